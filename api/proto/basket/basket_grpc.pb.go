@@ -19,11 +19,29 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	BasketService_GetBasket_FullMethodName      = "/basket.BasketService/GetBasket"
-	BasketService_AddItem_FullMethodName        = "/basket.BasketService/AddItem"
-	BasketService_RemoveItem_FullMethodName     = "/basket.BasketService/RemoveItem"
-	BasketService_UpdateQuantity_FullMethodName = "/basket.BasketService/UpdateQuantity"
-	BasketService_ClearBasket_FullMethodName    = "/basket.BasketService/ClearBasket"
+	BasketService_GetBasket_FullMethodName               = "/basket.BasketService/GetBasket"
+	BasketService_AddItem_FullMethodName                 = "/basket.BasketService/AddItem"
+	BasketService_RemoveItem_FullMethodName              = "/basket.BasketService/RemoveItem"
+	BasketService_UpdateQuantity_FullMethodName          = "/basket.BasketService/UpdateQuantity"
+	BasketService_BatchUpdateBasket_FullMethodName       = "/basket.BasketService/BatchUpdateBasket"
+	BasketService_ClearBasket_FullMethodName             = "/basket.BasketService/ClearBasket"
+	BasketService_ValidateBasket_FullMethodName          = "/basket.BasketService/ValidateBasket"
+	BasketService_MergeBaskets_FullMethodName            = "/basket.BasketService/MergeBaskets"
+	BasketService_ApplyCoupon_FullMethodName             = "/basket.BasketService/ApplyCoupon"
+	BasketService_RemoveCoupon_FullMethodName            = "/basket.BasketService/RemoveCoupon"
+	BasketService_CreateCoupon_FullMethodName            = "/basket.BasketService/CreateCoupon"
+	BasketService_SetDestination_FullMethodName          = "/basket.BasketService/SetDestination"
+	BasketService_GetLists_FullMethodName                = "/basket.BasketService/GetLists"
+	BasketService_CreateList_FullMethodName              = "/basket.BasketService/CreateList"
+	BasketService_DeleteList_FullMethodName              = "/basket.BasketService/DeleteList"
+	BasketService_AddToList_FullMethodName               = "/basket.BasketService/AddToList"
+	BasketService_RemoveFromList_FullMethodName          = "/basket.BasketService/RemoveFromList"
+	BasketService_MoveToList_FullMethodName              = "/basket.BasketService/MoveToList"
+	BasketService_MoveToBasket_FullMethodName            = "/basket.BasketService/MoveToBasket"
+	BasketService_Checkout_FullMethodName                = "/basket.BasketService/Checkout"
+	BasketService_AcknowledgePriceChanges_FullMethodName = "/basket.BasketService/AcknowledgePriceChanges"
+	BasketService_GetBasketHistory_FullMethodName        = "/basket.BasketService/GetBasketHistory"
+	BasketService_WatchBasket_FullMethodName             = "/basket.BasketService/WatchBasket"
 )
 
 // BasketServiceClient is the client API for BasketService service.
@@ -34,7 +52,25 @@ type BasketServiceClient interface {
 	AddItem(ctx context.Context, in *AddItemRequest, opts ...grpc.CallOption) (*AddItemResponse, error)
 	RemoveItem(ctx context.Context, in *RemoveItemRequest, opts ...grpc.CallOption) (*RemoveItemResponse, error)
 	UpdateQuantity(ctx context.Context, in *UpdateQuantityRequest, opts ...grpc.CallOption) (*UpdateQuantityResponse, error)
+	BatchUpdateBasket(ctx context.Context, in *BatchUpdateBasketRequest, opts ...grpc.CallOption) (*BatchUpdateBasketResponse, error)
 	ClearBasket(ctx context.Context, in *ClearBasketRequest, opts ...grpc.CallOption) (*ClearBasketResponse, error)
+	ValidateBasket(ctx context.Context, in *ValidateBasketRequest, opts ...grpc.CallOption) (*ValidateBasketResponse, error)
+	MergeBaskets(ctx context.Context, in *MergeBasketsRequest, opts ...grpc.CallOption) (*MergeBasketsResponse, error)
+	ApplyCoupon(ctx context.Context, in *ApplyCouponRequest, opts ...grpc.CallOption) (*ApplyCouponResponse, error)
+	RemoveCoupon(ctx context.Context, in *RemoveCouponRequest, opts ...grpc.CallOption) (*RemoveCouponResponse, error)
+	CreateCoupon(ctx context.Context, in *CreateCouponRequest, opts ...grpc.CallOption) (*CreateCouponResponse, error)
+	SetDestination(ctx context.Context, in *SetDestinationRequest, opts ...grpc.CallOption) (*SetDestinationResponse, error)
+	GetLists(ctx context.Context, in *GetListsRequest, opts ...grpc.CallOption) (*GetListsResponse, error)
+	CreateList(ctx context.Context, in *CreateListRequest, opts ...grpc.CallOption) (*CreateListResponse, error)
+	DeleteList(ctx context.Context, in *DeleteListRequest, opts ...grpc.CallOption) (*DeleteListResponse, error)
+	AddToList(ctx context.Context, in *AddToListRequest, opts ...grpc.CallOption) (*AddToListResponse, error)
+	RemoveFromList(ctx context.Context, in *RemoveFromListRequest, opts ...grpc.CallOption) (*RemoveFromListResponse, error)
+	MoveToList(ctx context.Context, in *MoveToListRequest, opts ...grpc.CallOption) (*MoveToListResponse, error)
+	MoveToBasket(ctx context.Context, in *MoveToBasketRequest, opts ...grpc.CallOption) (*MoveToBasketResponse, error)
+	Checkout(ctx context.Context, in *CheckoutRequest, opts ...grpc.CallOption) (*CheckoutResponse, error)
+	AcknowledgePriceChanges(ctx context.Context, in *AcknowledgePriceChangesRequest, opts ...grpc.CallOption) (*AcknowledgePriceChangesResponse, error)
+	GetBasketHistory(ctx context.Context, in *GetBasketHistoryRequest, opts ...grpc.CallOption) (*GetBasketHistoryResponse, error)
+	WatchBasket(ctx context.Context, in *WatchBasketRequest, opts ...grpc.CallOption) (BasketService_WatchBasketClient, error)
 }
 
 type basketServiceClient struct {
@@ -81,6 +117,15 @@ func (c *basketServiceClient) UpdateQuantity(ctx context.Context, in *UpdateQuan
 	return out, nil
 }
 
+func (c *basketServiceClient) BatchUpdateBasket(ctx context.Context, in *BatchUpdateBasketRequest, opts ...grpc.CallOption) (*BatchUpdateBasketResponse, error) {
+	out := new(BatchUpdateBasketResponse)
+	err := c.cc.Invoke(ctx, BasketService_BatchUpdateBasket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *basketServiceClient) ClearBasket(ctx context.Context, in *ClearBasketRequest, opts ...grpc.CallOption) (*ClearBasketResponse, error) {
 	out := new(ClearBasketResponse)
 	err := c.cc.Invoke(ctx, BasketService_ClearBasket_FullMethodName, in, out, opts...)
@@ -90,6 +135,182 @@ func (c *basketServiceClient) ClearBasket(ctx context.Context, in *ClearBasketRe
 	return out, nil
 }
 
+func (c *basketServiceClient) ValidateBasket(ctx context.Context, in *ValidateBasketRequest, opts ...grpc.CallOption) (*ValidateBasketResponse, error) {
+	out := new(ValidateBasketResponse)
+	err := c.cc.Invoke(ctx, BasketService_ValidateBasket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) MergeBaskets(ctx context.Context, in *MergeBasketsRequest, opts ...grpc.CallOption) (*MergeBasketsResponse, error) {
+	out := new(MergeBasketsResponse)
+	err := c.cc.Invoke(ctx, BasketService_MergeBaskets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) ApplyCoupon(ctx context.Context, in *ApplyCouponRequest, opts ...grpc.CallOption) (*ApplyCouponResponse, error) {
+	out := new(ApplyCouponResponse)
+	err := c.cc.Invoke(ctx, BasketService_ApplyCoupon_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) RemoveCoupon(ctx context.Context, in *RemoveCouponRequest, opts ...grpc.CallOption) (*RemoveCouponResponse, error) {
+	out := new(RemoveCouponResponse)
+	err := c.cc.Invoke(ctx, BasketService_RemoveCoupon_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) CreateCoupon(ctx context.Context, in *CreateCouponRequest, opts ...grpc.CallOption) (*CreateCouponResponse, error) {
+	out := new(CreateCouponResponse)
+	err := c.cc.Invoke(ctx, BasketService_CreateCoupon_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) SetDestination(ctx context.Context, in *SetDestinationRequest, opts ...grpc.CallOption) (*SetDestinationResponse, error) {
+	out := new(SetDestinationResponse)
+	err := c.cc.Invoke(ctx, BasketService_SetDestination_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) GetLists(ctx context.Context, in *GetListsRequest, opts ...grpc.CallOption) (*GetListsResponse, error) {
+	out := new(GetListsResponse)
+	err := c.cc.Invoke(ctx, BasketService_GetLists_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) CreateList(ctx context.Context, in *CreateListRequest, opts ...grpc.CallOption) (*CreateListResponse, error) {
+	out := new(CreateListResponse)
+	err := c.cc.Invoke(ctx, BasketService_CreateList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) DeleteList(ctx context.Context, in *DeleteListRequest, opts ...grpc.CallOption) (*DeleteListResponse, error) {
+	out := new(DeleteListResponse)
+	err := c.cc.Invoke(ctx, BasketService_DeleteList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) AddToList(ctx context.Context, in *AddToListRequest, opts ...grpc.CallOption) (*AddToListResponse, error) {
+	out := new(AddToListResponse)
+	err := c.cc.Invoke(ctx, BasketService_AddToList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) RemoveFromList(ctx context.Context, in *RemoveFromListRequest, opts ...grpc.CallOption) (*RemoveFromListResponse, error) {
+	out := new(RemoveFromListResponse)
+	err := c.cc.Invoke(ctx, BasketService_RemoveFromList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) MoveToList(ctx context.Context, in *MoveToListRequest, opts ...grpc.CallOption) (*MoveToListResponse, error) {
+	out := new(MoveToListResponse)
+	err := c.cc.Invoke(ctx, BasketService_MoveToList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) MoveToBasket(ctx context.Context, in *MoveToBasketRequest, opts ...grpc.CallOption) (*MoveToBasketResponse, error) {
+	out := new(MoveToBasketResponse)
+	err := c.cc.Invoke(ctx, BasketService_MoveToBasket_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) Checkout(ctx context.Context, in *CheckoutRequest, opts ...grpc.CallOption) (*CheckoutResponse, error) {
+	out := new(CheckoutResponse)
+	err := c.cc.Invoke(ctx, BasketService_Checkout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) AcknowledgePriceChanges(ctx context.Context, in *AcknowledgePriceChangesRequest, opts ...grpc.CallOption) (*AcknowledgePriceChangesResponse, error) {
+	out := new(AcknowledgePriceChangesResponse)
+	err := c.cc.Invoke(ctx, BasketService_AcknowledgePriceChanges_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) GetBasketHistory(ctx context.Context, in *GetBasketHistoryRequest, opts ...grpc.CallOption) (*GetBasketHistoryResponse, error) {
+	out := new(GetBasketHistoryResponse)
+	err := c.cc.Invoke(ctx, BasketService_GetBasketHistory_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *basketServiceClient) WatchBasket(ctx context.Context, in *WatchBasketRequest, opts ...grpc.CallOption) (BasketService_WatchBasketClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BasketService_ServiceDesc.Streams[0], BasketService_WatchBasket_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &basketServiceWatchBasketClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BasketService_WatchBasketClient interface {
+	Recv() (*BasketUpdate, error)
+	grpc.ClientStream
+}
+
+type basketServiceWatchBasketClient struct {
+	grpc.ClientStream
+}
+
+func (x *basketServiceWatchBasketClient) Recv() (*BasketUpdate, error) {
+	m := new(BasketUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // BasketServiceServer is the server API for BasketService service.
 // All implementations must embed UnimplementedBasketServiceServer
 // for forward compatibility
@@ -98,7 +319,25 @@ type BasketServiceServer interface {
 	AddItem(context.Context, *AddItemRequest) (*AddItemResponse, error)
 	RemoveItem(context.Context, *RemoveItemRequest) (*RemoveItemResponse, error)
 	UpdateQuantity(context.Context, *UpdateQuantityRequest) (*UpdateQuantityResponse, error)
+	BatchUpdateBasket(context.Context, *BatchUpdateBasketRequest) (*BatchUpdateBasketResponse, error)
 	ClearBasket(context.Context, *ClearBasketRequest) (*ClearBasketResponse, error)
+	ValidateBasket(context.Context, *ValidateBasketRequest) (*ValidateBasketResponse, error)
+	MergeBaskets(context.Context, *MergeBasketsRequest) (*MergeBasketsResponse, error)
+	ApplyCoupon(context.Context, *ApplyCouponRequest) (*ApplyCouponResponse, error)
+	RemoveCoupon(context.Context, *RemoveCouponRequest) (*RemoveCouponResponse, error)
+	CreateCoupon(context.Context, *CreateCouponRequest) (*CreateCouponResponse, error)
+	SetDestination(context.Context, *SetDestinationRequest) (*SetDestinationResponse, error)
+	GetLists(context.Context, *GetListsRequest) (*GetListsResponse, error)
+	CreateList(context.Context, *CreateListRequest) (*CreateListResponse, error)
+	DeleteList(context.Context, *DeleteListRequest) (*DeleteListResponse, error)
+	AddToList(context.Context, *AddToListRequest) (*AddToListResponse, error)
+	RemoveFromList(context.Context, *RemoveFromListRequest) (*RemoveFromListResponse, error)
+	MoveToList(context.Context, *MoveToListRequest) (*MoveToListResponse, error)
+	MoveToBasket(context.Context, *MoveToBasketRequest) (*MoveToBasketResponse, error)
+	Checkout(context.Context, *CheckoutRequest) (*CheckoutResponse, error)
+	AcknowledgePriceChanges(context.Context, *AcknowledgePriceChangesRequest) (*AcknowledgePriceChangesResponse, error)
+	GetBasketHistory(context.Context, *GetBasketHistoryRequest) (*GetBasketHistoryResponse, error)
+	WatchBasket(*WatchBasketRequest, BasketService_WatchBasketServer) error
 	mustEmbedUnimplementedBasketServiceServer()
 }
 
@@ -118,9 +357,63 @@ func (UnimplementedBasketServiceServer) RemoveItem(context.Context, *RemoveItemR
 func (UnimplementedBasketServiceServer) UpdateQuantity(context.Context, *UpdateQuantityRequest) (*UpdateQuantityResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateQuantity not implemented")
 }
+func (UnimplementedBasketServiceServer) BatchUpdateBasket(context.Context, *BatchUpdateBasketRequest) (*BatchUpdateBasketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchUpdateBasket not implemented")
+}
 func (UnimplementedBasketServiceServer) ClearBasket(context.Context, *ClearBasketRequest) (*ClearBasketResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ClearBasket not implemented")
 }
+func (UnimplementedBasketServiceServer) ValidateBasket(context.Context, *ValidateBasketRequest) (*ValidateBasketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidateBasket not implemented")
+}
+func (UnimplementedBasketServiceServer) MergeBaskets(context.Context, *MergeBasketsRequest) (*MergeBasketsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeBaskets not implemented")
+}
+func (UnimplementedBasketServiceServer) ApplyCoupon(context.Context, *ApplyCouponRequest) (*ApplyCouponResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyCoupon not implemented")
+}
+func (UnimplementedBasketServiceServer) RemoveCoupon(context.Context, *RemoveCouponRequest) (*RemoveCouponResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveCoupon not implemented")
+}
+func (UnimplementedBasketServiceServer) CreateCoupon(context.Context, *CreateCouponRequest) (*CreateCouponResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCoupon not implemented")
+}
+func (UnimplementedBasketServiceServer) SetDestination(context.Context, *SetDestinationRequest) (*SetDestinationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetDestination not implemented")
+}
+func (UnimplementedBasketServiceServer) GetLists(context.Context, *GetListsRequest) (*GetListsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLists not implemented")
+}
+func (UnimplementedBasketServiceServer) CreateList(context.Context, *CreateListRequest) (*CreateListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateList not implemented")
+}
+func (UnimplementedBasketServiceServer) DeleteList(context.Context, *DeleteListRequest) (*DeleteListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteList not implemented")
+}
+func (UnimplementedBasketServiceServer) AddToList(context.Context, *AddToListRequest) (*AddToListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddToList not implemented")
+}
+func (UnimplementedBasketServiceServer) RemoveFromList(context.Context, *RemoveFromListRequest) (*RemoveFromListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveFromList not implemented")
+}
+func (UnimplementedBasketServiceServer) MoveToList(context.Context, *MoveToListRequest) (*MoveToListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveToList not implemented")
+}
+func (UnimplementedBasketServiceServer) MoveToBasket(context.Context, *MoveToBasketRequest) (*MoveToBasketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveToBasket not implemented")
+}
+func (UnimplementedBasketServiceServer) Checkout(context.Context, *CheckoutRequest) (*CheckoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Checkout not implemented")
+}
+func (UnimplementedBasketServiceServer) AcknowledgePriceChanges(context.Context, *AcknowledgePriceChangesRequest) (*AcknowledgePriceChangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcknowledgePriceChanges not implemented")
+}
+func (UnimplementedBasketServiceServer) GetBasketHistory(context.Context, *GetBasketHistoryRequest) (*GetBasketHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBasketHistory not implemented")
+}
+func (UnimplementedBasketServiceServer) WatchBasket(*WatchBasketRequest, BasketService_WatchBasketServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchBasket not implemented")
+}
 func (UnimplementedBasketServiceServer) mustEmbedUnimplementedBasketServiceServer() {}
 
 // UnsafeBasketServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -206,6 +499,24 @@ func _BasketService_UpdateQuantity_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BasketService_BatchUpdateBasket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchUpdateBasketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).BatchUpdateBasket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_BatchUpdateBasket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).BatchUpdateBasket(ctx, req.(*BatchUpdateBasketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _BasketService_ClearBasket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ClearBasketRequest)
 	if err := dec(in); err != nil {
@@ -224,6 +535,315 @@ func _BasketService_ClearBasket_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BasketService_ValidateBasket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateBasketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).ValidateBasket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_ValidateBasket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).ValidateBasket(ctx, req.(*ValidateBasketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_MergeBaskets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeBasketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).MergeBaskets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_MergeBaskets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).MergeBaskets(ctx, req.(*MergeBasketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_ApplyCoupon_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyCouponRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).ApplyCoupon(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_ApplyCoupon_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).ApplyCoupon(ctx, req.(*ApplyCouponRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_RemoveCoupon_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveCouponRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).RemoveCoupon(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_RemoveCoupon_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).RemoveCoupon(ctx, req.(*RemoveCouponRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_CreateCoupon_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCouponRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).CreateCoupon(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_CreateCoupon_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).CreateCoupon(ctx, req.(*CreateCouponRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_SetDestination_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetDestinationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).SetDestination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_SetDestination_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).SetDestination(ctx, req.(*SetDestinationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_GetLists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetListsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).GetLists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_GetLists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).GetLists(ctx, req.(*GetListsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_CreateList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).CreateList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_CreateList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).CreateList(ctx, req.(*CreateListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_DeleteList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).DeleteList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_DeleteList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).DeleteList(ctx, req.(*DeleteListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_AddToList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddToListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).AddToList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_AddToList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).AddToList(ctx, req.(*AddToListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_RemoveFromList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveFromListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).RemoveFromList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_RemoveFromList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).RemoveFromList(ctx, req.(*RemoveFromListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_MoveToList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveToListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).MoveToList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_MoveToList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).MoveToList(ctx, req.(*MoveToListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_MoveToBasket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveToBasketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).MoveToBasket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_MoveToBasket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).MoveToBasket(ctx, req.(*MoveToBasketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_Checkout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).Checkout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_Checkout_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).Checkout(ctx, req.(*CheckoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_AcknowledgePriceChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AcknowledgePriceChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).AcknowledgePriceChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_AcknowledgePriceChanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).AcknowledgePriceChanges(ctx, req.(*AcknowledgePriceChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_GetBasketHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBasketHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BasketServiceServer).GetBasketHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BasketService_GetBasketHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BasketServiceServer).GetBasketHistory(ctx, req.(*GetBasketHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BasketService_WatchBasket_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchBasketRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BasketServiceServer).WatchBasket(m, &basketServiceWatchBasketServer{stream})
+}
+
+type BasketService_WatchBasketServer interface {
+	Send(*BasketUpdate) error
+	grpc.ServerStream
+}
+
+type basketServiceWatchBasketServer struct {
+	grpc.ServerStream
+}
+
+func (x *basketServiceWatchBasketServer) Send(m *BasketUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // BasketService_ServiceDesc is the grpc.ServiceDesc for BasketService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -247,11 +867,85 @@ var BasketService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateQuantity",
 			Handler:    _BasketService_UpdateQuantity_Handler,
 		},
+		{
+			MethodName: "BatchUpdateBasket",
+			Handler:    _BasketService_BatchUpdateBasket_Handler,
+		},
 		{
 			MethodName: "ClearBasket",
 			Handler:    _BasketService_ClearBasket_Handler,
 		},
+		{
+			MethodName: "ValidateBasket",
+			Handler:    _BasketService_ValidateBasket_Handler,
+		},
+		{
+			MethodName: "MergeBaskets",
+			Handler:    _BasketService_MergeBaskets_Handler,
+		},
+		{
+			MethodName: "ApplyCoupon",
+			Handler:    _BasketService_ApplyCoupon_Handler,
+		},
+		{
+			MethodName: "RemoveCoupon",
+			Handler:    _BasketService_RemoveCoupon_Handler,
+		},
+		{
+			MethodName: "CreateCoupon",
+			Handler:    _BasketService_CreateCoupon_Handler,
+		},
+		{
+			MethodName: "SetDestination",
+			Handler:    _BasketService_SetDestination_Handler,
+		},
+		{
+			MethodName: "GetLists",
+			Handler:    _BasketService_GetLists_Handler,
+		},
+		{
+			MethodName: "CreateList",
+			Handler:    _BasketService_CreateList_Handler,
+		},
+		{
+			MethodName: "DeleteList",
+			Handler:    _BasketService_DeleteList_Handler,
+		},
+		{
+			MethodName: "AddToList",
+			Handler:    _BasketService_AddToList_Handler,
+		},
+		{
+			MethodName: "RemoveFromList",
+			Handler:    _BasketService_RemoveFromList_Handler,
+		},
+		{
+			MethodName: "MoveToList",
+			Handler:    _BasketService_MoveToList_Handler,
+		},
+		{
+			MethodName: "MoveToBasket",
+			Handler:    _BasketService_MoveToBasket_Handler,
+		},
+		{
+			MethodName: "Checkout",
+			Handler:    _BasketService_Checkout_Handler,
+		},
+		{
+			MethodName: "AcknowledgePriceChanges",
+			Handler:    _BasketService_AcknowledgePriceChanges_Handler,
+		},
+		{
+			MethodName: "GetBasketHistory",
+			Handler:    _BasketService_GetBasketHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchBasket",
+			Handler:       _BasketService_WatchBasket_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/proto/basket/basket.proto",
 }
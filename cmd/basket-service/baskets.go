@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"daprps/api/proto/basket"
+	"daprps/internal/basket-service/service"
+)
+
+// itemRequest is the JSON body accepted by PUT /v1/baskets/{user}/items/{product}.
+type itemRequest struct {
+	Quantity int32 `json:"quantity"`
+	Clamp    bool  `json:"clamp_to_available"`
+}
+
+// batchRequest is the JSON body accepted by POST /v1/baskets/{user}/batch.
+type batchRequest struct {
+	Operations []struct {
+		Type      string `json:"type"`
+		ProductID string `json:"product_id"`
+		Quantity  int32  `json:"quantity"`
+	} `json:"operations"`
+	Clamp bool `json:"clamp_to_available"`
+}
+
+// basketResponse is a service response that carries a basket.
+type basketResponse interface {
+	GetBasket() *basket.Basket
+}
+
+// registerBasketRoutes adds the resource-style basket endpoints to mux:
+//
+//	GET    /v1/baskets/{user}                  get the basket
+//	DELETE /v1/baskets/{user}                  clear the basket
+//	PUT    /v1/baskets/{user}/items/{product}  set the quantity of a line, adding it if needed
+//	DELETE /v1/baskets/{user}/items/{product}  remove a line
+//	GET    /v1/baskets/{user}/history          changes of the basket, ?as_of= rebuilds it as of a time
+//	POST   /v1/baskets/{user}/batch            apply add, remove and set_quantity operations at once
+//	GET    /v1/baskets/{user}/watch            server-sent events with the basket after every change
+//
+// Responses carry the basket version as ETag. Mutations accept it back in
+// If-Match and fail with 412 Precondition Failed if the basket has changed
+// since; GET answers 304 Not Modified if it matches If-None-Match.
+//
+// More specific paths registered on mux, such as /v1/baskets/add, take
+// precedence over these routes.
+func registerBasketRoutes(mux *http.ServeMux, basketService *service.BasketService) {
+	mux.HandleFunc("/v1/baskets/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/baskets/"), "/")
+		userID := parts[0]
+		if userID == "" {
+			http.Error(w, "User ID required", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			handleBasket(w, r, basketService, userID)
+		case len(parts) == 2 && parts[1] == "history":
+			handleBasketHistory(w, r, basketService, userID)
+		case len(parts) == 2 && parts[1] == "batch":
+			handleBasketBatch(w, r, basketService, userID)
+		case len(parts) == 2 && parts[1] == "watch":
+			handleBasketWatch(w, r, basketService, userID)
+		case len(parts) == 3 && parts[1] == "items" && parts[2] != "":
+			handleBasketItem(w, r, basketService, userID, parts[2])
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func handleBasket(w http.ResponseWriter, r *http.Request, basketService *service.BasketService, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		resp, err := basketService.GetBasket(r.Context(), &basket.GetBasketRequest{
+			UserId:           userID,
+			RevalidatePrices: r.URL.Query().Get("revalidate") == "true",
+		})
+		if err == nil && r.Header.Get("If-None-Match") == etag(resp.Basket) {
+			setETag(w, resp)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		setETag(w, resp)
+		writeResponse(w, resp, err)
+	case http.MethodDelete:
+		version, ok := expectedVersion(w, r)
+		if !ok {
+			return
+		}
+		resp, err := basketService.ClearBasket(r.Context(), &basket.ClearBasketRequest{
+			UserId:          userID,
+			ExpectedVersion: version,
+		})
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+		setETag(w, resp)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleBasketHistory(w http.ResponseWriter, r *http.Request, basketService *service.BasketService, userID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp, err := basketService.GetBasketHistory(r.Context(), &basket.GetBasketHistoryRequest{
+		UserId: userID,
+		AsOf:   r.URL.Query().Get("as_of"),
+	})
+	writeResponse(w, resp, err)
+}
+
+// handleBasketWatch streams the basket as server-sent events, starting with a
+// snapshot. The event ID is the basket version. A comment is sent on every
+// resync without a change so that proxies do not close idle streams. Errors
+// before the first event are answered like other requests.
+func handleBasketWatch(w http.ResponseWriter, r *http.Request, basketService *service.BasketService, userID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	started := false
+	err := basketService.Watch(r.Context(), &basket.WatchBasketRequest{UserId: userID}, func(update *basket.BasketUpdate) error {
+		data, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", update.Basket.Version, update.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && !started {
+		writeResponse(w, nil, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Basket watch for user %s ended: %v", userID, err)
+	}
+}
+
+// handleBasketBatch answers 409 Conflict, with the result of every
+// operation, if any operation failed and the basket was left unchanged.
+func handleBasketBatch(w http.ResponseWriter, r *http.Request, basketService *service.BasketService, userID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	version, ok := expectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	batch := &basket.BatchUpdateBasketRequest{
+		UserId:           userID,
+		ClampToAvailable: req.Clamp,
+		ExpectedVersion:  version,
+	}
+	for _, op := range req.Operations {
+		batch.Operations = append(batch.Operations, &basket.BasketOperation{
+			Type:      op.Type,
+			ProductId: op.ProductID,
+			Quantity:  op.Quantity,
+		})
+	}
+
+	resp, err := basketService.BatchUpdateBasket(r.Context(), batch)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	setETag(w, resp)
+	if !resp.Success {
+		w.WriteHeader(http.StatusConflict)
+	}
+	writeResponse(w, resp, nil)
+}
+
+func handleBasketItem(w http.ResponseWriter, r *http.Request, basketService *service.BasketService, userID, productID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var req itemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Quantity <= 0 {
+			http.Error(w, "quantity must be positive, use DELETE to remove an item", http.StatusBadRequest)
+			return
+		}
+		version, ok := expectedVersion(w, r)
+		if !ok {
+			return
+		}
+
+		// Set the quantity, adding the line if it is missing, in one update
+		// so that concurrent PUTs cannot both add the product
+		resp, err := basketService.UpdateQuantity(r.Context(), &basket.UpdateQuantityRequest{
+			UserId:           userID,
+			ProductId:        productID,
+			Quantity:         req.Quantity,
+			ClampToAvailable: req.Clamp,
+			ExpectedVersion:  version,
+			AddMissing:       true,
+		})
+		setETag(w, resp)
+		if err == nil && resp.Added {
+			w.WriteHeader(http.StatusCreated)
+		}
+		writeResponse(w, resp, err)
+	case http.MethodDelete:
+		version, ok := expectedVersion(w, r)
+		if !ok {
+			return
+		}
+		resp, err := basketService.RemoveItem(r.Context(), &basket.RemoveItemRequest{
+			UserId:          userID,
+			ProductId:       productID,
+			ExpectedVersion: version,
+		})
+		setETag(w, resp)
+		writeResponse(w, resp, err)
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// expectedVersion parses the basket version a mutation expects from the
+// If-Match header. A missing header or "*" expects no particular version.
+// Invalid headers are answered with 400 and reported as not ok.
+func expectedVersion(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	version, err := parseETag(header)
+	if err != nil {
+		http.Error(w, "If-Match must be a basket ETag", http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+func parseETag(value string) (int64, error) {
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(value, "W/"))
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if version <= 0 {
+		return 0, errors.New("basket versions start at 1")
+	}
+	return version, nil
+}
+
+// etag formats the version of a basket as an HTTP entity tag.
+func etag(b *basket.Basket) string {
+	return strconv.Quote(strconv.FormatInt(b.GetVersion(), 10))
+}
+
+// setETag sets the ETag header from the basket of a response, if any.
+func setETag(w http.ResponseWriter, resp basketResponse) {
+	if b := resp.GetBasket(); b != nil {
+		w.Header().Set("ETag", etag(b))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"daprps/api/proto/basket"
+	"daprps/internal/basket-service/service"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// listRequest is the JSON body accepted by the list endpoints.
+type listRequest struct {
+	UserID    string `json:"user_id"`
+	ListID    string `json:"list_id"`
+	Name      string `json:"name"`
+	ProductID string `json:"product_id"`
+	Quantity  int32  `json:"quantity"`
+}
+
+// registerListRoutes adds the saved list endpoints to mux.
+func registerListRoutes(mux *http.ServeMux, basketService *service.BasketService) {
+	// Get all lists of a user
+	mux.HandleFunc("/v1/lists/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID := r.URL.Path[len("/v1/lists/"):]
+		if userID == "" {
+			http.Error(w, "User ID required", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := basketService.GetLists(context.Background(), &basket.GetListsRequest{UserId: userID})
+		writeResponse(w, resp, err)
+	})
+
+	handleListPost(mux, "/v1/lists", func(req *listRequest) (interface{}, error) {
+		return basketService.CreateList(context.Background(), &basket.CreateListRequest{
+			UserId: req.UserID,
+			Name:   req.Name,
+		})
+	})
+
+	handleListPost(mux, "/v1/lists/delete", func(req *listRequest) (interface{}, error) {
+		return basketService.DeleteList(context.Background(), &basket.DeleteListRequest{
+			UserId: req.UserID,
+			ListId: req.ListID,
+		})
+	})
+
+	handleListPost(mux, "/v1/lists/add", func(req *listRequest) (interface{}, error) {
+		return basketService.AddToList(context.Background(), &basket.AddToListRequest{
+			UserId:    req.UserID,
+			ListId:    req.ListID,
+			ProductId: req.ProductID,
+			Quantity:  req.Quantity,
+		})
+	})
+
+	handleListPost(mux, "/v1/lists/remove", func(req *listRequest) (interface{}, error) {
+		return basketService.RemoveFromList(context.Background(), &basket.RemoveFromListRequest{
+			UserId:    req.UserID,
+			ListId:    req.ListID,
+			ProductId: req.ProductID,
+		})
+	})
+
+	handleListPost(mux, "/v1/lists/move-to-basket", func(req *listRequest) (interface{}, error) {
+		return basketService.MoveToBasket(context.Background(), &basket.MoveToBasketRequest{
+			UserId:    req.UserID,
+			ListId:    req.ListID,
+			ProductId: req.ProductID,
+		})
+	})
+
+	handleListPost(mux, "/v1/baskets/move-to-list", func(req *listRequest) (interface{}, error) {
+		return basketService.MoveToList(context.Background(), &basket.MoveToListRequest{
+			UserId:    req.UserID,
+			ListId:    req.ListID,
+			ProductId: req.ProductID,
+		})
+	})
+}
+
+// handleListPost registers a POST endpoint that decodes a listRequest and
+// writes the result of call as JSON.
+func handleListPost(mux *http.ServeMux, path string, call func(req *listRequest) (interface{}, error)) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req listRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := call(&req)
+		writeResponse(w, resp, err)
+	})
+}
+
+// writeResponse writes resp as JSON, or err with the HTTP status matching its
+// gRPC code.
+func writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition:
+		return http.StatusConflict
+	case codes.Aborted:
+		if versionMismatch(err) {
+			return http.StatusPreconditionFailed
+		}
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusUnprocessableEntity
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// versionMismatch reports whether err is a basket update that expected an
+// outdated version.
+func versionMismatch(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == "VERSION_MISMATCH" {
+			return true
+		}
+	}
+	return false
+}
@@ -8,14 +8,21 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"google.golang.org/grpc"
 
 	"daprps/api/proto/basket"
+	moneypb "daprps/api/proto/money"
+	"daprps/internal/basket-service/client"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/pricing"
 	"daprps/internal/basket-service/repository"
 	"daprps/internal/basket-service/service"
+	"daprps/internal/money"
 	"daprps/kafka/consumer"
+	"daprps/kafka/publisher"
 )
 
 func main() {
@@ -31,24 +38,143 @@ func main() {
 		redisDB = 0
 	}
 
-	// Redis connection
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisHost + ":" + redisPort,
-		Password: redisPassword,
-		DB:       redisDB,
-	})
+	// Basket storage backend: "redis", "dapr" or "memory". An unreachable
+	// Redis is fatal unless BASKET_STORE_FALLBACK=memory allows falling back
+	// to in-memory storage.
+	store := getEnv("BASKET_STORE", "redis")
+	fallback := getEnv("BASKET_STORE_FALLBACK", "")
+	if fallback != "" && fallback != "memory" {
+		log.Fatalf("Invalid BASKET_STORE_FALLBACK %q, must be memory or unset", fallback)
+	}
+	switch store {
+	case "memory", "dapr":
+	case "redis":
+		// Test Redis connection
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     redisHost + ":" + redisPort,
+			Password: redisPassword,
+			DB:       redisDB,
+		})
+		_, err = rdb.Ping(rdb.Context()).Result()
+		rdb.Close()
+		if err != nil {
+			if fallback != "memory" {
+				log.Fatalf("Redis not available: %v", err)
+			}
+			log.Printf("Warning: Redis not available, using in-memory storage: %v", err)
+			store = "memory"
+		}
+	default:
+		log.Fatalf("Invalid BASKET_STORE %q, must be redis, dapr or memory", store)
+	}
+
+	// Create product service client
+	productTimeout := getDuration("PRODUCT_SERVICE_TIMEOUT", 2*time.Second)
+	productRetries, err := strconv.Atoi(getEnv("PRODUCT_SERVICE_RETRIES", "2"))
+	if err != nil {
+		log.Printf("Invalid PRODUCT_SERVICE_RETRIES value, using 2: %v", err)
+		productRetries = 2
+	}
+	productClient, err := client.NewProductClient(getEnv("PRODUCT_SERVICE_ADDR", "localhost:50051"), productTimeout, productRetries)
+	if err != nil {
+		log.Fatalf("Failed to create product service client: %v", err)
+	}
+	defer productClient.Close()
+
+	// Create payment service client. A checkout holds the basket for
+	// service.CheckoutLockTTL, which must outlast the payment call.
+	paymentTimeout := getDuration("PAYMENT_SERVICE_TIMEOUT", 30*time.Second)
+	if paymentTimeout >= service.CheckoutLockTTL {
+		log.Fatalf("PAYMENT_SERVICE_TIMEOUT (%s) must be shorter than the checkout lock (%s)", paymentTimeout, service.CheckoutLockTTL)
+	}
+	paymentClient, err := client.NewPaymentClient(getEnv("PAYMENT_SERVICE_ADDR", "localhost:50052"), paymentTimeout)
+	if err != nil {
+		log.Fatalf("Failed to create payment service client: %v", err)
+	}
+	defer paymentClient.Close()
 
-	// Test Redis connection
-	ctx := rdb.Context()
-	_, err = rdb.Ping(ctx).Result()
+	// Load tax and shipping tables
+	rules := pricing.DefaultRules()
+	if path := os.Getenv("PRICING_RULES_FILE"); path != "" {
+		rules, err = pricing.LoadRules(path)
+		if err != nil {
+			log.Fatalf("Failed to load pricing rules: %v", err)
+		}
+	}
+	pipeline := pricing.NewPipeline(pricing.NewShippingTable(rules.Shipping), pricing.NewTaxTable(rules.Tax))
+
+	// Basket lifetime and abandonment detection
+	basketTTL := getDuration("BASKET_TTL", 24*time.Hour)
+	abandonAfter := getDuration("BASKET_ABANDON_AFTER", 2*time.Hour)
+	if abandonAfter >= basketTTL {
+		log.Fatalf("BASKET_ABANDON_AFTER (%s) must be shorter than BASKET_TTL (%s)", abandonAfter, basketTTL)
+	}
+
+	// Create Kafka publisher for basket events
+	basketPublisher, err := publisher.NewBasketPublisher()
 	if err != nil {
-		log.Printf("Warning: Redis not available, using in-memory storage: %v", err)
-		// In a real app, you might want to exit here
+		log.Printf("Warning: Kafka publisher not available, basket events will not be published: %v", err)
+	} else {
+		defer basketPublisher.Close()
+	}
+
+	// Which price basket lines are charged once the catalog price changes
+	pricePolicy, err := model.ParsePriceDriftPolicy(getEnv("PRICE_DRIFT_POLICY", model.PriceHonourSnapshot), getDuration("PRICE_SNAPSHOT_TTL", 24*time.Hour))
+	if err != nil {
+		log.Fatalf("Invalid PRICE_DRIFT_POLICY: %v", err)
+	}
+
+	// Limits on the size of a basket, 0 for none
+	maxValue, err := model.ParseValueLimits(getEnv("BASKET_MAX_VALUE", "0"))
+	if err != nil {
+		log.Fatalf("Invalid BASKET_MAX_VALUE: %v", err)
+	}
+	limits := model.BasketLimits{
+		MaxLines:    getInt("BASKET_MAX_LINES", 100),
+		MaxQuantity: int32(getInt("BASKET_MAX_QUANTITY", 999)),
+		MaxValue:    maxValue,
 	}
 
 	// Create repository and service
-	repo := repository.NewBasketRepository(redisHost+":"+redisPort, redisPassword, redisDB)
-	basketService := service.NewBasketService(repo)
+	historyRetention := getDuration("BASKET_HISTORY_RETENTION", 30*24*time.Hour)
+	if historyRetention < basketTTL {
+		log.Fatalf("BASKET_HISTORY_RETENTION (%s) must not be shorter than BASKET_TTL (%s)", historyRetention, basketTTL)
+	}
+	var repo model.BasketRepository
+	var coupons model.CouponRepository
+	var history model.BasketHistory
+	var watcher model.BasketWatcher
+	switch store {
+	case "memory":
+		memory := repository.NewMemoryBasketRepository(basketTTL, historyRetention)
+		repo = memory
+		coupons = repository.NewMemoryCouponRepository()
+		history = memory.(model.BasketHistory)
+		watcher = memory.(model.BasketWatcher)
+	case "dapr":
+		// Coupons stay in Redis; baskets, lists and histories go through the sidecar
+		sidecarURL := "http://localhost:" + getEnv("DAPR_HTTP_PORT", "3500")
+		repo = repository.NewDaprBasketRepository(sidecarURL, getEnv("DAPR_STATE_STORE", "redis-state"), basketTTL, historyRetention)
+		coupons = repository.NewCouponRepository(redisHost+":"+redisPort, redisPassword, redisDB)
+		history = repo.(model.BasketHistory)
+		// Baskets are watched in the Redis behind the state store, which prefixes their keys
+		watcher = repository.NewKeyspaceBasketWatcher(redisHost+":"+redisPort, redisPassword, redisDB, getEnv("DAPR_STATE_KEY_PREFIX", "dapr:||"))
+	default:
+		repo = repository.NewBasketRepository(redisHost+":"+redisPort, redisPassword, redisDB, basketTTL, historyRetention)
+		coupons = repository.NewCouponRepository(redisHost+":"+redisPort, redisPassword, redisDB)
+		history = repo.(model.BasketHistory)
+		watcher = repository.NewKeyspaceBasketWatcher(redisHost+":"+redisPort, redisPassword, redisDB, "")
+	}
+	basketService := service.NewBasketService(repo, productClient, coupons, pipeline, basketPublisher, paymentClient, pricePolicy, limits, history, watcher)
+
+	if basketPublisher != nil {
+		go basketService.RunAbandonmentScanner(context.Background(), service.AbandonmentConfig{
+			Threshold: abandonAfter,
+			TTL:       basketTTL,
+			Interval:  getDuration("BASKET_ABANDON_SCAN_INTERVAL", time.Minute),
+			BatchSize: 500,
+		})
+	}
 
 	// Create Kafka consumer
 	kafkaConsumer, err := consumer.NewPaymentConsumer(basketService)
@@ -65,6 +191,21 @@ func main() {
 		}
 	}()
 
+	// Consume product events so deleted products are flagged in baskets
+	productGroup := getEnv("KAFKA_PRODUCT_GROUP", "basket-product-group")
+	productConsumer, err := consumer.NewProductConsumer(basketService, productGroup)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka product consumer: %v", err)
+	}
+	defer productConsumer.Close()
+
+	go func() {
+		topics := []string{publisher.ProductUpdatedTopic}
+		if err := productConsumer.Start(context.Background(), topics); err != nil {
+			log.Printf("Kafka product consumer error: %v", err)
+		}
+	}()
+
 	// Create gRPC server
 	grpcServer := grpc.NewServer()
 	basket.RegisterBasketServiceServer(grpcServer, basketService)
@@ -94,34 +235,48 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 	})
 
-	// Get basket endpoint
-	mux.HandleFunc("/v1/baskets/", func(w http.ResponseWriter, r *http.Request) {
+	// Basket and basket item resources
+	registerBasketRoutes(mux, basketService)
+
+	// Add item to basket endpoint
+	mux.HandleFunc("/v1/baskets/add", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
-		if r.Method != http.MethodGet {
+		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// Extract user ID from URL
-		userID := r.URL.Path[len("/v1/baskets/"):]
-		if userID == "" {
-			http.Error(w, "User ID required", http.StatusBadRequest)
-			return
+		var req struct {
+			UserID    string `json:"user_id"`
+			ProductID string `json:"product_id"`
+			Quantity  int32  `json:"quantity"`
+			Clamp     bool   `json:"clamp_to_available"`
 		}
 
-		// Get basket
-		basket, err := basketService.GetBasket(context.Background(), &basket.GetBasketRequest{UserId: userID})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		version, ok := expectedVersion(w, r)
+		if !ok {
 			return
 		}
 
-		json.NewEncoder(w).Encode(basket)
+		// Add item to basket
+		resp, err := basketService.AddItem(r.Context(), &basket.AddItemRequest{
+			UserId:           req.UserID,
+			ProductId:        req.ProductID,
+			Quantity:         req.Quantity,
+			ClampToAvailable: req.Clamp,
+			ExpectedVersion:  version,
+		})
+		setETag(w, resp)
+		writeResponse(w, resp, err)
 	})
 
-	// Add item to basket endpoint
-	mux.HandleFunc("/v1/baskets/add", func(w http.ResponseWriter, r *http.Request) {
+	// Remove item from basket endpoint
+	mux.HandleFunc("/v1/baskets/remove", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		if r.Method != http.MethodPost {
@@ -132,30 +287,69 @@ func main() {
 		var req struct {
 			UserID    string `json:"user_id"`
 			ProductID string `json:"product_id"`
-			Quantity  int32  `json:"quantity"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		version, ok := expectedVersion(w, r)
+		if !ok {
+			return
+		}
 
-		// Add item to basket
-		basket, err := basketService.AddItem(context.Background(), &basket.AddItemRequest{
-			UserId:    req.UserID,
-			ProductId: req.ProductID,
-			Quantity:  req.Quantity,
+		// Remove item from basket
+		resp, err := basketService.RemoveItem(r.Context(), &basket.RemoveItemRequest{
+			UserId:          req.UserID,
+			ProductId:       req.ProductID,
+			ExpectedVersion: version,
 		})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		setETag(w, resp)
+		writeResponse(w, resp, err)
+	})
+
+	// Merge basket endpoint, used to move a guest basket into a user's basket on login
+	mux.HandleFunc("/v1/baskets/merge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			ToUserID string `json:"to_user_id"`
+			Policy   string `json:"policy"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		json.NewEncoder(w).Encode(basket)
+		// The source is always the guest basket issued by the gateway, never
+		// an ID from the body, so one user cannot drain another's basket
+		fromID := r.Header.Get("X-Basket-ID")
+		if fromID == "" {
+			if cookie, err := r.Cookie("basket_id"); err == nil {
+				fromID = cookie.Value
+			}
+		}
+		if !model.IsGuestBasket(fromID) {
+			http.Error(w, "A guest basket ID is required", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := basketService.MergeBaskets(r.Context(), &basket.MergeBasketsRequest{
+			FromUserId: fromID,
+			ToUserId:   req.ToUserID,
+			Policy:     req.Policy,
+		})
+		writeResponse(w, resp, err)
 	})
 
-	// Remove item from basket endpoint
-	mux.HandleFunc("/v1/baskets/remove", func(w http.ResponseWriter, r *http.Request) {
+	// Checkout endpoint, charges the basket through the payment service
+	mux.HandleFunc("/v1/baskets/checkout", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		if r.Method != http.MethodPost {
@@ -164,8 +358,13 @@ func main() {
 		}
 
 		var req struct {
-			UserID    string `json:"user_id"`
-			ProductID string `json:"product_id"`
+			UserID        string `json:"user_id"`
+			Currency      string `json:"currency"`
+			PaymentMethod string `json:"payment_method"`
+			CardNumber    string `json:"card_number"`
+			CardHolder    string `json:"card_holder"`
+			ExpiryDate    string `json:"expiry_date"`
+			CVV           string `json:"cvv"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -173,19 +372,52 @@ func main() {
 			return
 		}
 
-		// Remove item from basket
-		basket, err := basketService.RemoveItem(context.Background(), &basket.RemoveItemRequest{
-			UserId:    req.UserID,
-			ProductId: req.ProductID,
+		resp, err := basketService.Checkout(r.Context(), &basket.CheckoutRequest{
+			UserId:        req.UserID,
+			Currency:      req.Currency,
+			PaymentMethod: req.PaymentMethod,
+			CardNumber:    req.CardNumber,
+			CardHolder:    req.CardHolder,
+			ExpiryDate:    req.ExpiryDate,
+			Cvv:           req.CVV,
 		})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeResponse(w, resp, err)
+	})
+
+	// Acknowledge price changes endpoint
+	mux.HandleFunc("/v1/baskets/acknowledge-prices", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			UserID string                 `json:"user_id"`
+			Prices map[string]money.Money `json:"prices"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		json.NewEncoder(w).Encode(basket)
+		prices := make(map[string]*moneypb.Money, len(req.Prices))
+		for productID, price := range req.Prices {
+			prices[productID] = money.ToProto(price)
+		}
+
+		resp, err := basketService.AcknowledgePriceChanges(r.Context(), &basket.AcknowledgePriceChangesRequest{
+			UserId: req.UserID,
+			Prices: prices,
+		})
+		writeResponse(w, resp, err)
 	})
 
+	// Saved list endpoints
+	registerListRoutes(mux, basketService)
+
 	log.Printf("Basket service HTTP starting on :%s", httpPort)
 	if err := http.ListenAndServe(":"+httpPort, mux); err != nil {
 		log.Fatalf("Failed to serve HTTP: %v", err)
@@ -198,3 +430,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s value, using %s: %v", key, defaultValue, err)
+		return defaultValue
+	}
+	return d
+}
+
+func getInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s value, using %d: %v", key, defaultValue, err)
+		return defaultValue
+	}
+	return n
+}
@@ -14,6 +14,7 @@ import (
 	"gorm.io/gorm"
 
 	"daprps/api/proto/payment"
+	"daprps/internal/money"
 	"daprps/internal/payment-service/model"
 	"daprps/internal/payment-service/repository"
 	"daprps/internal/payment-service/service"
@@ -32,7 +33,9 @@ func main() {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		dbHost, dbUser, dbPassword, dbName, dbPort)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// Duplicate keys are translated so that concurrent charges of an order
+	// are recognised
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -42,6 +45,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	// Amounts used to be stored as a float in major units with a separate currency
+	if err := money.MigrateColumn(db, "payments", "amount", "amount_", "currency"); err != nil {
+		log.Fatalf("Failed to migrate payment amounts: %v", err)
+	}
 	log.Println("Database migrated successfully")
 
 	// Create Kafka publisher
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"daprps/internal/product-service/catalog"
+	"daprps/internal/product-service/repository"
+)
+
+// runImport implements `product-service import`.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	file := fs.String("file", "", "catalog file to import (- for stdin)")
+	format := fs.String("format", "", "input format: csv or jsonl (detected from extension by default)")
+	batchSize := fs.Int("batch-size", catalog.DefaultBatchSize, "number of products written per transaction")
+	dryRun := fs.Bool("dry-run", false, "validate and print the diff without writing")
+	fs.Parse(args)
+
+	if *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	inputFormat, err := catalog.DetectFormat(*format, *file)
+	if err != nil {
+		log.Fatalf("Invalid input: %v", err)
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *file, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	rows, errs := catalog.ReadRows(in, inputFormat)
+	if len(errs) > 0 {
+		exitWithErrors("Failed to parse catalog", errs)
+	}
+
+	// Through the cache so the shared Redis tier drops the imported products;
+	// watchers hear of them through the change log
+	repo := newCachedRepository(repository.NewProductRepository(openDatabase()))
+	importer := catalog.NewImporter(repo, *batchSize, *dryRun)
+
+	report, errs := importer.Import(rows)
+	if report != nil {
+		report.Write(os.Stdout)
+	}
+	if len(errs) > 0 {
+		exitWithErrors("Failed to import catalog", errs)
+	}
+}
+
+// runExport implements `product-service export`.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	file := fs.String("file", "-", "output file (- for stdout)")
+	format := fs.String("format", "", "output format: csv or jsonl (detected from extension by default)")
+	category := fs.String("category", "", "only export products in this category")
+	limit := fs.Int("limit", -1, "maximum number of products to export (-1 for all)")
+	offset := fs.Int("offset", 0, "number of products to skip")
+	fs.Parse(args)
+
+	if *file == "-" && *format == "" {
+		*format = catalog.FormatJSONLines
+	}
+	outputFormat, err := catalog.DetectFormat(*format, *file)
+	if err != nil {
+		log.Fatalf("Invalid output: %v", err)
+	}
+
+	repo := newCachedRepository(repository.NewProductRepository(openDatabase()))
+	products, err := repo.GetAll(*category, int32(*limit), int32(*offset))
+	if err != nil {
+		log.Fatalf("Failed to load products: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *file, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := catalog.WriteProducts(out, outputFormat, products); err != nil {
+		log.Fatalf("Failed to write products: %v", err)
+	}
+	log.Printf("Exported %d products", len(products))
+}
+
+func exitWithErrors(message string, errs []error) {
+	fmt.Fprintf(os.Stderr, "%s:\n", message)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+	os.Exit(1)
+}
@@ -8,45 +8,55 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-redis/redis/v8"
 	"google.golang.org/grpc"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
 	"daprps/api/proto/product"
+	"daprps/internal/money"
 	"daprps/internal/product-service/model"
 	"daprps/internal/product-service/repository"
 	"daprps/internal/product-service/service"
 	"daprps/kafka/consumer"
+	"daprps/kafka/publisher"
 )
 
 func main() {
-	// Get database configuration from environment variables
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPort := getEnv("DB_PORT", "5432")
-	dbUser := getEnv("DB_USER", "postgres")
-	dbPassword := getEnv("DB_PASSWORD", "postgres")
-	dbName := getEnv("DB_NAME", "productdb")
-
-	// Database connection
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		dbHost, dbUser, dbPassword, dbName, dbPort)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	// Catalog maintenance subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		}
 	}
 
-	// Auto migration
-	err = db.AutoMigrate(&model.Product{})
+	db := openDatabase()
+
+	// Create Kafka publisher for stock and product events
+	kafkaPublisher, err := publisher.NewProductPublisher()
 	if err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+		log.Fatalf("Failed to create Kafka publisher: %v", err)
 	}
-	log.Println("Database migrated successfully")
+	defer kafkaPublisher.Close()
 
 	// Create repository and service
-	repo := repository.NewProductRepository(db)
-	productService := service.NewProductService(repo)
+	repo := newCachedRepository(repository.NewProductRepository(db))
+	inventory := repository.NewInventoryRepository(db)
+	if err := inventory.EnsureDefaultWarehouse(); err != nil {
+		log.Fatalf("Failed to create default warehouse: %v", err)
+	}
+	productService := service.NewProductService(repo, inventory, repository.NewChangeRepository(db), kafkaPublisher)
+	go productService.RunWatcher(context.Background())
+	go productService.RunReservationExpiry(context.Background(), time.Minute)
 
 	// Create Kafka consumer for payment events
 	kafkaConsumer, err := consumer.NewPaymentConsumer(productService)
@@ -63,6 +73,22 @@ func main() {
 		}
 	}()
 
+	// Every replica consumes product events in its own group so that its
+	// cache is invalidated and its watchers read the change log at once
+	hostname, _ := os.Hostname()
+	watchGroup := getEnv("KAFKA_WATCH_GROUP", "product-watch-"+hostname)
+	productConsumer, err := consumer.NewProductConsumer(productService, watchGroup)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka product consumer: %v", err)
+	}
+	defer productConsumer.Close()
+
+	go func() {
+		if err := productConsumer.Start(context.Background(), productConsumer.Topics()); err != nil {
+			log.Printf("Kafka product consumer error: %v", err)
+		}
+	}()
+
 	// Create gRPC server
 	grpcServer := grpc.NewServer()
 	product.RegisterProductServiceServer(grpcServer, productService)
@@ -111,6 +137,67 @@ func main() {
 		json.NewEncoder(w).Encode(products)
 	})
 
+	// Product change stream (Server-Sent Events)
+	mux.HandleFunc("/v1/products/watch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		req := &product.WatchProductsRequest{
+			Category: r.URL.Query().Get("category"),
+		}
+		if ids := r.URL.Query().Get("ids"); ids != "" {
+			req.ProductIds = strings.Split(ids, ",")
+		}
+
+		// Browsers resend the last event ID when reconnecting
+		since := r.Header.Get("Last-Event-ID")
+		if since == "" {
+			since = r.URL.Query().Get("since")
+		}
+		if since != "" {
+			version, err := strconv.ParseInt(since, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid version", http.StatusBadRequest)
+				return
+			}
+			req.SinceVersion = version
+		}
+
+		if len(req.ProductIds) == 0 && req.Category == "" {
+			http.Error(w, "ids or category required", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		err := productService.Watch(r.Context(), req, func(event *product.ProductEvent) error {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Version, event.Type, data); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		})
+		if err != nil {
+			log.Printf("Product watch ended: %v", err)
+		}
+	})
+
 	// Product by ID endpoint
 	mux.HandleFunc("/v1/products/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -143,6 +230,77 @@ func main() {
 	}
 }
 
+func openDatabase() *gorm.DB {
+	// Get database configuration from environment variables
+	dbHost := getEnv("DB_HOST", "localhost")
+	dbPort := getEnv("DB_PORT", "5432")
+	dbUser := getEnv("DB_USER", "postgres")
+	dbPassword := getEnv("DB_PASSWORD", "postgres")
+	dbName := getEnv("DB_NAME", "productdb")
+
+	// Database connection
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		dbHost, dbUser, dbPassword, dbName, dbPort)
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	// Auto migration
+	err = db.AutoMigrate(&model.Product{}, &model.Warehouse{}, &model.WarehouseStock{}, &model.StockTransfer{}, &model.StockReservation{}, &model.ProductChange{})
+	if err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	// SKUs used to have a plain index, replaced by the unique one
+	if db.Migrator().HasIndex(&model.Product{}, "idx_products_sku") {
+		if err := db.Migrator().DropIndex(&model.Product{}, "idx_products_sku"); err != nil {
+			log.Fatalf("Failed to drop the old SKU index: %v", err)
+		}
+	}
+	// Prices used to be stored as a float in major units of the default currency
+	if err := money.MigrateColumn(db, "products", "price", "price_", ""); err != nil {
+		log.Fatalf("Failed to migrate product prices: %v", err)
+	}
+	log.Println("Database migrated successfully")
+
+	return db
+}
+
+// newCachedRepository wraps the repository in a read-through cache configured
+// from the environment. A Redis tier is only used when PRODUCT_CACHE_REDIS_ADDR is set.
+func newCachedRepository(repo model.ProductRepository) model.ProductRepository {
+	size, err := strconv.Atoi(getEnv("PRODUCT_CACHE_SIZE", "1000"))
+	if err != nil {
+		log.Printf("Invalid PRODUCT_CACHE_SIZE value, using 1000: %v", err)
+		size = 1000
+	}
+	ttl, err := time.ParseDuration(getEnv("PRODUCT_CACHE_TTL", "30s"))
+	if err != nil {
+		log.Printf("Invalid PRODUCT_CACHE_TTL value, using 30s: %v", err)
+		ttl = 30 * time.Second
+	}
+	redisTTL, err := time.ParseDuration(getEnv("PRODUCT_CACHE_REDIS_TTL", "5m"))
+	if err != nil {
+		log.Printf("Invalid PRODUCT_CACHE_REDIS_TTL value, using 5m: %v", err)
+		redisTTL = 5 * time.Minute
+	}
+
+	opts := repository.CacheOptions{
+		Size:     size,
+		TTL:      ttl,
+		RedisTTL: redisTTL,
+	}
+	if addr := getEnv("PRODUCT_CACHE_REDIS_ADDR", ""); addr != "" {
+		opts.Redis = redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: getEnv("PRODUCT_CACHE_REDIS_PASSWORD", ""),
+		})
+	}
+
+	return repository.NewCachedProductRepository(repo, opts)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
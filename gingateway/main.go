@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,6 +10,7 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,8 +18,9 @@ import (
 )
 
 type APIGateway struct {
-	router *mux.Router
-	client *http.Client
+	router  *mux.Router
+	handler http.Handler
+	client  *http.Client
 }
 
 type ServiceConfig struct {
@@ -39,7 +43,8 @@ func NewAPIGateway() *APIGateway {
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Origin", "Authorization", "Content-Type", "Accept"},
+		AllowedHeaders: []string{"Origin", "Authorization", "Content-Type", "Accept", "If-Match", "If-None-Match", basketIDHeader},
+		ExposedHeaders: []string{"ETag", basketIDHeader},
 	})
 
 	// Create HTTP client with timeout
@@ -56,12 +61,9 @@ func NewAPIGateway() *APIGateway {
 	gateway.setupRoutes()
 
 	// Apply CORS middleware
-	corsMiddleware.Handler(router)
+	gateway.handler = corsMiddleware.Handler(router)
 
-	return &APIGateway{
-		router: mux.NewRouter(),
-		client: client,
-	}
+	return gateway
 }
 
 func (g *APIGateway) setupRoutes() {
@@ -73,6 +75,7 @@ func (g *APIGateway) setupRoutes() {
 
 	// Product routes
 	apiV1.HandleFunc("/products", g.handleProducts).Methods("GET")
+	apiV1.HandleFunc("/products/watch", g.handleWatchProducts).Methods("GET")
 	apiV1.HandleFunc("/products/{id}", g.handleProductByID).Methods("GET")
 
 	// Payment routes
@@ -80,10 +83,27 @@ func (g *APIGateway) setupRoutes() {
 	apiV1.HandleFunc("/payments/{id}", g.handlePaymentByID).Methods("GET")
 
 	// Basket routes
-	apiV1.HandleFunc("/baskets/{user_id}", g.handleBasket).Methods("GET")
+	apiV1.HandleFunc("/basket", g.withBasketID(g.handleCurrentBasket)).Methods("GET")
+	apiV1.HandleFunc("/baskets/merge", g.withBasketID(g.handleMergeBaskets)).Methods("POST")
+	apiV1.HandleFunc("/baskets/{user_id}", g.handleBasket).Methods("GET", "DELETE")
+	apiV1.HandleFunc("/baskets/{user_id}/items/{product_id}", g.handleBasketItem).Methods("PUT", "DELETE")
+	apiV1.HandleFunc("/baskets/{user_id}/history", g.handleBasketHistory).Methods("GET")
+	apiV1.HandleFunc("/baskets/{user_id}/batch", g.handleBasketBatch).Methods("POST")
+	apiV1.HandleFunc("/baskets/{user_id}/watch", g.handleWatchBasket).Methods("GET")
 	apiV1.HandleFunc("/baskets/add", g.handleAddItem).Methods("POST")
+	apiV1.HandleFunc("/baskets/move-to-list", g.handleMoveToList).Methods("POST")
+	apiV1.HandleFunc("/baskets/checkout", g.handleCheckout).Methods("POST")
+	apiV1.HandleFunc("/baskets/acknowledge-prices", g.handleAcknowledgePrices).Methods("POST")
 	apiV1.HandleFunc("/baskets/remove", g.handleRemoveItem).Methods("POST")
 
+	// Saved list routes
+	apiV1.HandleFunc("/lists", g.handleListAction).Methods("POST")
+	apiV1.HandleFunc("/lists/delete", g.handleListAction).Methods("POST")
+	apiV1.HandleFunc("/lists/add", g.handleListAction).Methods("POST")
+	apiV1.HandleFunc("/lists/remove", g.handleListAction).Methods("POST")
+	apiV1.HandleFunc("/lists/move-to-basket", g.handleListAction).Methods("POST")
+	apiV1.HandleFunc("/lists/{user_id}", g.handleLists).Methods("GET")
+
 	// Metrics endpoint
 	g.router.HandleFunc("/metrics", g.handleMetrics).Methods("GET")
 }
@@ -119,6 +139,12 @@ func (g *APIGateway) handleProductByID(w http.ResponseWriter, r *http.Request) {
 	g.forwardRequest(w, r, targetURL)
 }
 
+func (g *APIGateway) handleWatchProducts(w http.ResponseWriter, r *http.Request) {
+	// Forward to product service, keeping the watch filters
+	targetURL := "http://product-service:8081/v1/products/watch?" + r.URL.RawQuery
+	g.forwardRequest(w, r, targetURL)
+}
+
 func (g *APIGateway) handlePayments(w http.ResponseWriter, r *http.Request) {
 	// Forward to payment service
 	targetURL := "http://payment-service:8082/v1/payments"
@@ -139,7 +165,51 @@ func (g *APIGateway) handleBasket(w http.ResponseWriter, r *http.Request) {
 	userID := vars["user_id"]
 
 	// Forward to basket service
-	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s", userID)
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s?%s", userID, r.URL.RawQuery)
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleBasketItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Forward to basket service
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s/items/%s", url.PathEscape(vars["user_id"]), url.PathEscape(vars["product_id"]))
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleBasketHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Forward to basket service
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s/history?%s", url.PathEscape(vars["user_id"]), r.URL.RawQuery)
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleWatchBasket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Forward to basket service; the proxy flushes each event as it arrives
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s/watch", url.PathEscape(vars["user_id"]))
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleBasketBatch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	// Forward to basket service
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s/batch", url.PathEscape(vars["user_id"]))
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleCurrentBasket(w http.ResponseWriter, r *http.Request) {
+	// Forward to basket service using the basket ID from the cookie or header
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/baskets/%s?%s", url.PathEscape(r.Header.Get(basketIDHeader)), r.URL.RawQuery)
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleMergeBaskets(w http.ResponseWriter, r *http.Request) {
+	// Forward to basket service, which merges the basket in the X-Basket-ID header
+	targetURL := "http://basket-service:8083/v1/baskets/merge"
 	g.forwardRequest(w, r, targetURL)
 }
 
@@ -149,12 +219,46 @@ func (g *APIGateway) handleAddItem(w http.ResponseWriter, r *http.Request) {
 	g.forwardRequest(w, r, targetURL)
 }
 
+func (g *APIGateway) handleCheckout(w http.ResponseWriter, r *http.Request) {
+	// Forward to basket service, which computes the amount and calls the payment service
+	targetURL := "http://basket-service:8083/v1/baskets/checkout"
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleAcknowledgePrices(w http.ResponseWriter, r *http.Request) {
+	// Forward to basket service, which moves the acknowledged lines to the current prices
+	targetURL := "http://basket-service:8083/v1/baskets/acknowledge-prices"
+	g.forwardRequest(w, r, targetURL)
+}
+
+func (g *APIGateway) handleMoveToList(w http.ResponseWriter, r *http.Request) {
+	// Forward to basket service
+	targetURL := "http://basket-service:8083/v1/baskets/move-to-list"
+	g.forwardRequest(w, r, targetURL)
+}
+
 func (g *APIGateway) handleRemoveItem(w http.ResponseWriter, r *http.Request) {
 	// Forward to basket service
 	targetURL := "http://basket-service:8083/v1/baskets/remove"
 	g.forwardRequest(w, r, targetURL)
 }
 
+func (g *APIGateway) handleLists(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	// Forward to basket service
+	targetURL := fmt.Sprintf("http://basket-service:8083/v1/lists/%s", userID)
+	g.forwardRequest(w, r, targetURL)
+}
+
+// handleListAction serves the saved list actions under /api/v1/lists.
+func (g *APIGateway) handleListAction(w http.ResponseWriter, r *http.Request) {
+	// Forward to basket service, which uses the same paths without the /api prefix
+	targetURL := "http://basket-service:8083" + strings.TrimPrefix(r.URL.Path, "/api")
+	g.forwardRequest(w, r, targetURL)
+}
+
 func (g *APIGateway) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	response := Response{
 		Success: true,
@@ -181,8 +285,9 @@ func (g *APIGateway) forwardRequest(w http.ResponseWriter, r *http.Request, targ
 		return
 	}
 
-	// Create reverse proxy
+	// Create reverse proxy, flushing immediately so event streams are not buffered
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = -1
 
 	// Modify request
 	originalDirector := proxy.Director
@@ -208,6 +313,43 @@ func (g *APIGateway) forwardRequest(w http.ResponseWriter, r *http.Request, targ
 	proxy.ServeHTTP(w, r)
 }
 
+// withBasketID makes sure the request carries a basket ID. Clients send it in
+// the X-Basket-ID header or the basket_id cookie; anonymous shoppers without
+// one are issued a new guest basket ID.
+func (g *APIGateway) withBasketID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		basketID := r.Header.Get(basketIDHeader)
+		if basketID == "" {
+			if cookie, err := r.Cookie(basketIDCookie); err == nil {
+				basketID = cookie.Value
+			}
+		}
+
+		if basketID == "" {
+			id, err := generateGuestBasketID()
+			if err != nil {
+				log.Printf("Failed to generate guest basket ID: %v", err)
+				g.sendError(w, "Failed to create basket", http.StatusInternalServerError)
+				return
+			}
+			basketID = id
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     basketIDCookie,
+				Value:    basketID,
+				Path:     "/",
+				MaxAge:   int(guestBasketCookieAge / time.Second),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		r.Header.Set(basketIDHeader, basketID)
+		w.Header().Set(basketIDHeader, basketID)
+		next(w, r)
+	}
+}
+
 func (g *APIGateway) sendError(w http.ResponseWriter, message string, statusCode int) {
 	response := Response{
 		Success: false,
@@ -221,9 +363,17 @@ func (g *APIGateway) sendError(w http.ResponseWriter, message string, statusCode
 
 func (g *APIGateway) Start(port string) error {
 	log.Printf("Starting API Gateway on port %s", port)
-	return http.ListenAndServe(":"+port, g.router)
+	return http.ListenAndServe(":"+port, g.handler)
 }
 
+// Guest basket identification
+const (
+	basketIDHeader       = "X-Basket-ID"
+	basketIDCookie       = "basket_id"
+	guestBasketPrefix    = "guest-"
+	guestBasketCookieAge = 30 * 24 * time.Hour
+)
+
 // Global variables for metrics
 var (
 	startTime    = time.Now()
@@ -234,6 +384,14 @@ func generateRequestID() string {
 	return fmt.Sprintf("req-%d", time.Now().UnixNano())
 }
 
+func generateGuestBasketID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return guestBasketPrefix + hex.EncodeToString(b), nil
+}
+
 func main() {
 	port := getEnv("PORT", "8080")
 
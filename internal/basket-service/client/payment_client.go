@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	paymentpb "daprps/api/proto/payment"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// notTakenCodes are the status codes with which the payment service refuses
+// a charge before taking the payment.
+var notTakenCodes = map[codes.Code]bool{
+	codes.InvalidArgument:    true,
+	codes.NotFound:           true,
+	codes.PermissionDenied:   true,
+	codes.Unauthenticated:    true,
+	codes.FailedPrecondition: true,
+	codes.OutOfRange:         true,
+	codes.Unimplemented:      true,
+}
+
+// PaymentClient implements model.PaymentGateway on top of the payment service
+// gRPC API. Failed calls are not retried here: a charge is only idempotent
+// per order ID, and a checkout retried by the user reuses its order ID.
+type PaymentClient struct {
+	conn    *grpc.ClientConn
+	client  paymentpb.PaymentServiceClient
+	timeout time.Duration
+}
+
+func NewPaymentClient(addr string, timeout time.Duration) (*PaymentClient, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to payment service: %w", err)
+	}
+
+	return &PaymentClient{
+		conn:    conn,
+		client:  paymentpb.NewPaymentServiceClient(conn),
+		timeout: timeout,
+	}, nil
+}
+
+func (c *PaymentClient) ProcessPayment(ctx context.Context, req *model.PaymentRequest) (*model.PaymentResult, error) {
+	// A charge is not sent while the connection is failing, so that the
+	// checkout knows nothing was charged
+	if state := c.conn.GetState(); state == connectivity.TransientFailure || state == connectivity.Shutdown {
+		return nil, fmt.Errorf("%w: %w", model.ErrPaymentNotTaken, status.Error(codes.Unavailable, "payment service is unreachable"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	paymentReq := &paymentpb.ProcessPaymentRequest{
+		OrderId:       req.OrderID,
+		UserId:        req.UserID,
+		Amount:        money.ToProto(req.Amount),
+		PaymentMethod: req.PaymentMethod,
+		CardNumber:    req.CardNumber,
+		CardHolder:    req.CardHolder,
+		ExpiryDate:    req.ExpiryDate,
+		Cvv:           req.CVV,
+	}
+	for _, item := range req.Items {
+		paymentReq.Items = append(paymentReq.Items, &paymentpb.LineItem{
+			ProductId:   item.ProductID,
+			ProductName: item.ProductName,
+			Price:       money.ToProto(item.Price),
+			Quantity:    item.Quantity,
+		})
+	}
+
+	resp, err := c.client.ProcessPayment(ctx, paymentReq)
+	if err != nil {
+		if notTakenCodes[status.Code(err)] {
+			return nil, fmt.Errorf("%w: %w", model.ErrPaymentNotTaken, err)
+		}
+		return nil, fmt.Errorf("error processing payment: %w", err)
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%w: %s", model.ErrPaymentDeclined, resp.Error)
+	}
+
+	return &model.PaymentResult{
+		PaymentID: resp.Payment.Id,
+		Status:    resp.Payment.Status,
+	}, nil
+}
+
+func (c *PaymentClient) Close() error {
+	return c.conn.Close()
+}
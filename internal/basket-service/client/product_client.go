@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	productpb "daprps/api/proto/product"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// retryBackoff is the delay before the first retry; it doubles on each attempt.
+const retryBackoff = 100 * time.Millisecond
+
+// ProductClient implements model.ProductCatalog on top of the product service
+// gRPC API. Every attempt gets its own deadline and transient failures are
+// retried.
+type ProductClient struct {
+	conn    *grpc.ClientConn
+	client  productpb.ProductServiceClient
+	timeout time.Duration
+	retries int
+}
+
+func NewProductClient(addr string, timeout time.Duration, retries int) (*ProductClient, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to product service: %w", err)
+	}
+
+	return &ProductClient{
+		conn:    conn,
+		client:  productpb.NewProductServiceClient(conn),
+		timeout: timeout,
+		retries: retries,
+	}, nil
+}
+
+func (c *ProductClient) GetProduct(ctx context.Context, productID string) (*model.ProductInfo, error) {
+	var resp *productpb.GetProductResponse
+	err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.client.GetProduct(ctx, &productpb.GetProductRequest{ProductId: productID})
+		return err
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", model.ErrProductNotFound, productID)
+		}
+		return nil, fmt.Errorf("error getting product %s: %w", productID, err)
+	}
+
+	return convertProduct(resp), nil
+}
+
+func (c *ProductClient) GetProducts(ctx context.Context, productIDs []string) (map[string]*model.ProductInfo, error) {
+	var resp *productpb.GetProductsResponse
+	err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.client.GetProducts(ctx, &productpb.GetProductsRequest{ProductIds: productIDs})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting products: %w", err)
+	}
+
+	products := make(map[string]*model.ProductInfo, len(resp.Products))
+	for _, found := range resp.Products {
+		product := convertProduct(found)
+		products[product.ID] = product
+	}
+	return products, nil
+}
+
+func convertProduct(resp *productpb.GetProductResponse) *model.ProductInfo {
+	return &model.ProductInfo{
+		ID:          resp.Product.Id,
+		Name:        resp.Product.Name,
+		Price:       money.FromProto(resp.Product.Price),
+		Category:    resp.Product.Category,
+		WeightGrams: resp.Product.WeightGrams,
+		MaxPerOrder: resp.Product.MaxPerOrder,
+		Stock:       resp.Product.Stock,
+		Available:   resp.Available,
+	}
+}
+
+// call runs fn with a per-attempt deadline, retrying transient errors.
+func (c *ProductClient) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(retryBackoff << (attempt - 1)):
+			}
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err = fn(callCtx)
+		cancel()
+
+		if err == nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+func (c *ProductClient) Close() error {
+	return c.conn.Close()
+}
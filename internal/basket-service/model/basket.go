@@ -1,29 +1,249 @@
 package model
 
 import (
+	"errors"
+	"strings"
 	"time"
+
+	"daprps/internal/money"
 )
 
+// ErrBasketConflict is returned when a basket mutation keeps losing to
+// concurrent writers and gives up.
+var ErrBasketConflict = errors.New("basket modified concurrently")
+
+// Policies for lines present in both baskets when merging.
+const (
+	MergeSum    = "sum"    // add the quantities
+	MergeMax    = "max"    // keep the larger quantity
+	MergeNewest = "newest" // keep the line that was added most recently
+)
+
+var ErrInvalidMergePolicy = errors.New("invalid merge policy")
+
+// GuestBasketPrefix starts the basket IDs the gateway issues to anonymous
+// shoppers. Only guest baskets can be merged into another basket.
+const GuestBasketPrefix = "guest-"
+
+// IsGuestBasket reports whether id is a guest basket ID.
+func IsGuestBasket(id string) bool {
+	return strings.HasPrefix(id, GuestBasketPrefix) && len(id) > len(GuestBasketPrefix)
+}
+
+// ErrVersionMismatch is returned when a basket update expected a version the
+// basket is no longer at.
+var ErrVersionMismatch = errors.New("basket version mismatch")
+
 type BasketItem struct {
-	ProductID   string  `json:"product_id" db:"product_id"`
-	ProductName string  `json:"product_name" db:"product_name"`
-	Price       float64 `json:"price" db:"price"`
-	Quantity    int32   `json:"quantity" db:"quantity"`
+	ProductID   string      `json:"product_id" db:"product_id"`
+	ProductName string      `json:"product_name" db:"product_name"`
+	Price       money.Money `json:"price" db:"price"`
+	Quantity    int32       `json:"quantity" db:"quantity"`
+	Category    string      `json:"category" db:"category"`
+	WeightGrams int32       `json:"weight_grams" db:"weight_grams"`
+	// PriceSnapshotAt is when Price was copied from the catalog.
+	PriceSnapshotAt time.Time `json:"price_snapshot_at" db:"price_snapshot_at"`
 }
 
 type Basket struct {
 	UserID      string       `json:"user_id" db:"user_id"`
 	Items       []BasketItem `json:"items" db:"items"`
-	TotalAmount float64      `json:"total_amount" db:"total_amount"`
+	TotalAmount money.Money  `json:"total_amount" db:"total_amount"` // before discounts
+	CouponCodes []string     `json:"coupon_codes" db:"coupon_codes"`
+	Destination *Address     `json:"destination,omitempty" db:"destination"`
 	CreatedAt   time.Time    `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time    `json:"updated_at" db:"updated_at"`
+	// Version increases on every update; baskets that are not stored are at
+	// NewBasketVersion.
+	Version int64 `json:"version" db:"version"`
+}
+
+// NewBasketVersion is the version of a basket that is not stored, so that
+// its first update can be made conditional like any other. Stored baskets
+// are at later versions, also after they expired or were merged away.
+const NewBasketVersion = 1
+
+// Currency returns the currency of the basket's lines, or the default
+// currency for an empty basket.
+func (b *Basket) Currency() string {
+	for _, item := range b.Items {
+		if item.Price.Currency != "" {
+			return item.Price.Currency
+		}
+	}
+	return money.DefaultCurrency
+}
+
+// Subtotal returns the sum of all lines before discounts. It fails with
+// money.ErrCurrencyMismatch if lines are priced in different currencies.
+func (b *Basket) Subtotal() (money.Money, error) {
+	total := money.Zero(b.Currency())
+	for _, item := range b.Items {
+		var err error
+		if total, err = total.Add(item.Price.Mul(int64(item.Quantity))); err != nil {
+			return money.Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// FindItem returns the index of a product in the basket, or -1.
+func (b *Basket) FindItem(productID string) int {
+	for i, item := range b.Items {
+		if item.ProductID == productID {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddItem adds a line, adding up quantities of the same product.
+func (b *Basket) AddItem(item BasketItem) {
+	if i := b.FindItem(item.ProductID); i >= 0 {
+		b.Items[i].Quantity += item.Quantity
+		return
+	}
+	b.Items = append(b.Items, item)
+}
+
+// RemoveItem removes the line of a product, if any.
+func (b *Basket) RemoveItem(productID string) {
+	if i := b.FindItem(productID); i >= 0 {
+		b.Items = append(b.Items[:i], b.Items[i+1:]...)
+	}
+}
+
+// SetQuantity changes the quantity of a line, removing it if quantity is 0
+// or negative. Products that are not in the basket are ignored.
+func (b *Basket) SetQuantity(productID string, quantity int32) {
+	i := b.FindItem(productID)
+	if i < 0 {
+		return
+	}
+	if quantity <= 0 {
+		b.Items = append(b.Items[:i], b.Items[i+1:]...)
+		return
+	}
+	b.Items[i].Quantity = quantity
+}
+
+// Clear removes all lines and coupons.
+func (b *Basket) Clear() {
+	b.Items = []BasketItem{}
+	b.CouponCodes = nil
+}
+
+// RemoveOrdered takes the lines and coupons of a paid order out of the
+// basket. Units added after the order was placed stay in the basket.
+func (b *Basket) RemoveOrdered(order *PendingOrder) {
+	for _, item := range order.Items {
+		if i := b.FindItem(item.ProductID); i >= 0 {
+			b.SetQuantity(item.ProductID, b.Items[i].Quantity-item.Quantity)
+		}
+	}
+	for _, code := range order.CouponCodes {
+		b.RemoveCoupon(code)
+	}
+}
+
+// AddCoupon applies a coupon code once.
+func (b *Basket) AddCoupon(code string) {
+	for _, existing := range b.CouponCodes {
+		if existing == code {
+			return
+		}
+	}
+	b.CouponCodes = append(b.CouponCodes, code)
+}
+
+// RemoveCoupon removes a coupon code, if applied.
+func (b *Basket) RemoveCoupon(code string) {
+	var codes []string
+	for _, existing := range b.CouponCodes {
+		if existing != code {
+			codes = append(codes, existing)
+		}
+	}
+	b.CouponCodes = codes
 }
 
 type BasketRepository interface {
 	GetByUserID(userID string) (*Basket, error)
+	// Update applies fn to the basket in one atomic read-modify-write,
+	// recalculates its total and returns the stored basket. If
+	// expectedVersion is not 0, it fails with ErrVersionMismatch unless the
+	// basket is at that version. An error from fn aborts the update.
+	Update(userID string, expectedVersion int64, fn func(basket *Basket) error) (*Basket, error)
 	AddItem(userID string, item BasketItem) error
 	RemoveItem(userID, productID string) error
 	UpdateQuantity(userID, productID string, quantity int32) error
 	Clear(userID string) error
-	UpdateTotalAmount(userID string, totalAmount float64) error
+	UpdateTotalAmount(userID string, totalAmount money.Money) error
+	// Merge moves the lines of one basket into another and deletes the
+	// source basket in one transaction. check, if not nil, sees the lines
+	// the target basket had before and the merged basket inside the
+	// transaction; an error from it aborts the merge and leaves both
+	// baskets unchanged.
+	Merge(fromUserID, toUserID, policy string, check func(before []BasketItem, merged *Basket) error) (*Basket, error)
+	// UpdatePrices moves lines to new prices, keyed by product ID, and
+	// renews their price snapshots.
+	UpdatePrices(userID string, prices map[string]money.Money) error
+	AddCoupon(userID, code string) error
+	RemoveCoupon(userID, code string) error
+	SetDestination(userID string, destination *Address) error
+	GetIdleBaskets(idleSince time.Time, limit int64) ([]string, error)
+	MarkAbandoned(userID string, idleSince time.Time) (bool, error)
+	UnmarkAbandoned(userID string) error
+	GetAbandonedBaskets(limit int64) (map[string]time.Time, error)
+	ClearAbandoned(userID string) (bool, error)
+
+	// Saved lists
+	GetLists(userID string) ([]*List, error)
+	CreateList(userID, name string) (*List, error)
+	DeleteList(userID, listID string) error
+	AddToList(userID, listID string, item BasketItem) error
+	RemoveFromList(userID, listID, productID string) error
+	// MoveToList moves a basket line into a list in one transaction.
+	MoveToList(userID, listID, productID string) error
+	// MoveToBasket removes a product from a list and adds item to the basket
+	// with the quantity saved in the list, in one transaction. check, if not
+	// nil, sees the updated basket and the moved item inside the transaction;
+	// an error from it aborts the move.
+	MoveToBasket(userID, listID string, item BasketItem, check func(basket *Basket, moved BasketItem) error) error
+
+	// Checkout
+	// LockBasket locks a basket against changes for the duration of a
+	// checkout; the lock expires after ttl. The checkout charges orderID,
+	// unless an earlier checkout of the basket ended without a known payment
+	// outcome, whose order is reused so that the payment is not taken twice.
+	LockBasket(userID, orderID string, ttl time.Duration) (*CheckoutLock, error)
+	// UnlockBasket releases the lock of a checkout whose payment was not
+	// taken and forgets its order.
+	UnlockBasket(userID, token string) error
+	// SetPendingOrder records what a checkout order charges before the
+	// payment is taken and makes it the pending order of the basket. An
+	// earlier pending order it replaces stays recorded for its payment event.
+	SetPendingOrder(order *PendingOrder) error
+	// GetPendingOrder returns what an order recorded with SetPendingOrder
+	// charges, or nil if the order was completed, unlocked or has expired.
+	GetPendingOrder(orderID string) (*PendingOrder, error)
+	// CompleteCheckout takes the lines of a paid order out of the basket,
+	// records the order and releases the checkout lock if it is held with
+	// token or for the order.
+	CompleteCheckout(userID, token string, order *PendingOrder) error
+	IsCheckoutOrder(orderID string) (bool, error)
+	// PendingOrder returns the order of a checkout of the basket that was
+	// neither completed nor unlocked, or "" if there is none.
+	PendingOrder(userID string) (string, error)
+	SetProductDeleted(productID string, deleted bool) error
+	GetDeletedProducts(productIDs []string) (map[string]bool, error)
+}
+
+// BasketWatcher signals changes of users' baskets, including changes made by
+// other replicas of the service.
+type BasketWatcher interface {
+	// Watch returns a channel that receives a value after the user's basket
+	// changed, and a function that stops watching. Changes in quick
+	// succession may be signalled only once.
+	Watch(userID string) (<-chan struct{}, func())
 }
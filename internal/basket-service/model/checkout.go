@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"daprps/internal/money"
+)
+
+var (
+	ErrBasketLocked    = errors.New("basket is locked for checkout")
+	ErrPaymentDeclined = errors.New("payment declined")
+	// ErrPaymentNotTaken is returned for charges that failed before the
+	// payment could be taken, such as invalid requests.
+	ErrPaymentNotTaken = errors.New("payment not taken")
+)
+
+// CheckoutLock is a basket held by a checkout. OrderID is the order the
+// checkout charges and the idempotency key of its payment.
+type CheckoutLock struct {
+	Token   string
+	OrderID string
+}
+
+// PendingOrderTTL is how long the order of a checkout whose payment outcome
+// is unknown is kept for its payment event, and with it the coupon uses it
+// holds.
+const PendingOrderTTL = 7 * 24 * time.Hour
+
+// PendingOrder is what a checkout order charges. It is recorded before the
+// payment is taken, so that the payment event of the order takes only the
+// charged lines out of the basket, whatever was added to it since.
+type PendingOrder struct {
+	OrderID     string       `json:"order_id"`
+	UserID      string       `json:"user_id"`
+	Amount      money.Money  `json:"amount"`
+	Items       []BasketItem `json:"items"`
+	CouponCodes []string     `json:"coupon_codes"`
+	// Redeemed are the coupons that discount the order. Each holds a use
+	// of its coupon until the order is paid.
+	Redeemed []string `json:"redeemed"`
+}
+
+// SameCharge reports whether two orders charge the same lines for the same
+// amount, so that one can be charged under the order ID of the other.
+func (o *PendingOrder) SameCharge(other *PendingOrder) bool {
+	if cmp, err := o.Amount.Cmp(other.Amount); err != nil || cmp != 0 || len(o.Items) != len(other.Items) {
+		return false
+	}
+	for i, item := range o.Items {
+		charged := other.Items[i]
+		if item.ProductID != charged.ProductID || item.Quantity != charged.Quantity || item.Price != charged.Price {
+			return false
+		}
+	}
+	return true
+}
+
+// PaymentRequest is a charge for a checked out basket.
+type PaymentRequest struct {
+	OrderID       string
+	UserID        string
+	Amount        money.Money
+	PaymentMethod string
+	CardNumber    string
+	CardHolder    string
+	ExpiryDate    string
+	CVV           string
+	Items         []BasketItem
+}
+
+// PaymentResult is the outcome of a successful charge.
+type PaymentResult struct {
+	PaymentID string
+	Status    string
+}
+
+// PaymentGateway charges checked out baskets. Failed charges are reported
+// with ErrPaymentDeclined or ErrPaymentNotTaken if the payment was certainly
+// not taken; any other error leaves the outcome unknown.
+type PaymentGateway interface {
+	ProcessPayment(ctx context.Context, req *PaymentRequest) (*PaymentResult, error)
+}
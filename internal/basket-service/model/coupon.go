@@ -0,0 +1,121 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"daprps/internal/money"
+)
+
+// Coupon discount types.
+const (
+	DiscountPercentage = "percentage"  // Percent off the eligible lines
+	DiscountFixed      = "fixed"       // Amount off the eligible lines
+	DiscountBuyXGetY   = "buy_x_get_y" // every BuyQuantity+GetQuantity units, GetQuantity are free
+	DiscountThreshold  = "threshold"   // Amount off once the eligible lines reach MinSubtotal
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponNotActive     = errors.New("coupon is not active")
+	ErrCouponUsageExceeded = errors.New("coupon usage limit reached")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to basket")
+	ErrInvalidCoupon       = errors.New("invalid coupon")
+)
+
+// Coupon is a promotion that can be applied to a basket with its code.
+// ProductIDs and Categories restrict the lines it applies to; when both are
+// empty it applies to the whole basket.
+type Coupon struct {
+	Code        string      `json:"code"`
+	Type        string      `json:"type"`
+	Percent     float64     `json:"percent,omitempty"`
+	Amount      money.Money `json:"amount"`
+	BuyQuantity int32       `json:"buy_quantity"`
+	GetQuantity int32       `json:"get_quantity"`
+	MinSubtotal money.Money `json:"min_subtotal"`
+	ProductIDs  []string    `json:"product_ids"`
+	Categories  []string    `json:"categories"`
+	StartsAt    time.Time   `json:"starts_at"`
+	EndsAt      time.Time   `json:"ends_at"`
+	UsageLimit  int64       `json:"usage_limit"` // 0 means unlimited
+}
+
+// UnmarshalJSON also reads coupons stored before amounts were Money, which
+// kept the percentage or the amount off in a single "value" in major units.
+func (c *Coupon) UnmarshalJSON(data []byte) error {
+	type plain Coupon
+	var decoded struct {
+		plain
+		Value *float64 `json:"value"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*c = Coupon(decoded.plain)
+
+	if decoded.Value != nil {
+		switch c.Type {
+		case DiscountPercentage:
+			c.Percent = *decoded.Value
+		case DiscountFixed, DiscountThreshold:
+			c.Amount = money.FromMajor(*decoded.Value, money.DefaultCurrency)
+		}
+	}
+	return nil
+}
+
+// NormalizeCouponCode returns the canonical form of a coupon code.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ActiveAt reports whether t falls inside the coupon's validity window.
+func (c *Coupon) ActiveAt(t time.Time) bool {
+	if !c.StartsAt.IsZero() && t.Before(c.StartsAt) {
+		return false
+	}
+	if !c.EndsAt.IsZero() && !t.Before(c.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// Applies reports whether the coupon's scope covers a basket line.
+func (c *Coupon) Applies(item BasketItem) bool {
+	if len(c.ProductIDs) == 0 && len(c.Categories) == 0 {
+		return true
+	}
+	for _, id := range c.ProductIDs {
+		if id == item.ProductID {
+			return true
+		}
+	}
+	for _, category := range c.Categories {
+		if category == item.Category {
+			return true
+		}
+	}
+	return false
+}
+
+type CouponRepository interface {
+	Save(coupon *Coupon) error
+	Get(code string) (*Coupon, error)
+	// Redemptions returns how many paid orders have used the coupon.
+	Redemptions(code string) (int64, error)
+	// Reserve holds a use of the coupon for an order until the order is
+	// paid, the hold is released or ttl has passed. It fails with
+	// ErrCouponUsageExceeded if limit uses are already redeemed or held by
+	// other orders (0 means no limit). The check and the hold are atomic,
+	// and reserving for the same order again renews its hold.
+	Reserve(code, orderID string, limit int64, ttl time.Duration) error
+	// Redeem counts a use of the coupon by a paid order and drops its hold.
+	// The order is paid, so the use is counted even past the limit.
+	// Redeeming the same order twice counts once.
+	Redeem(code, orderID string) error
+	// Release drops the hold of an order that was not paid.
+	Release(code, orderID string) error
+}
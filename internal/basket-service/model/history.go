@@ -0,0 +1,254 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Types of basket history events.
+const (
+	EventBasketCreated   = "basket_created"   // a new basket was started
+	EventBasketDeleted   = "basket_deleted"   // merged into another basket
+	EventBasketCleared   = "basket_cleared"   // all lines and coupons removed
+	EventItemAdded       = "item_added"       // Item is the new line
+	EventItemRemoved     = "item_removed"     // ProductID is the removed line
+	EventQuantityChanged = "quantity_changed" // Quantity is the new quantity
+	EventItemChanged     = "item_changed"     // Item replaces the line, such as on a price change
+	EventCouponApplied   = "coupon_applied"
+	EventCouponRemoved   = "coupon_removed"
+	EventDestinationSet  = "destination_set" // Destination is nil when it was removed
+	EventBasketRenewed   = "basket_renewed"  // stored unchanged, which renews its expiry
+	EventBasketExpired   = "basket_expired"  // not stored: inserted when replaying at ExpiresAt of the change before
+	EventBasketSnapshot  = "basket_snapshot" // Basket is the whole basket, standing in for trimmed events
+)
+
+// ErrHistoryTrimmed is returned for a time before the oldest retained event
+// of a basket's history.
+var ErrHistoryTrimmed = errors.New("basket history trimmed")
+
+// BasketEvent is one change in the append-only history of a user's basket.
+// Replaying the events of a basket in order rebuilds it.
+type BasketEvent struct {
+	// ID is assigned by the history store when the event is appended.
+	ID   string    `json:"id,omitempty"`
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+	// Version is the basket version the change produced.
+	Version     int64       `json:"version"`
+	ProductID   string      `json:"product_id,omitempty"`
+	Item        *BasketItem `json:"item,omitempty"`
+	Quantity    int32       `json:"quantity,omitempty"`
+	CouponCode  string      `json:"coupon_code,omitempty"`
+	Destination *Address    `json:"destination,omitempty"`
+	// ExpiresAt is when the basket expires unless it is changed again.
+	ExpiresAt time.Time `json:"expires_at"`
+	Basket    *Basket   `json:"basket,omitempty"`
+}
+
+// BasketHistory reads the events of every user's basket. The basket
+// repositories append the events of a change in the same transaction as the
+// change. Once a history grows too long, its oldest events are replaced by a
+// basket_snapshot event, so replaying it still rebuilds the basket.
+type BasketHistory interface {
+	// Get returns the events of a user's basket in the order they were
+	// appended. If until is not zero, only events up to until are returned,
+	// or ErrHistoryTrimmed if until is before the oldest event retained.
+	Get(userID string, until time.Time) ([]BasketEvent, error)
+}
+
+// ChangeEvents returns the events to record for a change from before to
+// after: the events of DiffBaskets, or basket_renewed if the basket was
+// stored unchanged. A stored basket expires at expiresAt.
+func ChangeEvents(before, after *Basket, expiresAt time.Time) []BasketEvent {
+	events := DiffBaskets(before, after)
+	if after.Version <= NewBasketVersion {
+		return events
+	}
+	if len(events) == 0 {
+		events = []BasketEvent{{Type: EventBasketRenewed, At: after.UpdatedAt, Version: after.Version}}
+	}
+	for i := range events {
+		events[i].ExpiresAt = expiresAt
+	}
+	return events
+}
+
+// DiffBaskets returns the events that turn before into after. A basket at
+// NewBasketVersion is not stored, so a change from or to that version creates
+// or deletes the basket.
+func DiffBaskets(before, after *Basket) []BasketEvent {
+	at := after.UpdatedAt
+	if after.Version <= NewBasketVersion {
+		if before.Version <= NewBasketVersion {
+			return nil
+		}
+		return []BasketEvent{{Type: EventBasketDeleted, At: time.Now(), Version: NewBasketVersion}}
+	}
+	event := func(e BasketEvent) BasketEvent {
+		e.At = at
+		e.Version = after.Version
+		return e
+	}
+
+	var events []BasketEvent
+	if before.Version <= NewBasketVersion {
+		events = append(events, event(BasketEvent{Type: EventBasketCreated}))
+		before = &Basket{}
+	}
+
+	if len(after.Items) == 0 && len(after.CouponCodes) == 0 && (len(before.Items) > 0 || len(before.CouponCodes) > 0) {
+		events = append(events, event(BasketEvent{Type: EventBasketCleared}))
+		before = &Basket{Destination: before.Destination}
+	}
+
+	for _, item := range before.Items {
+		if after.FindItem(item.ProductID) < 0 {
+			events = append(events, event(BasketEvent{Type: EventItemRemoved, ProductID: item.ProductID}))
+		}
+	}
+	for _, item := range after.Items {
+		item := item
+		i := before.FindItem(item.ProductID)
+		switch {
+		case i < 0:
+			events = append(events, event(BasketEvent{Type: EventItemAdded, ProductID: item.ProductID, Item: &item}))
+		case sameLine(before.Items[i], item) && before.Items[i].Quantity == item.Quantity:
+			// Unchanged
+		case sameLine(before.Items[i], item):
+			events = append(events, event(BasketEvent{Type: EventQuantityChanged, ProductID: item.ProductID, Quantity: item.Quantity}))
+		default:
+			events = append(events, event(BasketEvent{Type: EventItemChanged, ProductID: item.ProductID, Item: &item}))
+		}
+	}
+
+	for _, code := range before.CouponCodes {
+		if !hasCoupon(after, code) {
+			events = append(events, event(BasketEvent{Type: EventCouponRemoved, CouponCode: code}))
+		}
+	}
+	for _, code := range after.CouponCodes {
+		if !hasCoupon(before, code) {
+			events = append(events, event(BasketEvent{Type: EventCouponApplied, CouponCode: code}))
+		}
+	}
+
+	if !sameAddress(before.Destination, after.Destination) {
+		e := BasketEvent{Type: EventDestinationSet}
+		if after.Destination != nil {
+			destination := *after.Destination
+			e.Destination = &destination
+		}
+		events = append(events, event(e))
+	}
+	return events
+}
+
+// ReplayBasket rebuilds a user's basket as of until, or now if until is zero,
+// from its events. It returns the basket and the events with the expiries of
+// the basket inserted: before a basket_created event that follows the expiry
+// of the change before it, and at the end if until is past the expiry of the
+// last change. The total is not recalculated.
+func ReplayBasket(userID string, events []BasketEvent, until time.Time) (*Basket, []BasketEvent) {
+	if until.IsZero() {
+		until = time.Now()
+	}
+	basket := &Basket{UserID: userID, Items: []BasketItem{}}
+	replayed := make([]BasketEvent, 0, len(events)+1)
+	var expiresAt time.Time
+	expire := func() {
+		e := BasketEvent{Type: EventBasketExpired, At: expiresAt, Version: NewBasketVersion}
+		basket.Apply(e)
+		replayed = append(replayed, e)
+		expiresAt = time.Time{}
+	}
+
+	for _, e := range events {
+		if e.Type == EventBasketCreated && !expiresAt.IsZero() && !e.At.Before(expiresAt) {
+			expire()
+		}
+		basket.Apply(e)
+		replayed = append(replayed, e)
+		expiresAt = e.ExpiresAt
+	}
+	if !expiresAt.IsZero() && !until.Before(expiresAt) {
+		expire()
+	}
+	return basket, replayed
+}
+
+// SnapshotEvent folds events into one basket_snapshot event that replays to
+// the same basket, with the version and expiry of the last event.
+func SnapshotEvent(userID string, events []BasketEvent) BasketEvent {
+	if len(events) == 0 {
+		return BasketEvent{Type: EventBasketSnapshot, Basket: &Basket{UserID: userID, Items: []BasketItem{}}}
+	}
+	last := events[len(events)-1]
+	basket, _ := ReplayBasket(userID, events, last.At)
+	return BasketEvent{
+		Type:      EventBasketSnapshot,
+		At:        last.At,
+		Version:   last.Version,
+		ExpiresAt: last.ExpiresAt,
+		Basket:    basket,
+	}
+}
+
+// Apply applies a history event to the basket.
+func (b *Basket) Apply(e BasketEvent) {
+	switch e.Type {
+	case EventBasketCreated, EventBasketDeleted, EventBasketExpired:
+		*b = Basket{UserID: b.UserID, Items: []BasketItem{}, CreatedAt: e.At}
+	case EventBasketSnapshot:
+		if e.Basket != nil {
+			snapshot := *e.Basket
+			snapshot.Items = append([]BasketItem{}, e.Basket.Items...)
+			snapshot.CouponCodes = append([]string(nil), e.Basket.CouponCodes...)
+			*b = snapshot
+		}
+	case EventBasketCleared:
+		b.Clear()
+	case EventItemAdded:
+		if e.Item != nil {
+			b.RemoveItem(e.ProductID)
+			b.Items = append(b.Items, *e.Item)
+		}
+	case EventItemRemoved:
+		b.RemoveItem(e.ProductID)
+	case EventQuantityChanged:
+		b.SetQuantity(e.ProductID, e.Quantity)
+	case EventItemChanged:
+		if i := b.FindItem(e.ProductID); i >= 0 && e.Item != nil {
+			b.Items[i] = *e.Item
+		}
+	case EventCouponApplied:
+		b.AddCoupon(e.CouponCode)
+	case EventCouponRemoved:
+		b.RemoveCoupon(e.CouponCode)
+	case EventDestinationSet:
+		b.Destination = e.Destination
+	}
+	b.Version = e.Version
+	b.UpdatedAt = e.At
+}
+
+// sameLine reports whether two lines differ in nothing but their quantity.
+func sameLine(a, b BasketItem) bool {
+	return a.ProductID == b.ProductID && a.ProductName == b.ProductName && a.Price == b.Price &&
+		a.Category == b.Category && a.WeightGrams == b.WeightGrams && a.PriceSnapshotAt.Equal(b.PriceSnapshotAt)
+}
+
+func hasCoupon(b *Basket, code string) bool {
+	for _, existing := range b.CouponCodes {
+		if existing == code {
+			return true
+		}
+	}
+	return false
+}
+
+func sameAddress(a, b *Address) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
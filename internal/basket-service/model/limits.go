@@ -0,0 +1,126 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"daprps/internal/money"
+)
+
+// ErrLimitExceeded is returned when a change would take a basket over one of
+// its limits.
+var ErrLimitExceeded = errors.New("basket limit exceeded")
+
+// Names of the basket limits, reported in LimitErrors.
+const (
+	LimitMaxLines    = "max_lines"     // distinct products in a basket
+	LimitMaxQuantity = "max_quantity"  // units of one product
+	LimitMaxPerOrder = "max_per_order" // units of one product, set in the catalog
+	LimitMaxValue    = "max_value"     // subtotal of a basket
+)
+
+// BasketLimits caps the size of baskets. Zero values mean no limit.
+type BasketLimits struct {
+	MaxLines    int
+	MaxQuantity int32
+	// MaxValue caps the subtotal of a basket, keyed by currency.
+	MaxValue map[string]money.Money
+}
+
+// ParseValueLimits reads basket value limits as a comma-separated list of
+// amounts, each followed by its currency, such as "500 USD, 60000 JPY". An
+// amount without a currency is in money.DefaultCurrency; zero amounts set
+// no limit.
+func ParseValueLimits(s string) (map[string]money.Money, error) {
+	limits := make(map[string]money.Money)
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid value limit %q", strings.TrimSpace(entry))
+		}
+		currency := ""
+		if len(fields) == 2 {
+			currency = fields[1]
+		}
+		limit, err := money.Parse(fields[0], currency)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := limits[limit.Currency]; ok {
+			return nil, fmt.Errorf("value limit for %s set twice", limit.Currency)
+		}
+		if limit.IsPositive() {
+			limits[limit.Currency] = limit
+		}
+	}
+	return limits, nil
+}
+
+// LimitError reports the limit a basket change would violate.
+type LimitError struct {
+	Limit     string
+	ProductID string // set for limits on a single line
+	Max       string
+	Actual    string
+}
+
+func (e *LimitError) Error() string {
+	if e.ProductID != "" {
+		return fmt.Sprintf("%v: %s for product %s is %s, requested %s", ErrLimitExceeded, e.Limit, e.ProductID, e.Max, e.Actual)
+	}
+	return fmt.Sprintf("%v: %s is %s, basket has %s", ErrLimitExceeded, e.Limit, e.Max, e.Actual)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// PerLine reports whether the limit applies to a single basket line rather
+// than to the basket as a whole.
+func (e *LimitError) PerLine() bool {
+	return e.Limit == LimitMaxQuantity || e.Limit == LimitMaxPerOrder
+}
+
+// CheckLine checks the quantity of a product's line against the basket limit
+// and the product's own limit, if it has one.
+func (l BasketLimits) CheckLine(product *ProductInfo, quantity int32) error {
+	if l.MaxQuantity > 0 && quantity > l.MaxQuantity {
+		return &LimitError{Limit: LimitMaxQuantity, ProductID: product.ID, Max: fmt.Sprint(l.MaxQuantity), Actual: fmt.Sprint(quantity)}
+	}
+	if product.MaxPerOrder > 0 && quantity > product.MaxPerOrder {
+		return &LimitError{Limit: LimitMaxPerOrder, ProductID: product.ID, Max: fmt.Sprint(product.MaxPerOrder), Actual: fmt.Sprint(quantity)}
+	}
+	return nil
+}
+
+// Check checks the number of lines and the value of a basket. Once value
+// limits are set, a basket in a currency without one fails with
+// money.ErrCurrencyMismatch rather than going unchecked.
+func (l BasketLimits) Check(b *Basket) error {
+	if l.MaxLines > 0 && len(b.Items) > l.MaxLines {
+		return &LimitError{Limit: LimitMaxLines, Max: fmt.Sprint(l.MaxLines), Actual: fmt.Sprint(len(b.Items))}
+	}
+	if len(l.MaxValue) == 0 {
+		return nil
+	}
+	subtotal, err := b.Subtotal()
+	if err != nil {
+		return err
+	}
+	limit, ok := l.MaxValue[subtotal.Currency]
+	if !ok {
+		return fmt.Errorf("%w: no basket value limit for %s", money.ErrCurrencyMismatch, subtotal.Currency)
+	}
+	cmp, err := subtotal.Cmp(limit)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return &LimitError{Limit: LimitMaxValue, Max: limit.String(), Actual: subtotal.String()}
+	}
+	return nil
+}
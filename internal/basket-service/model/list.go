@@ -0,0 +1,39 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultListID is the "saved for later" list every user has.
+const (
+	DefaultListID   = "saved-for-later"
+	DefaultListName = "Saved for later"
+)
+
+var (
+	ErrListNotFound = errors.New("list not found")
+	ErrItemNotFound = errors.New("item not found")
+	ErrDefaultList  = errors.New("the saved for later list cannot be deleted")
+)
+
+// List is a named list of products kept outside the basket, such as the
+// saved for later list or a wishlist. Item prices are the prices at the time
+// the item was saved.
+type List struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Items     []BasketItem `json:"items"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// FindItem returns the index of a product in the list, or -1.
+func (l *List) FindItem(productID string) int {
+	for i, item := range l.Items {
+		if item.ProductID == productID {
+			return i
+		}
+	}
+	return -1
+}
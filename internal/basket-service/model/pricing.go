@@ -0,0 +1,44 @@
+package model
+
+import (
+	"errors"
+
+	"daprps/internal/money"
+)
+
+// ErrNoShippingRate is returned when no shipping rate covers a destination.
+var ErrNoShippingRate = errors.New("no shipping rate for destination")
+
+// Address is the destination a basket is shipped to. Country is an ISO 3166-1
+// alpha-2 code and Region a subdivision code such as a US state.
+type Address struct {
+	Country    string `json:"country"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	City       string `json:"city"`
+}
+
+// ShippingLine is the shipping charge of a basket.
+type ShippingLine struct {
+	Name   string
+	Amount money.Money
+}
+
+// TaxLine is one tax charged on a basket.
+type TaxLine struct {
+	Name   string
+	Rate   float64 // percent
+	Amount money.Money
+}
+
+type ShippingCalculator interface {
+	// Shipping returns the shipping charge for items worth value, after
+	// discounts, shipped to destination.
+	Shipping(destination Address, items []BasketItem, value money.Money) (*ShippingLine, error)
+}
+
+type TaxCalculator interface {
+	// Tax returns the taxes due on goods worth value, after discounts, and on
+	// the shipping charge.
+	Tax(destination Address, value, shipping money.Money) ([]TaxLine, error)
+}
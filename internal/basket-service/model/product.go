@@ -0,0 +1,79 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"daprps/internal/money"
+)
+
+// ErrProductNotFound is returned by a ProductCatalog for unknown or deleted products.
+var ErrProductNotFound = errors.New("product not found")
+
+// Reasons reported for basket lines that can no longer be bought as they are.
+const (
+	IssueUnavailable       = "unavailable"
+	IssueInsufficientStock = "insufficient_stock"
+)
+
+// ProductInfo is the catalog data the basket service needs about a product.
+type ProductInfo struct {
+	ID          string
+	Name        string
+	Price       money.Money
+	Category    string
+	WeightGrams int32
+	MaxPerOrder int32 // 0 for no limit
+	Stock       int32
+	Available   int32
+}
+
+// ProductCatalog looks up products in the product service.
+type ProductCatalog interface {
+	GetProduct(ctx context.Context, productID string) (*ProductInfo, error)
+	// GetProducts looks up several products at once, keyed by ID. Unknown
+	// and deleted products are left out.
+	GetProducts(ctx context.Context, productIDs []string) (map[string]*ProductInfo, error)
+}
+
+// Price drift policies decide which price a basket line is charged once the
+// catalog price differs from the snapshot taken when it was added.
+const (
+	// PriceHonourSnapshot keeps the snapshot price for SnapshotTTL after it
+	// was taken, then moves to the current price.
+	PriceHonourSnapshot = "honour_snapshot"
+	// PriceUseCurrent always charges the current catalog price.
+	PriceUseCurrent = "use_current"
+	// PriceRequireAck blocks checkout until the user acknowledges the change.
+	PriceRequireAck = "require_ack"
+)
+
+var ErrInvalidPricePolicy = errors.New("invalid price drift policy")
+
+type PriceDriftPolicy struct {
+	Mode        string
+	SnapshotTTL time.Duration
+}
+
+// ParsePriceDriftPolicy validates a policy mode.
+func ParsePriceDriftPolicy(mode string, snapshotTTL time.Duration) (PriceDriftPolicy, error) {
+	switch mode {
+	case PriceHonourSnapshot, PriceUseCurrent, PriceRequireAck:
+		return PriceDriftPolicy{Mode: mode, SnapshotTTL: snapshotTTL}, nil
+	}
+	return PriceDriftPolicy{}, fmt.Errorf("%w: %s", ErrInvalidPricePolicy, mode)
+}
+
+// Honours reports whether a line keeps its snapshot price at time now when
+// the catalog price has changed.
+func (p PriceDriftPolicy) Honours(item BasketItem, now time.Time) bool {
+	switch p.Mode {
+	case PriceHonourSnapshot:
+		return !item.PriceSnapshotAt.IsZero() && now.Sub(item.PriceSnapshotAt) < p.SnapshotTTL
+	case PriceRequireAck:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package pricing
+
+import (
+	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/promotion"
+	"daprps/internal/money"
+)
+
+// Quote is the full price of a basket: subtotal, discounts, shipping and
+// taxes. Total is the amount the customer pays.
+type Quote struct {
+	Subtotal  money.Money
+	Discounts []promotion.Discount
+	Shipping  *model.ShippingLine
+	Taxes     []model.TaxLine
+	Total     money.Money
+}
+
+// Pipeline prices a basket by applying coupons, then shipping, then taxes.
+type Pipeline struct {
+	shipping model.ShippingCalculator
+	tax      model.TaxCalculator
+}
+
+func NewPipeline(shipping model.ShippingCalculator, tax model.TaxCalculator) *Pipeline {
+	return &Pipeline{
+		shipping: shipping,
+		tax:      tax,
+	}
+}
+
+// Quote prices a basket. Shipping and tax need a destination and are left
+// out until the basket has one. On error the returned quote still holds the
+// discounted goods total.
+func (p *Pipeline) Quote(basket *model.Basket, coupons []*model.Coupon) (*Quote, error) {
+	breakdown, err := promotion.Price(basket.Items, coupons)
+	if err != nil {
+		return &Quote{}, err
+	}
+	quote := &Quote{
+		Subtotal:  breakdown.Subtotal,
+		Discounts: breakdown.Discounts,
+		Total:     breakdown.Total,
+	}
+	if basket.Destination == nil || len(basket.Items) == 0 {
+		return quote, nil
+	}
+
+	shipping, err := p.shipping.Shipping(*basket.Destination, basket.Items, breakdown.Total)
+	if err != nil {
+		return quote, err
+	}
+
+	taxes, err := p.tax.Tax(*basket.Destination, breakdown.Total, shipping.Amount)
+	if err != nil {
+		return quote, err
+	}
+
+	total, err := breakdown.Total.Add(shipping.Amount)
+	if err != nil {
+		return quote, err
+	}
+	for _, tax := range taxes {
+		if total, err = total.Add(tax.Amount); err != nil {
+			return quote, err
+		}
+	}
+
+	quote.Shipping = shipping
+	quote.Taxes = taxes
+	quote.Total = total
+	return quote, nil
+}
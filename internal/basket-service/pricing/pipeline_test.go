@@ -0,0 +1,180 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+func item(productID string, cents int64, quantity, weightGrams int32) model.BasketItem {
+	return model.BasketItem{
+		ProductID:   productID,
+		Price:       usd(cents),
+		Quantity:    quantity,
+		WeightGrams: weightGrams,
+	}
+}
+
+func defaultPipeline() *Pipeline {
+	rules := DefaultRules()
+	return NewPipeline(NewShippingTable(rules.Shipping), NewTaxTable(rules.Tax))
+}
+
+func TestQuote(t *testing.T) {
+	germany := &model.Address{Country: "DE"}
+	us := &model.Address{Country: "US"}
+	tenPercent := &model.Coupon{Code: "P10", Type: model.DiscountPercentage, Percent: 10}
+	tenOff := &model.Coupon{Code: "F10", Type: model.DiscountFixed, Amount: usd(1000)}
+
+	tests := []struct {
+		name        string
+		items       []model.BasketItem
+		destination *model.Address
+		coupons     []*model.Coupon
+		shipping    string // empty when no shipping line is expected
+		shippingFee int64
+		taxes       []int64
+		total       int64
+	}{
+		{"no destination", []model.BasketItem{item("a", 1000, 2, 500)}, nil, nil,
+			"", 0, nil, 2000},
+		{"no destination with a coupon", []model.BasketItem{item("a", 1000, 2, 500)}, nil, []*model.Coupon{tenPercent},
+			"", 0, nil, 1800},
+		{"empty basket", nil, germany, nil,
+			"", 0, nil, 0},
+		// 19% of 25.00 + 4.99 is 5.6981
+		{"standard shipping taxed with the goods", []model.BasketItem{item("a", 1000, 2, 500), item("b", 500, 1, 0)}, germany, nil,
+			"Standard", 499, []int64{570}, 3569},
+		// 19% of 22.50 + 4.99 is 5.2231
+		{"tax on the discounted total", []model.BasketItem{item("a", 1000, 2, 500), item("b", 500, 1, 0)}, germany, []*model.Coupon{tenPercent},
+			"Standard", 499, []int64{522}, 3271},
+		{"free shipping", []model.BasketItem{item("a", 10000, 1, 1000)}, germany, nil,
+			"Free shipping", 0, []int64{1900}, 11900},
+		{"free shipping needs the discounted total", []model.BasketItem{item("a", 10500, 1, 1000)}, germany, []*model.Coupon{tenOff},
+			"Standard", 499, []int64{1900}, 11899},
+		{"heavy", []model.BasketItem{item("a", 1000, 2, 3000)}, us, nil,
+			"Standard (heavy)", 999, nil, 2999},
+		{"freight", []model.BasketItem{item("a", 1000, 1, 25000)}, us, nil,
+			"Freight", 2999, nil, 3999},
+		{"heavy and worth free shipping", []model.BasketItem{item("a", 20000, 1, 25000)}, us, nil,
+			"Freight", 2999, nil, 22999},
+		{"country without tax", []model.BasketItem{item("a", 1000, 1, 100)}, us, nil,
+			"Standard", 499, nil, 1499},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			basket := &model.Basket{Items: tt.items, Destination: tt.destination}
+			quote, err := defaultPipeline().Quote(basket, tt.coupons)
+			if err != nil {
+				t.Fatalf("Quote failed: %v", err)
+			}
+
+			switch {
+			case tt.shipping == "" && quote.Shipping != nil:
+				t.Errorf("shipping = %+v, want none", quote.Shipping)
+			case tt.shipping != "" && quote.Shipping == nil:
+				t.Errorf("no shipping, want %s", tt.shipping)
+			case tt.shipping != "" && (quote.Shipping.Name != tt.shipping || quote.Shipping.Amount != usd(tt.shippingFee)):
+				t.Errorf("shipping = %+v, want %s at %v", quote.Shipping, tt.shipping, usd(tt.shippingFee))
+			}
+			if len(quote.Taxes) != len(tt.taxes) {
+				t.Fatalf("taxes = %+v, want %v", quote.Taxes, tt.taxes)
+			}
+			for i, tax := range quote.Taxes {
+				if tax.Amount != usd(tt.taxes[i]) {
+					t.Errorf("tax %s = %v, want %v", tax.Name, tax.Amount, usd(tt.taxes[i]))
+				}
+			}
+			if quote.Total != usd(tt.total) {
+				t.Errorf("total = %v, want %v", quote.Total, usd(tt.total))
+			}
+		})
+	}
+}
+
+func TestQuoteWithoutShippingRate(t *testing.T) {
+	pipeline := NewPipeline(
+		NewShippingTable([]ShippingRate{{Country: "DE", Name: "Domestic", Price: usd(499)}}),
+		NewTaxTable(nil),
+	)
+	basket := &model.Basket{
+		Items:       []model.BasketItem{item("a", 1000, 1, 100)},
+		Destination: &model.Address{Country: "US"},
+	}
+
+	quote, err := pipeline.Quote(basket, nil)
+	if !errors.Is(err, model.ErrNoShippingRate) {
+		t.Fatalf("err = %v, want ErrNoShippingRate", err)
+	}
+	if quote.Total != usd(1000) {
+		t.Errorf("total = %v, want the goods total", quote.Total)
+	}
+}
+
+func TestShippingTable(t *testing.T) {
+	table := NewShippingTable([]ShippingRate{
+		{Country: "US", Region: "AK", Name: "Alaska", Price: usd(1999)},
+		{Country: "US", Name: "Domestic", Price: usd(599)},
+		{Country: "DE", Name: "Inland", Price: money.New(499, "EUR")},
+	})
+
+	tests := []struct {
+		name        string
+		destination model.Address
+		value       money.Money
+		want        string
+	}{
+		{"region", model.Address{Country: "us", Region: "ak"}, usd(1000), "Alaska"},
+		{"country", model.Address{Country: "US", Region: "NY"}, usd(1000), "Domestic"},
+		{"currency of the basket", model.Address{Country: "DE"}, money.New(1000, "EUR"), "Inland"},
+		{"rate in another currency", model.Address{Country: "DE"}, usd(1000), ""},
+		{"unknown country", model.Address{Country: "FR"}, usd(1000), ""},
+	}
+	for _, tt := range tests {
+		line, err := table.Shipping(tt.destination, nil, tt.value)
+		switch {
+		case tt.want == "" && !errors.Is(err, model.ErrNoShippingRate):
+			t.Errorf("%s: Shipping = %+v, %v, want ErrNoShippingRate", tt.name, line, err)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: Shipping failed: %v", tt.name, err)
+		case tt.want != "" && line.Name != tt.want:
+			t.Errorf("%s: Shipping = %s, want %s", tt.name, line.Name, tt.want)
+		}
+	}
+}
+
+func TestTaxTable(t *testing.T) {
+	table := NewTaxTable([]TaxRule{
+		{Country: "US", Name: "Federal", Rate: 5},
+		{Country: "US", Region: "CA", Name: "California", Rate: 2.5, Shipping: true},
+	})
+
+	tests := []struct {
+		name        string
+		destination model.Address
+		want        map[string]int64
+	}{
+		// 2.5% of 10.00 + 5.00 is 0.375
+		{"country and region rates stack", model.Address{Country: "us", Region: "ca"}, map[string]int64{"Federal": 50, "California": 38}},
+		{"country rate only", model.Address{Country: "US", Region: "NY"}, map[string]int64{"Federal": 50}},
+		{"no rate", model.Address{Country: "CA"}, map[string]int64{}},
+	}
+	for _, tt := range tests {
+		lines, err := table.Tax(tt.destination, usd(1000), usd(500))
+		if err != nil {
+			t.Fatalf("%s: Tax failed: %v", tt.name, err)
+		}
+		if len(lines) != len(tt.want) {
+			t.Errorf("%s: taxes = %+v, want %v", tt.name, lines, tt.want)
+			continue
+		}
+		for _, line := range lines {
+			if want, ok := tt.want[line.Name]; !ok || line.Amount != usd(want) {
+				t.Errorf("%s: tax %s = %v, want %v", tt.name, line.Name, line.Amount, usd(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+// TaxRule charges Rate percent on baskets shipped to Country (empty for any
+// country), or only to Region of it when Region is set. Every matching rule is charged, so a
+// country rate and a regional rate stack.
+type TaxRule struct {
+	Country string  `json:"country"`
+	Region  string  `json:"region"`
+	Name    string  `json:"name"`
+	Rate    float64 `json:"rate"`
+	// Shipping makes the shipping charge taxable as well.
+	Shipping bool `json:"shipping"`
+}
+
+// ShippingRate is one band of a shipping table. A rate matches when the
+// destination is in Country (empty for any country) and Region (empty for any
+// region), the basket weighs at most MaxWeightGrams (0 for no limit) and is
+// worth at least MinValue. Rates only match baskets in the currency of their
+// Price. The first matching rate is used. Amounts given as plain numbers in
+// the rules file are in major units of the default currency.
+type ShippingRate struct {
+	Country        string      `json:"country"`
+	Region         string      `json:"region"`
+	Name           string      `json:"name"`
+	MaxWeightGrams int32       `json:"max_weight_grams"`
+	MinValue       money.Money `json:"min_value"`
+	Price          money.Money `json:"price"`
+}
+
+// Rules holds the tax and shipping tables.
+type Rules struct {
+	Tax      []TaxRule      `json:"tax"`
+	Shipping []ShippingRate `json:"shipping"`
+}
+
+// DefaultRules returns the tables used when no rules file is configured.
+func DefaultRules() *Rules {
+	return &Rules{
+		Tax: []TaxRule{
+			{Country: "TR", Name: "KDV", Rate: 20, Shipping: true},
+			{Country: "DE", Name: "MwSt", Rate: 19, Shipping: true},
+			{Country: "FR", Name: "TVA", Rate: 20, Shipping: true},
+			{Country: "NL", Name: "BTW", Rate: 21, Shipping: true},
+			{Country: "GB", Name: "VAT", Rate: 20, Shipping: true},
+		},
+		Shipping: []ShippingRate{
+			{Name: "Free shipping", MaxWeightGrams: 20000, MinValue: usd(10000), Price: usd(0)},
+			{Name: "Standard", MaxWeightGrams: 5000, Price: usd(499)},
+			{Name: "Standard (heavy)", MaxWeightGrams: 20000, Price: usd(999)},
+			{Name: "Freight", Price: usd(2999)},
+		},
+	}
+}
+
+// LoadRules reads tax and shipping tables from a JSON file.
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pricing rules: %w", err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing pricing rules: %w", err)
+	}
+	return &rules, nil
+}
+
+// TaxTable is a TaxCalculator backed by a list of TaxRules.
+type TaxTable struct {
+	rules []TaxRule
+}
+
+func NewTaxTable(rules []TaxRule) *TaxTable {
+	return &TaxTable{rules: rules}
+}
+
+func (t *TaxTable) Tax(destination model.Address, value, shipping money.Money) ([]model.TaxLine, error) {
+	var lines []model.TaxLine
+	for _, rule := range t.rules {
+		if !matches(rule.Country, rule.Region, destination) {
+			continue
+		}
+
+		base := value
+		if rule.Shipping {
+			var err error
+			if base, err = value.Add(shipping); err != nil {
+				return nil, err
+			}
+		}
+		lines = append(lines, model.TaxLine{
+			Name:   rule.Name,
+			Rate:   rule.Rate,
+			Amount: base.Percent(rule.Rate),
+		})
+	}
+	return lines, nil
+}
+
+// ShippingTable is a ShippingCalculator backed by a list of ShippingRates.
+type ShippingTable struct {
+	rates []ShippingRate
+}
+
+func NewShippingTable(rates []ShippingRate) *ShippingTable {
+	return &ShippingTable{rates: rates}
+}
+
+func (t *ShippingTable) Shipping(destination model.Address, items []model.BasketItem, value money.Money) (*model.ShippingLine, error) {
+	var weight int32
+	for _, item := range items {
+		weight += item.WeightGrams * item.Quantity
+	}
+
+	for _, rate := range t.rates {
+		if !matches(rate.Country, rate.Region, destination) {
+			continue
+		}
+		if rate.MaxWeightGrams > 0 && weight > rate.MaxWeightGrams {
+			continue
+		}
+		if _, err := value.Cmp(rate.Price); err != nil {
+			continue
+		}
+		if c, err := value.Cmp(rate.MinValue); err != nil || c < 0 {
+			continue
+		}
+		return &model.ShippingLine{Name: rate.Name, Amount: rate.Price}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", model.ErrNoShippingRate, destination.Country)
+}
+
+func matches(country, region string, destination model.Address) bool {
+	if country != "" && !strings.EqualFold(country, destination.Country) {
+		return false
+	}
+	if region != "" && !strings.EqualFold(region, destination.Region) {
+		return false
+	}
+	return true
+}
+
+func usd(cents int64) money.Money {
+	return money.New(cents, "USD")
+}
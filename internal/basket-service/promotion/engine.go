@@ -0,0 +1,149 @@
+package promotion
+
+import (
+	"fmt"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+// Discount is one discount line of a basket breakdown.
+type Discount struct {
+	Code        string
+	Description string
+	Amount      money.Money
+}
+
+// Breakdown is the priced basket: the subtotal of all lines, the discount of
+// each applied coupon and the final total.
+type Breakdown struct {
+	Subtotal  money.Money
+	Discounts []Discount
+	Total     money.Money
+}
+
+// Validate checks a coupon definition before it is stored.
+func Validate(coupon *model.Coupon) error {
+	if coupon.Code == "" {
+		return fmt.Errorf("%w: code is required", model.ErrInvalidCoupon)
+	}
+	if !coupon.EndsAt.IsZero() && !coupon.EndsAt.After(coupon.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", model.ErrInvalidCoupon)
+	}
+	if coupon.UsageLimit < 0 || coupon.MinSubtotal.Amount < 0 {
+		return fmt.Errorf("%w: usage_limit and min_subtotal must not be negative", model.ErrInvalidCoupon)
+	}
+
+	switch coupon.Type {
+	case model.DiscountPercentage:
+		if coupon.Percent <= 0 || coupon.Percent > 100 {
+			return fmt.Errorf("%w: percentage must be between 0 and 100", model.ErrInvalidCoupon)
+		}
+	case model.DiscountFixed:
+		if !coupon.Amount.IsPositive() {
+			return fmt.Errorf("%w: amount must be positive", model.ErrInvalidCoupon)
+		}
+	case model.DiscountThreshold:
+		if !coupon.Amount.IsPositive() || !coupon.MinSubtotal.IsPositive() {
+			return fmt.Errorf("%w: amount and min_subtotal must be positive", model.ErrInvalidCoupon)
+		}
+		if _, err := coupon.Amount.Cmp(coupon.MinSubtotal); err != nil {
+			return fmt.Errorf("%w: %v", model.ErrInvalidCoupon, err)
+		}
+	case model.DiscountBuyXGetY:
+		if coupon.BuyQuantity <= 0 || coupon.GetQuantity <= 0 {
+			return fmt.Errorf("%w: buy_quantity and get_quantity must be positive", model.ErrInvalidCoupon)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", model.ErrInvalidCoupon, coupon.Type)
+	}
+	return nil
+}
+
+// Price computes the breakdown of a basket with the given coupons, applied in
+// order. Coupons that do not apply, including coupons in another currency
+// than the basket, contribute no discount line, and the total never drops
+// below zero. Percentages are rounded half to even.
+func Price(items []model.BasketItem, coupons []*model.Coupon) (*Breakdown, error) {
+	basket := &model.Basket{Items: items}
+	subtotal, err := basket.Subtotal()
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := &Breakdown{Subtotal: subtotal}
+	remaining := subtotal
+	for _, coupon := range coupons {
+		discount, ok := discountAmount(items, coupon, subtotal.Currency)
+		if !ok {
+			continue
+		}
+		amount, err := discount.Min(remaining)
+		if err != nil || !amount.IsPositive() {
+			continue
+		}
+		if remaining, err = remaining.Sub(amount); err != nil {
+			return nil, err
+		}
+		breakdown.Discounts = append(breakdown.Discounts, Discount{
+			Code:        coupon.Code,
+			Description: describe(coupon),
+			Amount:      amount,
+		})
+	}
+
+	breakdown.Total = remaining
+	return breakdown, nil
+}
+
+// discountAmount returns the discount a coupon gives on a basket in currency,
+// or false when it does not apply.
+func discountAmount(items []model.BasketItem, coupon *model.Coupon, currency string) (money.Money, bool) {
+	eligible := money.Zero(currency)
+	free := money.Zero(currency)
+	for _, item := range items {
+		if !coupon.Applies(item) {
+			continue
+		}
+		eligible.Amount += item.Price.Mul(int64(item.Quantity)).Amount
+
+		if coupon.Type == model.DiscountBuyXGetY {
+			group := coupon.BuyQuantity + coupon.GetQuantity
+			free.Amount += item.Price.Mul(int64(item.Quantity / group * coupon.GetQuantity)).Amount
+		}
+	}
+
+	if !eligible.IsPositive() {
+		return money.Money{}, false
+	}
+	if coupon.MinSubtotal.IsPositive() {
+		if c, err := eligible.Cmp(coupon.MinSubtotal); err != nil || c < 0 {
+			return money.Money{}, false
+		}
+	}
+
+	switch coupon.Type {
+	case model.DiscountPercentage:
+		return eligible.Percent(coupon.Percent), true
+	case model.DiscountFixed, model.DiscountThreshold:
+		amount, err := coupon.Amount.Min(eligible)
+		return amount, err == nil
+	case model.DiscountBuyXGetY:
+		return free, true
+	}
+	return money.Money{}, false
+}
+
+func describe(coupon *model.Coupon) string {
+	switch coupon.Type {
+	case model.DiscountPercentage:
+		return fmt.Sprintf("%g%% off", coupon.Percent)
+	case model.DiscountFixed:
+		return fmt.Sprintf("%s off", coupon.Amount)
+	case model.DiscountThreshold:
+		return fmt.Sprintf("%s off orders over %s", coupon.Amount, coupon.MinSubtotal)
+	case model.DiscountBuyXGetY:
+		return fmt.Sprintf("buy %d get %d free", coupon.BuyQuantity, coupon.GetQuantity)
+	}
+	return coupon.Type
+}
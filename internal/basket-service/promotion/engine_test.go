@@ -0,0 +1,185 @@
+package promotion
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+func line(productID, category string, cents int64, quantity int32) model.BasketItem {
+	return model.BasketItem{
+		ProductID: productID,
+		Category:  category,
+		Price:     money.New(cents, "USD"),
+		Quantity:  quantity,
+	}
+}
+
+func usd(cents int64) money.Money {
+	return money.New(cents, "USD")
+}
+
+func percentage(code string, percent float64) *model.Coupon {
+	return &model.Coupon{Code: code, Type: model.DiscountPercentage, Percent: percent}
+}
+
+func fixed(code string, amount money.Money) *model.Coupon {
+	return &model.Coupon{Code: code, Type: model.DiscountFixed, Amount: amount}
+}
+
+func threshold(code string, amount, minSubtotal money.Money) *model.Coupon {
+	return &model.Coupon{Code: code, Type: model.DiscountThreshold, Amount: amount, MinSubtotal: minSubtotal}
+}
+
+func buyXGetY(code string, buy, get int32) *model.Coupon {
+	return &model.Coupon{Code: code, Type: model.DiscountBuyXGetY, BuyQuantity: buy, GetQuantity: get}
+}
+
+func scoped(coupon *model.Coupon, productIDs, categories []string) *model.Coupon {
+	coupon.ProductIDs = productIDs
+	coupon.Categories = categories
+	return coupon
+}
+
+func TestPrice(t *testing.T) {
+	// 25.00 USD: two of a at 10.00 and a book at 5.00
+	basket := []model.BasketItem{line("a", "toys", 1000, 2), line("b", "books", 500, 1)}
+
+	tests := []struct {
+		name      string
+		items     []model.BasketItem
+		coupons   []*model.Coupon
+		discounts []int64 // amount of each discount line, in order
+		total     int64
+	}{
+		{"no coupons", basket, nil, nil, 2500},
+		{"empty basket", nil, []*model.Coupon{percentage("P10", 10)}, nil, 0},
+
+		{"percentage", basket, []*model.Coupon{percentage("P10", 10)}, []int64{250}, 2250},
+		{"percentage rounds half to even", []model.BasketItem{line("a", "", 125, 1)},
+			[]*model.Coupon{percentage("P10", 10)}, []int64{12}, 113},
+		{"percentage rounds half to even upwards", []model.BasketItem{line("a", "", 135, 1)},
+			[]*model.Coupon{percentage("P10", 10)}, []int64{14}, 121},
+		{"full percentage", basket, []*model.Coupon{percentage("P100", 100)}, []int64{2500}, 0},
+
+		{"fixed", basket, []*model.Coupon{fixed("F5", usd(500))}, []int64{500}, 2000},
+		{"fixed capped at the basket", []model.BasketItem{line("a", "", 300, 1)},
+			[]*model.Coupon{fixed("F5", usd(500))}, []int64{300}, 0},
+
+		{"buy 2 get 1 with one group", []model.BasketItem{line("a", "", 1000, 3)},
+			[]*model.Coupon{buyXGetY("B2G1", 2, 1)}, []int64{1000}, 2000},
+		{"buy 2 get 1 with an incomplete group", []model.BasketItem{line("a", "", 1000, 5)},
+			[]*model.Coupon{buyXGetY("B2G1", 2, 1)}, []int64{1000}, 4000},
+		{"buy 2 get 1 with two groups", []model.BasketItem{line("a", "", 1000, 6)},
+			[]*model.Coupon{buyXGetY("B2G1", 2, 1)}, []int64{2000}, 4000},
+		{"buy 2 get 1 counts each line on its own", []model.BasketItem{line("a", "", 1000, 2), line("b", "", 500, 2)},
+			[]*model.Coupon{buyXGetY("B2G1", 2, 1)}, nil, 3000},
+		{"buy 2 get 1 below the group size", []model.BasketItem{line("a", "", 1000, 2)},
+			[]*model.Coupon{buyXGetY("B2G1", 2, 1)}, nil, 2000},
+
+		{"threshold reached", basket, []*model.Coupon{threshold("T10", usd(1000), usd(2000))}, []int64{1000}, 1500},
+		{"threshold reached exactly", basket, []*model.Coupon{threshold("T10", usd(1000), usd(2500))}, []int64{1000}, 1500},
+		{"threshold missed", basket, []*model.Coupon{threshold("T10", usd(1000), usd(2501))}, nil, 2500},
+
+		{"scoped to a product", basket,
+			[]*model.Coupon{scoped(percentage("P50", 50), []string{"b"}, nil)}, []int64{250}, 2250},
+		{"scoped to a category", basket,
+			[]*model.Coupon{scoped(percentage("P50", 50), nil, []string{"toys"})}, []int64{1000}, 1500},
+		{"scoped fixed capped at the eligible lines", basket,
+			[]*model.Coupon{scoped(fixed("F10", usd(1000)), nil, []string{"books"})}, []int64{500}, 2000},
+		{"scoped to lines not in the basket", basket,
+			[]*model.Coupon{scoped(percentage("P50", 50), []string{"z"}, []string{"food"})}, nil, 2500},
+		{"scoped threshold counts only the eligible lines", basket,
+			[]*model.Coupon{scoped(threshold("T2", usd(200), usd(1000)), nil, []string{"books"})}, nil, 2500},
+		{"scoped buy x get y", []model.BasketItem{line("a", "toys", 1000, 3), line("b", "books", 500, 3)},
+			[]*model.Coupon{scoped(buyXGetY("B2G1", 2, 1), nil, []string{"books"})}, []int64{500}, 4000},
+
+		{"stacked coupons apply in order", basket,
+			[]*model.Coupon{percentage("P10", 10), fixed("F5", usd(500))}, []int64{250, 500}, 1750},
+		{"stacked percentages both apply to the subtotal", basket,
+			[]*model.Coupon{percentage("P10", 10), percentage("P20", 20)}, []int64{250, 500}, 1750},
+		{"stacked discounts stop at zero", basket,
+			[]*model.Coupon{fixed("F24", usd(2400)), percentage("P10", 10)}, []int64{2400, 100}, 0},
+		{"stacked coupon with nothing left adds no line", basket,
+			[]*model.Coupon{percentage("P100", 100), fixed("F5", usd(500))}, []int64{2500}, 0},
+		{"stacked with one coupon that does not apply", basket,
+			[]*model.Coupon{threshold("T10", usd(1000), usd(5000)), fixed("F5", usd(500))}, []int64{500}, 2000},
+
+		{"fixed in another currency", basket,
+			[]*model.Coupon{fixed("EUR5", money.New(500, "EUR"))}, nil, 2500},
+		{"threshold in another currency", basket,
+			[]*model.Coupon{threshold("EUR10", money.New(1000, "EUR"), money.New(2000, "EUR"))}, nil, 2500},
+		{"percentage in a zero-decimal currency", []model.BasketItem{{ProductID: "a", Price: money.New(1005, "JPY"), Quantity: 1}},
+			[]*model.Coupon{percentage("P10", 10)}, []int64{100}, 905},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown, err := Price(tt.items, tt.coupons)
+			if err != nil {
+				t.Fatalf("Price failed: %v", err)
+			}
+
+			currency := (&model.Basket{Items: tt.items}).Currency()
+			if len(breakdown.Discounts) != len(tt.discounts) {
+				t.Fatalf("discounts = %+v, want %v", breakdown.Discounts, tt.discounts)
+			}
+			for i, discount := range breakdown.Discounts {
+				if want := money.New(tt.discounts[i], currency); discount.Amount != want {
+					t.Errorf("discount %d (%s) = %v, want %v", i, discount.Code, discount.Amount, want)
+				}
+			}
+			if want := money.New(tt.total, currency); breakdown.Total != want {
+				t.Errorf("total = %v, want %v", breakdown.Total, want)
+			}
+		})
+	}
+}
+
+func TestPriceMixedCurrencies(t *testing.T) {
+	items := []model.BasketItem{line("a", "", 1000, 1), {ProductID: "b", Price: money.New(500, "EUR"), Quantity: 1}}
+	if _, err := Price(items, nil); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("Price of a mixed basket: err = %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		coupon *model.Coupon
+		valid  bool
+	}{
+		{"percentage", percentage("P10", 10), true},
+		{"full percentage", percentage("P100", 100), true},
+		{"zero percentage", percentage("P0", 0), false},
+		{"percentage over 100", percentage("P101", 101), false},
+		{"fixed", fixed("F5", usd(500)), true},
+		{"fixed without amount", fixed("F0", usd(0)), false},
+		{"threshold", threshold("T10", usd(1000), usd(5000)), true},
+		{"threshold without minimum", threshold("T10", usd(1000), money.Money{}), false},
+		{"threshold in two currencies", threshold("T10", usd(1000), money.New(5000, "EUR")), false},
+		{"buy x get y", buyXGetY("B2G1", 2, 1), true},
+		{"buy x get nothing", buyXGetY("B2G0", 2, 0), false},
+		{"unknown type", &model.Coupon{Code: "X", Type: "bogus"}, false},
+		{"no code", percentage("", 10), false},
+		{"negative usage limit", &model.Coupon{Code: "P10", Type: model.DiscountPercentage, Percent: 10, UsageLimit: -1}, false},
+		{"ends before it starts", &model.Coupon{Code: "P10", Type: model.DiscountPercentage, Percent: 10,
+			StartsAt: now, EndsAt: now.Add(-time.Hour)}, false},
+		{"validity window", &model.Coupon{Code: "P10", Type: model.DiscountPercentage, Percent: 10,
+			StartsAt: now, EndsAt: now.Add(time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		err := Validate(tt.coupon)
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("%s: Validate failed: %v", tt.name, err)
+		case !tt.valid && !errors.Is(err, model.ErrInvalidCoupon):
+			t.Errorf("%s: err = %v, want ErrInvalidCoupon", tt.name, err)
+		}
+	}
+}
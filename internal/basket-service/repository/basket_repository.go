@@ -4,18 +4,64 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
 	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
 
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	deletedProductsKey = "products:deleted"
+	// activityKey indexes non-empty baskets by their last update time.
+	activityKey = "baskets:activity"
+	// abandonedKey holds abandoned baskets, scored by their last activity.
+	abandonedKey = "baskets:abandoned"
+)
+
+// markAbandonedScript moves a basket from the activity index to the abandoned
+// set if it has not been updated since ARGV[2]. It returns 1 if the basket was
+// moved, so that only one scanner reports it.
+var markAbandonedScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], score, ARGV[1])
+return 1
+`)
+
+// unmarkAbandonedScript moves a basket back from the abandoned set to the
+// activity index, unless it has been updated in the meantime.
+var unmarkAbandonedScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[2], ARGV[1])
+if not score then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[1], 'NX', score, ARGV[1])
+return 1
+`)
+
+// maxTxRetries bounds how often a basket mutation is retried after losing an
+// optimistic transaction to a concurrent writer.
+const maxTxRetries = 10
+
 type BasketRepositoryImpl struct {
-	client *redis.Client
+	client    *redis.Client
+	ttl       time.Duration
+	retention time.Duration
 }
 
-func NewBasketRepository(addr, password string, db int) model.BasketRepository {
+// NewBasketRepository creates a Redis basket repository. Baskets expire ttl
+// after their last update. The repository is also the BasketHistory of its
+// baskets, whose histories are kept retention after their last change.
+func NewBasketRepository(addr, password string, db int, ttl, retention time.Duration) model.BasketRepository {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -23,168 +69,497 @@ func NewBasketRepository(addr, password string, db int) model.BasketRepository {
 	})
 
 	return &BasketRepositoryImpl{
-		client: client,
+		client:    client,
+		ttl:       ttl,
+		retention: retention,
 	}
 }
 
 func (r *BasketRepositoryImpl) GetByUserID(userID string) (*model.Basket, error) {
+	return loadBasket(context.Background(), r.client, userID)
+}
+
+// Update applies fn to the stored basket and recalculates its total. The
+// read-modify-write runs in an optimistic transaction so that concurrent
+// updates of the same basket are never lost.
+func (r *BasketRepositoryImpl) Update(userID string, expectedVersion int64, fn func(basket *model.Basket) error) (*model.Basket, error) {
 	ctx := context.Background()
-	key := fmt.Sprintf("basket:%s", userID)
+	key := basketKey(userID)
+
+	var updated *model.Basket
+	err := r.withRetry(ctx, []string{key, lockKey(userID)}, func(tx *redis.Tx) error {
+		if err := ensureUnlocked(ctx, tx, userID); err != nil {
+			return err
+		}
+		basket, err := loadBasket(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(basket, expectedVersion); err != nil {
+			return err
+		}
+		if err := fn(basket); err != nil {
+			return err
+		}
 
-	data, err := r.client.Get(ctx, key).Result()
+		if err := updateTotal(basket); err != nil {
+			return err
+		}
+		touch(basket)
+		if err := r.saveBasket(ctx, tx, basket); err != nil {
+			return err
+		}
+		updated = basket
+		return nil
+	})
 	if err != nil {
-		if err == redis.Nil {
-			// Basket doesn't exist, return empty basket
-			return &model.Basket{
-				UserID:      userID,
-				Items:       []model.BasketItem{},
-				TotalAmount: 0,
-				CreatedAt:   time.Now(),
-				UpdatedAt:   time.Now(),
-			}, nil
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *BasketRepositoryImpl) AddItem(userID string, item model.BasketItem) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.AddItem(item)
+	})
+}
+
+func (r *BasketRepositoryImpl) RemoveItem(userID, productID string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.RemoveItem(productID)
+	})
+}
+
+func (r *BasketRepositoryImpl) UpdateQuantity(userID, productID string, quantity int32) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.SetQuantity(productID, quantity)
+	})
+}
+
+func (r *BasketRepositoryImpl) Clear(userID string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.Clear()
+	})
+}
+
+func (r *BasketRepositoryImpl) UpdatePrices(userID string, prices map[string]money.Money) error {
+	now := time.Now()
+	return r.mutate(userID, func(basket *model.Basket) {
+		for i, item := range basket.Items {
+			if price, ok := prices[item.ProductID]; ok {
+				basket.Items[i].Price = price
+				basket.Items[i].PriceSnapshotAt = now
+			}
 		}
-		return nil, fmt.Errorf("error getting basket: %w", err)
+	})
+}
+
+func (r *BasketRepositoryImpl) AddCoupon(userID, code string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.AddCoupon(code)
+	})
+}
+
+func (r *BasketRepositoryImpl) SetDestination(userID string, destination *model.Address) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.Destination = destination
+	})
+}
+
+func (r *BasketRepositoryImpl) RemoveCoupon(userID, code string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.RemoveCoupon(code)
+	})
+}
+
+func (r *BasketRepositoryImpl) UpdateTotalAmount(userID string, totalAmount money.Money) error {
+	ctx := context.Background()
+	key := basketKey(userID)
+
+	return r.withRetry(ctx, []string{key, lockKey(userID)}, func(tx *redis.Tx) error {
+		if err := ensureUnlocked(ctx, tx, userID); err != nil {
+			return err
+		}
+		basket, err := loadBasket(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		basket.TotalAmount = totalAmount
+		touch(basket)
+		return r.saveBasket(ctx, tx, basket)
+	})
+}
+
+// Merge moves the items of one basket into another and deletes the source
+// basket, all in one transaction. Lines present in both baskets are combined
+// according to policy.
+func (r *BasketRepositoryImpl) Merge(fromUserID, toUserID, policy string, check func(before []model.BasketItem, merged *model.Basket) error) (*model.Basket, error) {
+	switch policy {
+	case model.MergeSum, model.MergeMax, model.MergeNewest:
+	default:
+		return nil, fmt.Errorf("%w: %s", model.ErrInvalidMergePolicy, policy)
 	}
 
-	var basket model.Basket
-	err = json.Unmarshal([]byte(data), &basket)
+	ctx := context.Background()
+	fromKey := basketKey(fromUserID)
+	toKey := basketKey(toUserID)
+
+	var merged *model.Basket
+	keys := []string{fromKey, toKey, lockKey(fromUserID), lockKey(toUserID)}
+	err := r.withRetry(ctx, keys, func(tx *redis.Tx) error {
+		if err := ensureUnlocked(ctx, tx, fromUserID); err != nil {
+			return err
+		}
+		if err := ensureUnlocked(ctx, tx, toUserID); err != nil {
+			return err
+		}
+		from, err := loadBasket(ctx, tx, fromUserID)
+		if err != nil {
+			return err
+		}
+		to, err := loadBasket(ctx, tx, toUserID)
+		if err != nil {
+			return err
+		}
+
+		before := to.Items
+		to.Items = mergeItems(from, to, policy)
+		if to.Destination == nil {
+			to.Destination = from.Destination
+		}
+		for _, code := range from.CouponCodes {
+			if !containsString(to.CouponCodes, code) {
+				to.CouponCodes = append(to.CouponCodes, code)
+			}
+		}
+		if err := updateTotal(to); err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(before, to); err != nil {
+				return err
+			}
+		}
+		touch(to)
+
+		data, err := json.Marshal(to)
+		if err != nil {
+			return fmt.Errorf("error marshaling basket: %w", err)
+		}
+		toHistory, err := r.prepareHistory(ctx, tx, to)
+		if err != nil {
+			return err
+		}
+		fromHistory, err := r.prepareHistory(ctx, tx, &model.Basket{UserID: fromUserID, Version: model.NewBasketVersion})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueSave(ctx, pipe, to, data, toHistory)
+			r.queueHistory(ctx, pipe, fromHistory)
+			pipe.Del(ctx, fromKey)
+			pipe.ZRem(ctx, activityKey, fromUserID)
+			return nil
+		})
+		if err != nil {
+			if err == redis.TxFailedErr {
+				return err
+			}
+			return fmt.Errorf("error saving merged basket: %w", err)
+		}
+
+		merged = to
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling basket: %w", err)
+		return nil, err
 	}
 
-	return &basket, nil
+	return merged, nil
 }
 
-func (r *BasketRepositoryImpl) AddItem(userID string, item model.BasketItem) error {
-	basket, err := r.GetByUserID(userID)
+// GetIdleBaskets returns up to limit non-empty baskets that have not been
+// updated since idleSince.
+func (r *BasketRepositoryImpl) GetIdleBaskets(idleSince time.Time, limit int64) ([]string, error) {
+	userIDs, err := r.client.ZRangeByScore(context.Background(), activityKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(idleSince.UnixMilli(), 10),
+		Count: limit,
+	}).Result()
 	if err != nil {
-		return fmt.Errorf("error getting basket: %w", err)
+		return nil, fmt.Errorf("error getting idle baskets: %w", err)
 	}
+	return userIDs, nil
+}
 
-	// Check if item already exists
-	itemExists := false
-	for i, existingItem := range basket.Items {
-		if existingItem.ProductID == item.ProductID {
-			basket.Items[i].Quantity += item.Quantity
-			itemExists = true
-			break
-		}
+// MarkAbandoned flags a basket as abandoned if it is still idle since
+// idleSince. It reports false if the basket was updated or already claimed
+// by another scanner.
+func (r *BasketRepositoryImpl) MarkAbandoned(userID string, idleSince time.Time) (bool, error) {
+	moved, err := markAbandonedScript.Run(context.Background(), r.client,
+		[]string{activityKey, abandonedKey}, userID, activityScore(idleSince)).Int()
+	if err != nil {
+		return false, fmt.Errorf("error marking basket abandoned: %w", err)
 	}
+	return moved == 1, nil
+}
 
-	if !itemExists {
-		basket.Items = append(basket.Items, item)
+// UnmarkAbandoned reverts MarkAbandoned, for instance when the abandonment
+// could not be reported.
+func (r *BasketRepositoryImpl) UnmarkAbandoned(userID string) error {
+	err := unmarkAbandonedScript.Run(context.Background(), r.client,
+		[]string{activityKey, abandonedKey}, userID).Err()
+	if err != nil {
+		return fmt.Errorf("error unmarking abandoned basket: %w", err)
 	}
+	return nil
+}
 
-	// Recalculate total
-	basket.TotalAmount = 0
-	for _, item := range basket.Items {
-		basket.TotalAmount += item.Price * float64(item.Quantity)
+// GetAbandonedBaskets returns up to limit abandoned baskets with the time of
+// their last activity before they were abandoned.
+func (r *BasketRepositoryImpl) GetAbandonedBaskets(limit int64) (map[string]time.Time, error) {
+	entries, err := r.client.ZRangeWithScores(context.Background(), abandonedKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting abandoned baskets: %w", err)
 	}
 
-	basket.UpdatedAt = time.Now()
-
-	return r.saveBasket(basket)
+	abandoned := make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		abandoned[entry.Member.(string)] = time.UnixMilli(int64(entry.Score))
+	}
+	return abandoned, nil
 }
 
-func (r *BasketRepositoryImpl) RemoveItem(userID, productID string) error {
-	basket, err := r.GetByUserID(userID)
+// ClearAbandoned stops tracking an abandoned basket. It reports false if the
+// basket was not tracked, so that only one scanner reports its recovery.
+func (r *BasketRepositoryImpl) ClearAbandoned(userID string) (bool, error) {
+	removed, err := r.client.ZRem(context.Background(), abandonedKey, userID).Result()
 	if err != nil {
-		return fmt.Errorf("error getting basket: %w", err)
+		return false, fmt.Errorf("error clearing abandoned basket: %w", err)
 	}
+	return removed == 1, nil
+}
 
-	// Remove item
-	var newItems []model.BasketItem
-	for _, item := range basket.Items {
-		if item.ProductID != productID {
-			newItems = append(newItems, item)
-		}
+// SetProductDeleted records whether a product has been removed from the
+// catalog so that basket lines referring to it can be flagged.
+func (r *BasketRepositoryImpl) SetProductDeleted(productID string, deleted bool) error {
+	ctx := context.Background()
+
+	var err error
+	if deleted {
+		err = r.client.SAdd(ctx, deletedProductsKey, productID).Err()
+	} else {
+		err = r.client.SRem(ctx, deletedProductsKey, productID).Err()
 	}
+	if err != nil {
+		return fmt.Errorf("error updating deleted products: %w", err)
+	}
+	return nil
+}
 
-	basket.Items = newItems
+func (r *BasketRepositoryImpl) GetDeletedProducts(productIDs []string) (map[string]bool, error) {
+	deleted := make(map[string]bool)
+	if len(productIDs) == 0 {
+		return deleted, nil
+	}
 
-	// Recalculate total
-	basket.TotalAmount = 0
-	for _, item := range basket.Items {
-		basket.TotalAmount += item.Price * float64(item.Quantity)
+	ctx := context.Background()
+	members := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		members[i] = id
 	}
 
-	basket.UpdatedAt = time.Now()
+	flags, err := r.client.SMIsMember(ctx, deletedProductsKey, members...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting deleted products: %w", err)
+	}
+	for i, flag := range flags {
+		if flag {
+			deleted[productIDs[i]] = true
+		}
+	}
+	return deleted, nil
+}
 
-	return r.saveBasket(basket)
+// mutate applies fn to the stored basket whatever its version.
+func (r *BasketRepositoryImpl) mutate(userID string, fn func(basket *model.Basket)) error {
+	_, err := r.Update(userID, 0, func(basket *model.Basket) error {
+		fn(basket)
+		return nil
+	})
+	return err
 }
 
-func (r *BasketRepositoryImpl) UpdateQuantity(userID, productID string, quantity int32) error {
-	basket, err := r.GetByUserID(userID)
-	if err != nil {
-		return fmt.Errorf("error getting basket: %w", err)
+// withRetry runs txf with keys watched, retrying when another client modified
+// one of them before the transaction committed.
+func (r *BasketRepositoryImpl) withRetry(ctx context.Context, keys []string, txf func(tx *redis.Tx) error) error {
+	for attempt := 0; attempt < maxTxRetries; attempt++ {
+		err := r.client.Watch(ctx, txf, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+
+		// Back off with jitter so competing writers do not collide again
+		time.Sleep(time.Duration(attempt+1) * time.Duration(1+rand.Intn(5)) * time.Millisecond)
 	}
+	return fmt.Errorf("%w: %s", model.ErrBasketConflict, strings.Join(keys, ", "))
+}
 
-	// Update quantity
-	for i, item := range basket.Items {
-		if item.ProductID == productID {
-			if quantity <= 0 {
-				// Remove item if quantity is 0 or negative
-				basket.Items = append(basket.Items[:i], basket.Items[i+1:]...)
-			} else {
-				basket.Items[i].Quantity = quantity
+// mergeItems combines the lines of two baskets, keeping the order of the
+// target basket and appending lines only present in the source.
+func mergeItems(from, to *model.Basket, policy string) []model.BasketItem {
+	items := append([]model.BasketItem{}, to.Items...)
+	for _, incoming := range from.Items {
+		found := false
+		for i, existing := range items {
+			if existing.ProductID != incoming.ProductID {
+				continue
+			}
+			found = true
+
+			switch policy {
+			case model.MergeSum:
+				items[i].Quantity += incoming.Quantity
+			case model.MergeMax:
+				if incoming.Quantity > existing.Quantity {
+					items[i].Quantity = incoming.Quantity
+				}
+			case model.MergeNewest:
+				if itemAddedAt(incoming, from).After(itemAddedAt(existing, to)) {
+					items[i] = incoming
+				}
 			}
 			break
 		}
+
+		if !found {
+			items = append(items, incoming)
+		}
 	}
+	return items
+}
 
-	// Recalculate total
-	basket.TotalAmount = 0
-	for _, item := range basket.Items {
-		basket.TotalAmount += item.Price * float64(item.Quantity)
+// itemAddedAt falls back to the basket update time for lines stored before
+// price snapshots were recorded.
+func itemAddedAt(item model.BasketItem, basket *model.Basket) time.Time {
+	if !item.PriceSnapshotAt.IsZero() {
+		return item.PriceSnapshotAt
 	}
+	return basket.UpdatedAt
+}
 
-	basket.UpdatedAt = time.Now()
+// checkVersion fails with ErrVersionMismatch if expectedVersion is set and
+// differs from the basket's version.
+func checkVersion(basket *model.Basket, expectedVersion int64) error {
+	if expectedVersion != 0 && basket.Version != expectedVersion {
+		return fmt.Errorf("%w: expected %d, basket is at %d", model.ErrVersionMismatch, expectedVersion, basket.Version)
+	}
+	return nil
+}
 
-	return r.saveBasket(basket)
+// touch records an update of the basket. Versions follow the clock, in
+// microseconds, so that a basket stored again after it expired or was merged
+// away does not reuse the versions it had before.
+func touch(basket *model.Basket) {
+	now := time.Now()
+	basket.UpdatedAt = now
+	basket.Version = max(basket.Version+1, now.UnixMicro())
 }
 
-func (r *BasketRepositoryImpl) Clear(userID string) error {
-	basket, err := r.GetByUserID(userID)
-	if err != nil {
-		return fmt.Errorf("error getting basket: %w", err)
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
+}
 
-	basket.Items = []model.BasketItem{}
-	basket.TotalAmount = 0
-	basket.UpdatedAt = time.Now()
-
-	return r.saveBasket(basket)
+// updateTotal recalculates the total of a basket. It fails with
+// money.ErrCurrencyMismatch if the lines are priced in different currencies.
+func updateTotal(basket *model.Basket) error {
+	total, err := basket.Subtotal()
+	if err != nil {
+		return err
+	}
+	basket.TotalAmount = total
+	return nil
 }
 
-func (r *BasketRepositoryImpl) UpdateTotalAmount(userID string, totalAmount float64) error {
-	basket, err := r.GetByUserID(userID)
+func loadBasket(ctx context.Context, c redis.Cmdable, userID string) (*model.Basket, error) {
+	data, err := c.Get(ctx, basketKey(userID)).Result()
 	if err != nil {
-		return fmt.Errorf("error getting basket: %w", err)
+		if err == redis.Nil {
+			// Basket doesn't exist, return empty basket
+			return &model.Basket{
+				UserID:    userID,
+				Items:     []model.BasketItem{},
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+				Version:   model.NewBasketVersion,
+			}, nil
+		}
+		return nil, fmt.Errorf("error getting basket: %w", err)
 	}
 
-	basket.TotalAmount = totalAmount
-	basket.UpdatedAt = time.Now()
+	var basket model.Basket
+	err = json.Unmarshal([]byte(data), &basket)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling basket: %w", err)
+	}
 
-	return r.saveBasket(basket)
+	return &basket, nil
 }
 
-func (r *BasketRepositoryImpl) saveBasket(basket *model.Basket) error {
-	ctx := context.Background()
-	key := fmt.Sprintf("basket:%s", basket.UserID)
-
+// saveBasket writes the basket and its history inside a MULTI/EXEC block,
+// which fails with redis.TxFailedErr if the watched key changed since it was
+// read.
+func (r *BasketRepositoryImpl) saveBasket(ctx context.Context, tx *redis.Tx, basket *model.Basket) error {
 	data, err := json.Marshal(basket)
 	if err != nil {
 		return fmt.Errorf("error marshaling basket: %w", err)
 	}
+	history, err := r.prepareHistory(ctx, tx, basket)
+	if err != nil {
+		return err
+	}
 
-	err = r.client.Set(ctx, key, data, 24*time.Hour).Err()
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.queueSave(ctx, pipe, basket, data, history)
+		return nil
+	})
 	if err != nil {
+		if err == redis.TxFailedErr {
+			return err
+		}
 		return fmt.Errorf("error saving basket: %w", err)
 	}
 
 	return nil
 }
 
+// queueSave queues the commands that store a basket, refresh its TTL, append
+// its history and keep the activity index up to date. Empty baskets are not
+// tracked.
+func (r *BasketRepositoryImpl) queueSave(ctx context.Context, pipe redis.Pipeliner, basket *model.Basket, data []byte, history *historyWrite) {
+	pipe.Set(ctx, basketKey(basket.UserID), data, r.ttl)
+	r.queueHistory(ctx, pipe, history)
+	if len(basket.Items) > 0 {
+		pipe.ZAdd(ctx, activityKey, &redis.Z{Score: activityScore(basket.UpdatedAt), Member: basket.UserID})
+	} else {
+		pipe.ZRem(ctx, activityKey, basket.UserID)
+	}
+}
+
+func activityScore(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+func basketKey(userID string) string {
+	return fmt.Sprintf("basket:%s", userID)
+}
+
 func (r *BasketRepositoryImpl) Close() error {
 	return r.client.Close()
 }
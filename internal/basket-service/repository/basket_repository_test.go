@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/repository/repositorytest"
+	"daprps/internal/money"
+)
+
+// testRedisAddr returns the Redis server tests may use, skipping the test
+// when BASKET_TEST_REDIS_ADDR is not set.
+func testRedisAddr(t *testing.T) string {
+	t.Helper()
+	addr := os.Getenv("BASKET_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("BASKET_TEST_REDIS_ADDR not set")
+	}
+	return addr
+}
+
+func TestRedisRepositoryConformance(t *testing.T) {
+	repo := NewBasketRepository(testRedisAddr(t), "", 0, time.Hour, 24*time.Hour)
+	defer repo.(*BasketRepositoryImpl).Close()
+
+	if err := repositorytest.TestBasketRepository(repo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentUpdatesLoseNothing runs concurrent AddItem and UpdateQuantity
+// calls on one basket, which all go through withRetry, and checks that every
+// update that succeeded is in the stored basket.
+func TestConcurrentUpdatesLoseNothing(t *testing.T) {
+	repo := NewBasketRepository(testRedisAddr(t), "", 0, time.Hour, 24*time.Hour).(*BasketRepositoryImpl)
+	defer repo.Close()
+
+	const writers = 25
+	userID := fmt.Sprintf("concurrency-%d", time.Now().UnixNano())
+	defer func() {
+		ctx := repo.client.Context()
+		repo.client.Del(ctx, basketKey(userID))
+		repo.client.ZRem(ctx, activityKey, userID)
+	}()
+
+	line := func(productID string, quantity int32) model.BasketItem {
+		return model.BasketItem{
+			ProductID: productID,
+			Price:     money.New(100, money.DefaultCurrency),
+			Quantity:  quantity,
+		}
+	}
+
+	// One line per writer, so that each UpdateQuantity below has its own line
+	// whose quantity another writer could overwrite with a stale basket
+	for i := 0; i < writers; i++ {
+		if err := repo.AddItem(userID, line(fmt.Sprintf("own-%d", i), 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	addErrs := make(chan error, writers)
+	updated := make([]bool, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			addErrs <- repo.AddItem(userID, line("shared", 1))
+		}()
+		go func(i int) {
+			defer wg.Done()
+			err := repo.UpdateQuantity(userID, fmt.Sprintf("own-%d", i), int32(i+2))
+			if err != nil && !errors.Is(err, model.ErrBasketConflict) {
+				t.Error(err)
+			}
+			updated[i] = err == nil
+		}(i)
+	}
+	wg.Wait()
+	close(addErrs)
+
+	// Writers may give up with ErrBasketConflict after maxTxRetries, but no
+	// update that succeeded may be lost
+	var added int32
+	for err := range addErrs {
+		switch {
+		case err == nil:
+			added++
+		case !errors.Is(err, model.ErrBasketConflict):
+			t.Error(err)
+		}
+	}
+	if added == 0 {
+		t.Fatal("no concurrent AddItem succeeded")
+	}
+
+	basket, err := repo.GetByUserID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quantities := make(map[string]int32)
+	for _, item := range basket.Items {
+		quantities[item.ProductID] = item.Quantity
+	}
+	if quantities["shared"] != added {
+		t.Errorf("shared line has quantity %d, want %d from the successful adds", quantities["shared"], added)
+	}
+	// Every stored update increments the version exactly once
+	versions := int64(writers) + int64(added)
+	for i := 0; i < writers; i++ {
+		want := int32(1)
+		if updated[i] {
+			want = int32(i + 2)
+			versions++
+		}
+		if got := quantities[fmt.Sprintf("own-%d", i)]; got != want {
+			t.Errorf("line own-%d has quantity %d, want %d", i, got, want)
+		}
+	}
+	if basket.Version != versions {
+		t.Errorf("basket at version %d, want %d", basket.Version, versions)
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"daprps/internal/basket-service/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keyspaceEvents are the keyspace notification classes the watcher relies on:
+// K for keyspace channels, $ for SET, h for the hashes the Dapr state store
+// writes, g for DEL and x for expiry.
+const keyspaceEvents = "K$hgx"
+
+// KeyspaceBasketWatcher watches baskets through Redis keyspace notifications,
+// so it sees the writes of every replica.
+type KeyspaceBasketWatcher struct {
+	client    *redis.Client
+	pubsub    *redis.PubSub
+	channel   string // keyspace channel prefix of basket keys
+	keyPrefix string
+	watchers  basketWatchers
+}
+
+// NewKeyspaceBasketWatcher creates a watcher for baskets stored in the given
+// Redis database. keyPrefix is put in front of basket keys by the store, such
+// as "dapr:||" for the Dapr state store. The watcher tries to enable the
+// keyspace notifications it needs; where CONFIG is not allowed, Redis must be
+// started with notify-keyspace-events including them.
+func NewKeyspaceBasketWatcher(addr, password string, db int, keyPrefix string) model.BasketWatcher {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx := context.Background()
+	if err := enableKeyspaceEvents(ctx, client); err != nil {
+		log.Printf("Warning: could not enable Redis keyspace notifications, basket watches need notify-keyspace-events %q: %v", keyspaceEvents, err)
+	}
+
+	w := &KeyspaceBasketWatcher{
+		client:    client,
+		channel:   fmt.Sprintf("__keyspace@%d__:", db),
+		keyPrefix: keyPrefix,
+	}
+	w.pubsub = client.PSubscribe(ctx, w.channel+keyPrefix+basketKey("*"))
+	go w.run()
+	return w
+}
+
+func (w *KeyspaceBasketWatcher) Watch(userID string) (<-chan struct{}, func()) {
+	return w.watchers.watch(w.keyPrefix + basketKey(userID))
+}
+
+// run dispatches notifications until the watcher is closed. The pattern also
+// matches other keys of a basket, such as its lock, which nobody watches.
+func (w *KeyspaceBasketWatcher) run() {
+	for msg := range w.pubsub.Channel() {
+		w.watchers.notify(strings.TrimPrefix(msg.Channel, w.channel))
+	}
+}
+
+func (w *KeyspaceBasketWatcher) Close() error {
+	w.pubsub.Close()
+	return w.client.Close()
+}
+
+// enableKeyspaceEvents adds the notification classes the watcher needs to
+// those already enabled.
+func enableKeyspaceEvents(ctx context.Context, client *redis.Client) error {
+	result, err := client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	current := ""
+	if len(result) == 2 {
+		current, _ = result[1].(string)
+	}
+
+	flags := current
+	for _, c := range keyspaceEvents {
+		// A is an alias for all event classes
+		if strings.ContainsRune(flags, c) || c != 'K' && strings.ContainsRune(flags, 'A') {
+			continue
+		}
+		flags += string(c)
+	}
+	if flags == current {
+		return nil
+	}
+	return client.ConfigSet(ctx, "notify-keyspace-events", flags).Err()
+}
+
+// basketWatchers fans out change signals to the watchers of each key. The
+// zero value is ready to use.
+type basketWatchers struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]bool
+}
+
+func (w *basketWatchers) watch(key string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	if w.subs == nil {
+		w.subs = make(map[string]map[chan struct{}]bool)
+	}
+	if w.subs[key] == nil {
+		w.subs[key] = make(map[chan struct{}]bool)
+	}
+	w.subs[key][ch] = true
+	w.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.subs[key], ch)
+			if len(w.subs[key]) == 0 {
+				delete(w.subs, key)
+			}
+		})
+	}
+}
+
+// notify signals the watchers of key without blocking. A watcher that has not
+// taken the previous signal yet gets no second one.
+func (w *basketWatchers) notify(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subs[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"daprps/internal/basket-service/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// checkoutOrderTTL is how long completed checkout orders are remembered, so
+// that their payment-completed events are recognised.
+const checkoutOrderTTL = 7 * 24 * time.Hour
+
+// lockScript takes the checkout lock and returns the order of the checkout:
+// the pending order of an earlier checkout, or the new one.
+var lockScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[3]) then
+	return false
+end
+local orderID = redis.call("GET", KEYS[2])
+if not orderID then
+	orderID = ARGV[2]
+	redis.call("SET", KEYS[2], orderID, "PX", ARGV[4])
+end
+return orderID
+`)
+
+// unlockScript deletes a lock and the pending order only if the lock is
+// still held with the given token. The record of the pending order is
+// deleted with it if the order is still ARGV[2].
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	if redis.call("GET", KEYS[2]) == ARGV[2] then
+		redis.call("DEL", KEYS[3])
+	end
+	redis.call("DEL", KEYS[2])
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// LockBasket locks a basket against changes for the duration of a checkout.
+// The pending order is kept until the checkout completes or is unlocked, so
+// a checkout retried after its lock expired charges the same order.
+func (r *BasketRepositoryImpl) LockBasket(userID, orderID string, ttl time.Duration) (*model.CheckoutLock, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating lock token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	keys := []string{lockKey(userID), pendingOrderKey(userID)}
+	pending, err := lockScript.Run(context.Background(), r.client, keys, token, orderID, ttl.Milliseconds(), model.PendingOrderTTL.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, model.ErrBasketLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error locking basket: %w", err)
+	}
+	return &model.CheckoutLock{Token: token, OrderID: pending}, nil
+}
+
+func (r *BasketRepositoryImpl) UnlockBasket(userID, token string) error {
+	orderID, err := r.PendingOrder(userID)
+	if err != nil {
+		return fmt.Errorf("error unlocking basket: %w", err)
+	}
+	keys := []string{lockKey(userID), pendingOrderKey(userID), pendingChargeKey(orderID)}
+	if err := unlockScript.Run(context.Background(), r.client, keys, token, orderID).Err(); err != nil {
+		return fmt.Errorf("error unlocking basket: %w", err)
+	}
+	return nil
+}
+
+// SetPendingOrder stores the record of the order for as long as the order
+// can stay pending, and makes it the pending order of the basket.
+func (r *BasketRepositoryImpl) SetPendingOrder(order *model.PendingOrder) error {
+	ctx := context.Background()
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("error marshaling pending order: %w", err)
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, pendingChargeKey(order.OrderID), data, model.PendingOrderTTL)
+		pipe.Set(ctx, pendingOrderKey(order.UserID), order.OrderID, model.PendingOrderTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error setting pending order: %w", err)
+	}
+	return nil
+}
+
+func (r *BasketRepositoryImpl) GetPendingOrder(orderID string) (*model.PendingOrder, error) {
+	data, err := r.client.Get(context.Background(), pendingChargeKey(orderID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending order: %w", err)
+	}
+
+	var order model.PendingOrder
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, fmt.Errorf("error unmarshaling pending order: %w", err)
+	}
+	return &order, nil
+}
+
+// PendingOrder returns the order of a checkout that was neither completed nor
+// unlocked.
+func (r *BasketRepositoryImpl) PendingOrder(userID string) (string, error) {
+	orderID, err := r.client.Get(context.Background(), pendingOrderKey(userID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting pending order: %w", err)
+	}
+	return orderID, nil
+}
+
+// CompleteCheckout takes the lines of a paid order out of the basket, records
+// the order and releases the checkout lock in one transaction. The lock is
+// released if it is held with token or for the order, as when the payment
+// event completes a checkout.
+func (r *BasketRepositoryImpl) CompleteCheckout(userID, token string, order *model.PendingOrder) error {
+	ctx := context.Background()
+	key := lockKey(userID)
+	pendingKey := pendingOrderKey(userID)
+	orderID := order.OrderID
+
+	return r.withRetry(ctx, []string{basketKey(userID), key, pendingKey}, func(tx *redis.Tx) error {
+		basket, err := loadBasket(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		held, err := tx.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("error getting basket lock: %w", err)
+		}
+		pending, err := tx.Get(ctx, pendingKey).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("error getting pending order: %w", err)
+		}
+
+		basket.RemoveOrdered(order)
+		if err := updateTotal(basket); err != nil {
+			return err
+		}
+		touch(basket)
+
+		data, err := json.Marshal(basket)
+		if err != nil {
+			return fmt.Errorf("error marshaling basket: %w", err)
+		}
+		history, err := r.prepareHistory(ctx, tx, basket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueSave(ctx, pipe, basket, data, history)
+			pipe.Set(ctx, checkoutOrderKey(orderID), userID, checkoutOrderTTL)
+			pipe.Del(ctx, pendingChargeKey(orderID))
+			if held == token || pending == orderID {
+				pipe.Del(ctx, key)
+			}
+			if pending == orderID {
+				pipe.Del(ctx, pendingKey)
+			}
+			return nil
+		})
+		if err != nil && err != redis.TxFailedErr {
+			return fmt.Errorf("error completing checkout: %w", err)
+		}
+		return err
+	})
+}
+
+// IsCheckoutOrder reports whether an order was placed and completed through
+// CompleteCheckout.
+func (r *BasketRepositoryImpl) IsCheckoutOrder(orderID string) (bool, error) {
+	n, err := r.client.Exists(context.Background(), checkoutOrderKey(orderID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("error checking checkout order: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ensureUnlocked fails with ErrBasketLocked while a checkout holds the basket.
+// The lock key must be watched by the transaction.
+func ensureUnlocked(ctx context.Context, tx *redis.Tx, userID string) error {
+	n, err := tx.Exists(ctx, lockKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("error checking basket lock: %w", err)
+	}
+	if n > 0 {
+		return model.ErrBasketLocked
+	}
+	return nil
+}
+
+func lockKey(userID string) string {
+	return fmt.Sprintf("basket:%s:lock", userID)
+}
+
+func checkoutOrderKey(orderID string) string {
+	return fmt.Sprintf("checkout:%s", orderID)
+}
+
+func pendingOrderKey(userID string) string {
+	return fmt.Sprintf("basket:%s:pending", userID)
+}
+
+func pendingChargeKey(orderID string) string {
+	return fmt.Sprintf("checkout:%s:pending", orderID)
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"daprps/internal/basket-service/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// reserveScript holds a use of a coupon for an order in the coupon's hold
+// set, scored by expiry, unless the redeemed and held uses of other orders
+// reach the usage limit. Expired holds are dropped first.
+var reserveScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[3])
+if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 then
+	return 1
+end
+local limit = tonumber(ARGV[2])
+if limit > 0 and not redis.call("ZSCORE", KEYS[2], ARGV[1]) then
+	if redis.call("SCARD", KEYS[1]) + redis.call("ZCARD", KEYS[2]) >= limit then
+		return 0
+	end
+end
+redis.call("ZADD", KEYS[2], ARGV[4], ARGV[1])
+if redis.call("PTTL", KEYS[2]) < tonumber(ARGV[5]) then
+	redis.call("PEXPIRE", KEYS[2], ARGV[5])
+end
+return 1
+`)
+
+type CouponRepositoryImpl struct {
+	client *redis.Client
+}
+
+func NewCouponRepository(addr, password string, db int) model.CouponRepository {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	return &CouponRepositoryImpl{
+		client: client,
+	}
+}
+
+func (r *CouponRepositoryImpl) Save(coupon *model.Coupon) error {
+	ctx := context.Background()
+
+	data, err := json.Marshal(coupon)
+	if err != nil {
+		return fmt.Errorf("error marshaling coupon: %w", err)
+	}
+
+	if err := r.client.Set(ctx, couponKey(coupon.Code), data, 0).Err(); err != nil {
+		return fmt.Errorf("error saving coupon: %w", err)
+	}
+	return nil
+}
+
+func (r *CouponRepositoryImpl) Get(code string) (*model.Coupon, error) {
+	ctx := context.Background()
+
+	data, err := r.client.Get(ctx, couponKey(code)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("%w: %s", model.ErrCouponNotFound, code)
+		}
+		return nil, fmt.Errorf("error getting coupon: %w", err)
+	}
+
+	var coupon model.Coupon
+	if err := json.Unmarshal(data, &coupon); err != nil {
+		return nil, fmt.Errorf("error unmarshaling coupon: %w", err)
+	}
+	return &coupon, nil
+}
+
+func (r *CouponRepositoryImpl) Redemptions(code string) (int64, error) {
+	count, err := r.client.SCard(context.Background(), couponRedemptionsKey(code)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error getting coupon redemptions: %w", err)
+	}
+	return count, nil
+}
+
+// Reserve adds the order to the coupon's holds. The limit is checked
+// against the redemptions and the unexpired holds in the same script.
+func (r *CouponRepositoryImpl) Reserve(code, orderID string, limit int64, ttl time.Duration) error {
+	now := time.Now()
+	keys := []string{couponRedemptionsKey(code), couponHoldsKey(code)}
+	reserved, err := reserveScript.Run(context.Background(), r.client, keys, orderID, limit, now.UnixMilli(), now.Add(ttl).UnixMilli(), ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("error reserving coupon: %w", err)
+	}
+	if reserved == 0 {
+		return fmt.Errorf("%w: %s", model.ErrCouponUsageExceeded, code)
+	}
+	return nil
+}
+
+// Redeem moves the order from the coupon's holds to its redemption set, so
+// the count is idempotent per order.
+func (r *CouponRepositoryImpl) Redeem(code, orderID string) error {
+	ctx := context.Background()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, couponRedemptionsKey(code), orderID)
+		pipe.ZRem(ctx, couponHoldsKey(code), orderID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error redeeming coupon: %w", err)
+	}
+	return nil
+}
+
+func (r *CouponRepositoryImpl) Release(code, orderID string) error {
+	if err := r.client.ZRem(context.Background(), couponHoldsKey(code), orderID).Err(); err != nil {
+		return fmt.Errorf("error releasing coupon: %w", err)
+	}
+	return nil
+}
+
+func (r *CouponRepositoryImpl) Close() error {
+	return r.client.Close()
+}
+
+func couponKey(code string) string {
+	return fmt.Sprintf("coupon:%s", code)
+}
+
+func couponRedemptionsKey(code string) string {
+	return fmt.Sprintf("coupon:%s:redemptions", code)
+}
+
+func couponHoldsKey(code string) string {
+	return fmt.Sprintf("coupon:%s:holds", code)
+}
@@ -0,0 +1,933 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	mathrand "math/rand"
+	"time"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+const (
+	// activityShards spreads the activity index over several state entries.
+	// The index is updated after each basket transaction, not within it, so
+	// that basket writes never conflict on it.
+	activityShards = 16
+	// daprTimeout bounds each call to the Dapr sidecar.
+	daprTimeout = 5 * time.Second
+	// daprHistoryEvents caps a basket's history, which is read and written
+	// as one state entry with every change.
+	daprHistoryEvents = 1000
+)
+
+// daprBasket is the state stored per basket. The checkout lock is kept with
+// the basket so that mutations and locking are ordered by the same ETag.
+type daprBasket struct {
+	Basket *model.Basket `json:"basket"`
+	Lock   *daprLock     `json:"lock,omitempty"`
+	// PendingOrder is the order of a checkout that was neither completed
+	// nor unlocked.
+	PendingOrder string `json:"pending_order,omitempty"`
+}
+
+type daprLock struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DaprBasketRepository stores baskets in a Dapr state store through the
+// sidecar's state API. Concurrent updates are detected with ETags and
+// retried; multi-key updates use state transactions, so the store must
+// support transactions and TTLs (state.redis does). The history of each
+// basket is stored in the same transactions as its changes.
+type DaprBasketRepository struct {
+	state     *daprState
+	ttl       time.Duration
+	retention time.Duration
+}
+
+// NewDaprBasketRepository creates a basket repository on the state store
+// storeName of the Dapr sidecar at sidecarURL, e.g. http://localhost:3500.
+// Baskets expire ttl after their last update. The repository is also the
+// BasketHistory of its baskets, whose histories are kept retention after
+// their last change.
+func NewDaprBasketRepository(sidecarURL, storeName string, ttl, retention time.Duration) model.BasketRepository {
+	return &DaprBasketRepository{
+		state:     newDaprState(sidecarURL, storeName, daprTimeout),
+		ttl:       ttl,
+		retention: retention,
+	}
+}
+
+func (r *DaprBasketRepository) GetByUserID(userID string) (*model.Basket, error) {
+	tx := r.state.newTx(context.Background())
+	stored, err := r.loadBasket(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return stored.Basket, nil
+}
+
+func (r *DaprBasketRepository) AddItem(userID string, item model.BasketItem) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.AddItem(item)
+	})
+}
+
+func (r *DaprBasketRepository) RemoveItem(userID, productID string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.RemoveItem(productID)
+	})
+}
+
+func (r *DaprBasketRepository) UpdateQuantity(userID, productID string, quantity int32) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.SetQuantity(productID, quantity)
+	})
+}
+
+func (r *DaprBasketRepository) Clear(userID string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.Clear()
+	})
+}
+
+func (r *DaprBasketRepository) UpdatePrices(userID string, prices map[string]money.Money) error {
+	now := time.Now()
+	return r.mutate(userID, func(basket *model.Basket) {
+		for i, item := range basket.Items {
+			if price, ok := prices[item.ProductID]; ok {
+				basket.Items[i].Price = price
+				basket.Items[i].PriceSnapshotAt = now
+			}
+		}
+	})
+}
+
+func (r *DaprBasketRepository) AddCoupon(userID, code string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.AddCoupon(code)
+	})
+}
+
+func (r *DaprBasketRepository) RemoveCoupon(userID, code string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.RemoveCoupon(code)
+	})
+}
+
+func (r *DaprBasketRepository) SetDestination(userID string, destination *model.Address) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.Destination = destination
+	})
+}
+
+func (r *DaprBasketRepository) UpdateTotalAmount(userID string, totalAmount money.Money) error {
+	return r.update(func(tx *daprTx) error {
+		stored, err := r.loadUnlocked(tx, userID)
+		if err != nil {
+			return err
+		}
+		stored.Basket.TotalAmount = totalAmount
+		touch(stored.Basket)
+		return r.saveBasket(tx, stored)
+	})
+}
+
+// Merge moves the items of one basket into another and deletes the source
+// basket, all in one transaction. Lines present in both baskets are combined
+// according to policy.
+func (r *DaprBasketRepository) Merge(fromUserID, toUserID, policy string, check func(before []model.BasketItem, merged *model.Basket) error) (*model.Basket, error) {
+	switch policy {
+	case model.MergeSum, model.MergeMax, model.MergeNewest:
+	default:
+		return nil, fmt.Errorf("%w: %s", model.ErrInvalidMergePolicy, policy)
+	}
+
+	var merged *model.Basket
+	err := r.update(func(tx *daprTx) error {
+		if err := tx.load(basketKey(fromUserID), basketKey(toUserID)); err != nil {
+			return err
+		}
+		from, err := r.loadUnlocked(tx, fromUserID)
+		if err != nil {
+			return err
+		}
+		to, err := r.loadUnlocked(tx, toUserID)
+		if err != nil {
+			return err
+		}
+
+		before := to.Basket.Items
+		to.Basket.Items = mergeItems(from.Basket, to.Basket, policy)
+		if to.Basket.Destination == nil {
+			to.Basket.Destination = from.Basket.Destination
+		}
+		for _, code := range from.Basket.CouponCodes {
+			if !containsString(to.Basket.CouponCodes, code) {
+				to.Basket.CouponCodes = append(to.Basket.CouponCodes, code)
+			}
+		}
+		if err := updateTotal(to.Basket); err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(before, to.Basket); err != nil {
+				return err
+			}
+		}
+		touch(to.Basket)
+
+		if err := r.saveBasket(tx, to); err != nil {
+			return err
+		}
+		if err := r.record(tx, from.Basket, &model.Basket{UserID: fromUserID, Version: model.NewBasketVersion}, time.Time{}); err != nil {
+			return err
+		}
+		if err := tx.delete(basketKey(fromUserID)); err != nil {
+			return err
+		}
+		tx.onCommit(func() { r.indexActivity(fromUserID) })
+		merged = to.Basket
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// GetIdleBaskets returns up to limit non-empty baskets that have not been
+// updated since idleSince, least recently updated first.
+func (r *DaprBasketRepository) GetIdleBaskets(idleSince time.Time, limit int64) ([]string, error) {
+	keys := make([]string, activityShards)
+	for i := range keys {
+		keys[i] = activityShardKey(i)
+	}
+
+	tx := r.state.newTx(context.Background())
+	if err := tx.load(keys...); err != nil {
+		return nil, fmt.Errorf("error getting idle baskets: %w", err)
+	}
+
+	idle := make(map[string]time.Time)
+	for _, key := range keys {
+		var shard map[string]int64
+		if _, err := tx.get(key, &shard); err != nil {
+			return nil, fmt.Errorf("error getting idle baskets: %w", err)
+		}
+		for userID, ms := range shard {
+			if lastActivity := time.UnixMilli(ms); !lastActivity.After(idleSince) {
+				idle[userID] = lastActivity
+			}
+		}
+	}
+
+	userIDs := sortedByTime(idle)
+	if int64(len(userIDs)) > limit {
+		userIDs = userIDs[:limit]
+	}
+	return userIDs, nil
+}
+
+// MarkAbandoned flags a basket as abandoned if it is still idle since
+// idleSince. It reports false if the basket was updated or already claimed
+// by another scanner.
+func (r *DaprBasketRepository) MarkAbandoned(userID string, idleSince time.Time) (bool, error) {
+	moved := false
+	err := r.update(func(tx *daprTx) error {
+		moved = false
+		if err := tx.load(basketKey(userID), activityKeyFor(userID), abandonedKey); err != nil {
+			return err
+		}
+		activity, err := r.getActivity(tx, userID)
+		if err != nil {
+			return err
+		}
+		ms, ok := activity[userID]
+		if !ok || time.UnixMilli(ms).After(idleSince) {
+			return nil
+		}
+
+		// The index may lag behind the basket; correct it if the basket
+		// is no longer idle
+		stored, err := r.loadBasket(tx, userID)
+		if err != nil {
+			return err
+		}
+		last := lastActivity(stored.Basket)
+		if last == nil || last.After(idleSince) {
+			return r.setActivity(tx, userID, last)
+		}
+		ms = last.UnixMilli()
+
+		abandoned, err := r.getAbandoned(tx)
+		if err != nil {
+			return err
+		}
+		abandoned[userID] = ms
+		if err := tx.put(abandonedKey, abandoned, 0); err != nil {
+			return err
+		}
+		if err := r.setActivity(tx, userID, nil); err != nil {
+			return err
+		}
+		moved = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error marking basket abandoned: %w", err)
+	}
+	return moved, nil
+}
+
+// UnmarkAbandoned reverts MarkAbandoned, unless the basket has been updated
+// in the meantime.
+func (r *DaprBasketRepository) UnmarkAbandoned(userID string) error {
+	err := r.update(func(tx *daprTx) error {
+		if err := tx.load(activityKeyFor(userID), abandonedKey); err != nil {
+			return err
+		}
+		abandoned, err := r.getAbandoned(tx)
+		if err != nil {
+			return err
+		}
+		ms, ok := abandoned[userID]
+		if !ok {
+			return nil
+		}
+		delete(abandoned, userID)
+		if err := tx.put(abandonedKey, abandoned, 0); err != nil {
+			return err
+		}
+
+		activity, err := r.getActivity(tx, userID)
+		if err != nil {
+			return err
+		}
+		if _, ok := activity[userID]; ok {
+			return nil
+		}
+		idleFrom := time.UnixMilli(ms)
+		return r.setActivity(tx, userID, &idleFrom)
+	})
+	if err != nil {
+		return fmt.Errorf("error unmarking abandoned basket: %w", err)
+	}
+	return nil
+}
+
+// GetAbandonedBaskets returns up to limit abandoned baskets with the time of
+// their last activity before they were abandoned.
+func (r *DaprBasketRepository) GetAbandonedBaskets(limit int64) (map[string]time.Time, error) {
+	tx := r.state.newTx(context.Background())
+	entries, err := r.getAbandoned(tx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting abandoned baskets: %w", err)
+	}
+
+	all := make(map[string]time.Time, len(entries))
+	for userID, ms := range entries {
+		all[userID] = time.UnixMilli(ms)
+	}
+
+	abandoned := make(map[string]time.Time)
+	for _, userID := range sortedByTime(all) {
+		if int64(len(abandoned)) >= limit {
+			break
+		}
+		abandoned[userID] = all[userID]
+	}
+	return abandoned, nil
+}
+
+// ClearAbandoned stops tracking an abandoned basket. It reports false if the
+// basket was not tracked, so that only one scanner reports its recovery.
+func (r *DaprBasketRepository) ClearAbandoned(userID string) (bool, error) {
+	removed := false
+	err := r.update(func(tx *daprTx) error {
+		removed = false
+		abandoned, err := r.getAbandoned(tx)
+		if err != nil {
+			return err
+		}
+		if _, ok := abandoned[userID]; !ok {
+			return nil
+		}
+		delete(abandoned, userID)
+		removed = true
+		return tx.put(abandonedKey, abandoned, 0)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error clearing abandoned basket: %w", err)
+	}
+	return removed, nil
+}
+
+// SetProductDeleted records whether a product has been removed from the
+// catalog so that basket lines referring to it can be flagged.
+func (r *DaprBasketRepository) SetProductDeleted(productID string, deleted bool) error {
+	err := r.update(func(tx *daprTx) error {
+		products := make(map[string]bool)
+		if _, err := tx.get(deletedProductsKey, &products); err != nil {
+			return err
+		}
+		if products[productID] == deleted {
+			return nil
+		}
+		if deleted {
+			products[productID] = true
+		} else {
+			delete(products, productID)
+		}
+		return tx.put(deletedProductsKey, products, 0)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating deleted products: %w", err)
+	}
+	return nil
+}
+
+func (r *DaprBasketRepository) GetDeletedProducts(productIDs []string) (map[string]bool, error) {
+	deleted := make(map[string]bool)
+	if len(productIDs) == 0 {
+		return deleted, nil
+	}
+
+	products := make(map[string]bool)
+	tx := r.state.newTx(context.Background())
+	if _, err := tx.get(deletedProductsKey, &products); err != nil {
+		return nil, fmt.Errorf("error getting deleted products: %w", err)
+	}
+	for _, id := range productIDs {
+		if products[id] {
+			deleted[id] = true
+		}
+	}
+	return deleted, nil
+}
+
+func (r *DaprBasketRepository) GetLists(userID string) ([]*model.List, error) {
+	tx := r.state.newTx(context.Background())
+	var lists []*model.List
+	if _, err := tx.get(listsKey(userID), &lists); err != nil {
+		return nil, fmt.Errorf("error getting lists: %w", err)
+	}
+	return withDefaultList(lists), nil
+}
+
+func (r *DaprBasketRepository) CreateList(userID, name string) (*model.List, error) {
+	now := time.Now()
+	list := &model.List{
+		ID:        fmt.Sprintf("list_%d", now.UnixNano()),
+		Name:      name,
+		Items:     []model.BasketItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		return append(lists, list), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *DaprBasketRepository) DeleteList(userID, listID string) error {
+	if listID == model.DefaultListID {
+		return model.ErrDefaultList
+	}
+
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		for i, list := range lists {
+			if list.ID == listID {
+				return append(lists[:i], lists[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", model.ErrListNotFound, listID)
+	})
+}
+
+func (r *DaprBasketRepository) AddToList(userID, listID string, item model.BasketItem) error {
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return nil, err
+		}
+		addToList(list, item)
+		return lists, nil
+	})
+}
+
+func (r *DaprBasketRepository) RemoveFromList(userID, listID, productID string) error {
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := takeFromList(list, productID); err != nil {
+			return nil, err
+		}
+		return lists, nil
+	})
+}
+
+func (r *DaprBasketRepository) MoveToList(userID, listID, productID string) error {
+	return r.move(userID, func(basket *model.Basket, lists []*model.List) error {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return err
+		}
+
+		for i, item := range basket.Items {
+			if item.ProductID == productID {
+				basket.Items = append(basket.Items[:i], basket.Items[i+1:]...)
+				addToList(list, item)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", model.ErrItemNotFound, productID)
+	})
+}
+
+func (r *DaprBasketRepository) MoveToBasket(userID, listID string, item model.BasketItem, check func(basket *model.Basket, moved model.BasketItem) error) error {
+	return r.move(userID, func(basket *model.Basket, lists []*model.List) error {
+		return moveToBasket(basket, lists, listID, item, check)
+	})
+}
+
+// LockBasket locks a basket against changes for the duration of a checkout.
+// The pending order is kept until the checkout completes or is unlocked, so
+// a checkout retried after its lock expired charges the same order.
+func (r *DaprBasketRepository) LockBasket(userID, orderID string, ttl time.Duration) (*model.CheckoutLock, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating lock token: %w", err)
+	}
+	lock := &model.CheckoutLock{Token: hex.EncodeToString(b)}
+
+	err := r.update(func(tx *daprTx) error {
+		stored, err := r.loadUnlocked(tx, userID)
+		if err != nil {
+			return err
+		}
+		if stored.PendingOrder == "" {
+			stored.PendingOrder = orderID
+		}
+		lock.OrderID = stored.PendingOrder
+		stored.Lock = &daprLock{Token: lock.Token, ExpiresAt: time.Now().Add(ttl)}
+		return tx.put(basketKey(userID), stored, r.remainingTTL(stored.Basket))
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrBasketLocked) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error locking basket: %w", err)
+	}
+	return lock, nil
+}
+
+func (r *DaprBasketRepository) UnlockBasket(userID, token string) error {
+	err := r.update(func(tx *daprTx) error {
+		stored, err := r.loadBasket(tx, userID)
+		if err != nil {
+			return err
+		}
+		if stored.Lock == nil || stored.Lock.Token != token {
+			return nil
+		}
+		if stored.PendingOrder != "" {
+			if err := tx.delete(pendingChargeKey(stored.PendingOrder)); err != nil {
+				return err
+			}
+		}
+		stored.Lock = nil
+		stored.PendingOrder = ""
+		return tx.put(basketKey(userID), stored, r.remainingTTL(stored.Basket))
+	})
+	if err != nil {
+		return fmt.Errorf("error unlocking basket: %w", err)
+	}
+	return nil
+}
+
+// SetPendingOrder stores the record of the order for as long as the order
+// can stay pending, and makes it the pending order of the basket.
+func (r *DaprBasketRepository) SetPendingOrder(order *model.PendingOrder) error {
+	err := r.update(func(tx *daprTx) error {
+		if err := tx.load(basketKey(order.UserID), pendingChargeKey(order.OrderID)); err != nil {
+			return err
+		}
+		stored, err := r.loadBasket(tx, order.UserID)
+		if err != nil {
+			return err
+		}
+		stored.PendingOrder = order.OrderID
+		if err := tx.put(basketKey(order.UserID), stored, r.remainingTTL(stored.Basket)); err != nil {
+			return err
+		}
+		return tx.put(pendingChargeKey(order.OrderID), order, model.PendingOrderTTL)
+	})
+	if err != nil {
+		return fmt.Errorf("error setting pending order: %w", err)
+	}
+	return nil
+}
+
+func (r *DaprBasketRepository) GetPendingOrder(orderID string) (*model.PendingOrder, error) {
+	var order model.PendingOrder
+	found, err := r.state.newTx(context.Background()).get(pendingChargeKey(orderID), &order)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending order: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &order, nil
+}
+
+// CompleteCheckout takes the lines of a paid order out of the basket, records
+// the order and releases the checkout lock in one transaction. The lock is
+// released if it is held with token or for the order, as when the payment
+// event completes a checkout.
+func (r *DaprBasketRepository) CompleteCheckout(userID, token string, order *model.PendingOrder) error {
+	orderID := order.OrderID
+	err := r.update(func(tx *daprTx) error {
+		if err := tx.load(basketKey(userID), checkoutOrderKey(orderID), pendingChargeKey(orderID)); err != nil {
+			return err
+		}
+		stored, err := r.loadBasket(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		stored.Basket.RemoveOrdered(order)
+		if err := updateTotal(stored.Basket); err != nil {
+			return err
+		}
+		touch(stored.Basket)
+		forOrder := stored.PendingOrder == orderID
+		if stored.Lock != nil && (stored.Lock.Token == token || forOrder) {
+			stored.Lock = nil
+		}
+		if forOrder {
+			stored.PendingOrder = ""
+		}
+
+		if err := r.saveBasket(tx, stored); err != nil {
+			return err
+		}
+		if err := tx.delete(pendingChargeKey(orderID)); err != nil {
+			return err
+		}
+		return tx.put(checkoutOrderKey(orderID), userID, checkoutOrderTTL)
+	})
+	if err != nil {
+		return fmt.Errorf("error completing checkout: %w", err)
+	}
+	return nil
+}
+
+// IsCheckoutOrder reports whether an order was placed and completed through
+// CompleteCheckout.
+func (r *DaprBasketRepository) IsCheckoutOrder(orderID string) (bool, error) {
+	var userID string
+	found, err := r.state.newTx(context.Background()).get(checkoutOrderKey(orderID), &userID)
+	if err != nil {
+		return false, fmt.Errorf("error checking checkout order: %w", err)
+	}
+	return found, nil
+}
+
+// PendingOrder returns the order of a checkout that was neither completed nor
+// unlocked.
+func (r *DaprBasketRepository) PendingOrder(userID string) (string, error) {
+	stored, err := r.loadBasket(r.state.newTx(context.Background()), userID)
+	if err != nil {
+		return "", err
+	}
+	return stored.PendingOrder, nil
+}
+
+// Update applies fn to the stored basket and recalculates its total. The
+// read-modify-write is conditional on the basket's ETag, so concurrent
+// updates of the same basket are never lost.
+func (r *DaprBasketRepository) Update(userID string, expectedVersion int64, fn func(basket *model.Basket) error) (*model.Basket, error) {
+	var updated *model.Basket
+	err := r.update(func(tx *daprTx) error {
+		if err := tx.load(basketKey(userID)); err != nil {
+			return err
+		}
+		stored, err := r.loadUnlocked(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(stored.Basket, expectedVersion); err != nil {
+			return err
+		}
+		if err := fn(stored.Basket); err != nil {
+			return err
+		}
+
+		if err := updateTotal(stored.Basket); err != nil {
+			return err
+		}
+		touch(stored.Basket)
+		updated = stored.Basket
+		return r.saveBasket(tx, stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// mutate applies fn to the stored basket whatever its version.
+func (r *DaprBasketRepository) mutate(userID string, fn func(basket *model.Basket)) error {
+	_, err := r.Update(userID, 0, func(basket *model.Basket) error {
+		fn(basket)
+		return nil
+	})
+	return err
+}
+
+// mutateLists applies fn to the user's lists in an optimistic transaction.
+func (r *DaprBasketRepository) mutateLists(userID string, fn func(lists []*model.List) ([]*model.List, error)) error {
+	return r.update(func(tx *daprTx) error {
+		var lists []*model.List
+		if _, err := tx.get(listsKey(userID), &lists); err != nil {
+			return err
+		}
+		lists, err := fn(withDefaultList(lists))
+		if err != nil {
+			return err
+		}
+		return tx.put(listsKey(userID), lists, 0)
+	})
+}
+
+// move applies fn to the basket and the lists of a user in one transaction,
+// so items are never lost or duplicated between them.
+func (r *DaprBasketRepository) move(userID string, fn func(basket *model.Basket, lists []*model.List) error) error {
+	return r.update(func(tx *daprTx) error {
+		if err := tx.load(basketKey(userID), listsKey(userID)); err != nil {
+			return err
+		}
+		stored, err := r.loadUnlocked(tx, userID)
+		if err != nil {
+			return err
+		}
+		var lists []*model.List
+		if _, err := tx.get(listsKey(userID), &lists); err != nil {
+			return err
+		}
+		lists = withDefaultList(lists)
+
+		if err := fn(stored.Basket, lists); err != nil {
+			return err
+		}
+		if err := updateTotal(stored.Basket); err != nil {
+			return err
+		}
+		touch(stored.Basket)
+
+		if err := r.saveBasket(tx, stored); err != nil {
+			return err
+		}
+		return tx.put(listsKey(userID), lists, 0)
+	})
+}
+
+// update runs fn in a new state transaction and commits it, retrying with
+// fresh state when a concurrent writer changed any of the keys.
+func (r *DaprBasketRepository) update(fn func(tx *daprTx) error) error {
+	for attempt := 0; attempt < maxTxRetries; attempt++ {
+		tx := r.state.newTx(context.Background())
+		err := fn(tx)
+		if err == nil {
+			err = tx.commit()
+		}
+		if err == nil {
+			for _, fn := range tx.committed {
+				fn()
+			}
+			return nil
+		}
+		if !errors.Is(err, errETagMismatch) {
+			return err
+		}
+
+		// Back off with jitter so competing writers do not collide again
+		time.Sleep(time.Duration(attempt+1) * time.Duration(1+mathrand.Intn(5)) * time.Millisecond)
+	}
+	return model.ErrBasketConflict
+}
+
+// loadBasket returns the stored basket, or a new empty basket if there is
+// none. Expired locks are dropped.
+func (r *DaprBasketRepository) loadBasket(tx *daprTx, userID string) (*daprBasket, error) {
+	var stored daprBasket
+	found, err := tx.get(basketKey(userID), &stored)
+	if err != nil {
+		return nil, fmt.Errorf("error getting basket: %w", err)
+	}
+	if !found || stored.Basket == nil {
+		now := time.Now()
+		stored.Basket = &model.Basket{
+			UserID:    userID,
+			Items:     []model.BasketItem{},
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   model.NewBasketVersion,
+		}
+	}
+	if stored.Lock != nil && !time.Now().Before(stored.Lock.ExpiresAt) {
+		stored.Lock = nil
+	}
+	return &stored, nil
+}
+
+// loadUnlocked is loadBasket, failing with ErrBasketLocked while a checkout
+// holds the basket.
+func (r *DaprBasketRepository) loadUnlocked(tx *daprTx, userID string) (*daprBasket, error) {
+	stored, err := r.loadBasket(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Lock != nil {
+		return nil, model.ErrBasketLocked
+	}
+	return stored, nil
+}
+
+// saveBasket stores the basket with a fresh TTL and records the change in
+// its history. The activity index is brought up to date once the
+// transaction has been committed.
+func (r *DaprBasketRepository) saveBasket(tx *daprTx, stored *daprBasket) error {
+	before, err := r.loadBasket(tx, stored.Basket.UserID)
+	if err != nil {
+		return err
+	}
+	if err := r.record(tx, before.Basket, stored.Basket, time.Now().Add(r.ttl)); err != nil {
+		return err
+	}
+	if err := tx.put(basketKey(stored.Basket.UserID), stored, r.ttl); err != nil {
+		return err
+	}
+	userID := stored.Basket.UserID
+	tx.onCommit(func() { r.indexActivity(userID) })
+	return nil
+}
+
+// remainingTTL returns the time until a basket expires, for writes that keep
+// its expiry, such as checkout locks.
+func (r *DaprBasketRepository) remainingTTL(basket *model.Basket) time.Duration {
+	return max(time.Until(basket.UpdatedAt.Add(r.ttl)), time.Second)
+}
+
+// record appends the events of a change to the basket's history in the
+// transaction of the change.
+func (r *DaprBasketRepository) record(tx *daprTx, before, after *model.Basket, expiresAt time.Time) error {
+	events := model.ChangeEvents(before, after, expiresAt)
+	if len(events) == 0 {
+		return nil
+	}
+	var history eventLog
+	if _, err := tx.get(historyKey(after.UserID), &history); err != nil {
+		return err
+	}
+	history.append(after.UserID, events, daprHistoryEvents)
+	return tx.put(historyKey(after.UserID), &history, r.retention)
+}
+
+// Get returns the history of a user's basket.
+func (r *DaprBasketRepository) Get(userID string, until time.Time) ([]model.BasketEvent, error) {
+	var history eventLog
+	if _, err := r.state.newTx(context.Background()).get(historyKey(userID), &history); err != nil {
+		return nil, fmt.Errorf("error getting basket history: %w", err)
+	}
+	return history.get(until)
+}
+
+// indexActivity updates the activity index entry of a basket from the stored
+// basket, in its own transaction. Empty and deleted baskets are not tracked.
+// Reading the basket rather than passing its state along makes updates that
+// run out of order converge. Failures only leave the index stale, which
+// MarkAbandoned checks against the basket.
+func (r *DaprBasketRepository) indexActivity(userID string) {
+	err := r.update(func(tx *daprTx) error {
+		if err := tx.load(basketKey(userID), activityKeyFor(userID)); err != nil {
+			return err
+		}
+		stored, err := r.loadBasket(tx, userID)
+		if err != nil {
+			return err
+		}
+		return r.setActivity(tx, userID, lastActivity(stored.Basket))
+	})
+	if err != nil {
+		log.Printf("Failed to index activity of basket %s: %v", userID, err)
+	}
+}
+
+// lastActivity returns when a basket was last updated, or nil if it is empty.
+func lastActivity(basket *model.Basket) *time.Time {
+	if len(basket.Items) == 0 {
+		return nil
+	}
+	updatedAt := basket.UpdatedAt
+	return &updatedAt
+}
+
+func (r *DaprBasketRepository) getActivity(tx *daprTx, userID string) (map[string]int64, error) {
+	activity := make(map[string]int64)
+	if _, err := tx.get(activityKeyFor(userID), &activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+// setActivity records the last activity of a basket in the activity index,
+// or removes the basket from it if lastActivity is nil.
+func (r *DaprBasketRepository) setActivity(tx *daprTx, userID string, lastActivity *time.Time) error {
+	activity, err := r.getActivity(tx, userID)
+	if err != nil {
+		return err
+	}
+	if lastActivity == nil {
+		if _, ok := activity[userID]; !ok {
+			return nil
+		}
+		delete(activity, userID)
+	} else {
+		activity[userID] = lastActivity.UnixMilli()
+	}
+	return tx.put(activityKeyFor(userID), activity, 0)
+}
+
+func (r *DaprBasketRepository) getAbandoned(tx *daprTx) (map[string]int64, error) {
+	abandoned := make(map[string]int64)
+	if _, err := tx.get(abandonedKey, &abandoned); err != nil {
+		return nil, err
+	}
+	return abandoned, nil
+}
+
+func activityKeyFor(userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return activityShardKey(int(h.Sum32() % activityShards))
+}
+
+func activityShardKey(shard int) string {
+	return fmt.Sprintf("%s:%d", activityKey, shard)
+}
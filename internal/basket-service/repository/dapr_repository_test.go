@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"daprps/internal/basket-service/repository/repositorytest"
+)
+
+func TestDaprRepositoryConformance(t *testing.T) {
+	sidecar := repositorytest.NewDaprSidecar()
+	defer sidecar.Close()
+
+	repo := NewDaprBasketRepository(sidecar.URL, "statestore", time.Hour, 24*time.Hour)
+	if err := repositorytest.TestBasketRepository(repo); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errETagMismatch is returned when a state transaction lost to a concurrent
+// writer. Callers re-read the state and retry.
+var errETagMismatch = errors.New("etag mismatch")
+
+// daprItem is a state entry as returned by the Dapr state API.
+type daprItem struct {
+	Key  string          `json:"key"`
+	Data json.RawMessage `json:"data,omitempty"`
+	ETag string          `json:"etag,omitempty"`
+}
+
+type daprOperation struct {
+	Operation string      `json:"operation"`
+	Request   daprRequest `json:"request"`
+}
+
+type daprRequest struct {
+	Key      string            `json:"key"`
+	Value    json.RawMessage   `json:"value,omitempty"`
+	ETag     string            `json:"etag,omitempty"`
+	Options  *daprOptions      `json:"options,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type daprOptions struct {
+	Concurrency string `json:"concurrency"`
+}
+
+// daprState is a client for the state API of a Dapr sidecar.
+type daprState struct {
+	client   *http.Client
+	stateURL string
+}
+
+func newDaprState(sidecarURL, storeName string, timeout time.Duration) *daprState {
+	return &daprState{
+		client:   &http.Client{Timeout: timeout},
+		stateURL: strings.TrimSuffix(sidecarURL, "/") + "/v1.0/state/" + url.PathEscape(storeName),
+	}
+}
+
+// bulkGet reads keys with their ETags. Missing keys are returned without data.
+func (s *daprState) bulkGet(ctx context.Context, keys []string) (map[string]*daprItem, error) {
+	body, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling state request: %w", err)
+	}
+
+	resp, err := s.post(ctx, s.stateURL+"/bulk", body)
+	if err != nil {
+		return nil, fmt.Errorf("error getting state: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting state: %w", stateError(resp))
+	}
+
+	var items []*daprItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("error decoding state: %w", err)
+	}
+
+	result := make(map[string]*daprItem, len(keys))
+	for _, item := range items {
+		result[item.Key] = item
+	}
+	for _, key := range keys {
+		if result[key] == nil {
+			result[key] = &daprItem{Key: key}
+		}
+	}
+	return result, nil
+}
+
+// transact applies operations atomically. It fails with errETagMismatch if
+// any of the ETags no longer matches.
+func (s *daprState) transact(ctx context.Context, operations []daprOperation) error {
+	body, err := json.Marshal(map[string]interface{}{"operations": operations})
+	if err != nil {
+		return fmt.Errorf("error marshaling state transaction: %w", err)
+	}
+
+	resp, err := s.post(ctx, s.stateURL+"/transaction", body)
+	if err != nil {
+		return fmt.Errorf("error saving state: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = stateError(resp)
+	if resp.StatusCode == http.StatusConflict || strings.Contains(strings.ToLower(err.Error()), "etag") {
+		return fmt.Errorf("%w: %v", errETagMismatch, err)
+	}
+	return fmt.Errorf("error saving state: %w", err)
+}
+
+func (s *daprState) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.client.Do(req)
+}
+
+func stateError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("dapr returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// daprTx collects the reads and writes of one optimistic state transaction.
+// Writes are conditional on the ETags seen by the reads; keys that did not
+// exist may only be created, not overwritten.
+type daprTx struct {
+	state *daprState
+	ctx   context.Context
+	items map[string]*daprItem
+	ops   map[string]daprOperation
+	order []string
+	// committed runs once the transaction has been committed
+	committed []func()
+}
+
+func (s *daprState) newTx(ctx context.Context) *daprTx {
+	return &daprTx{
+		state: s,
+		ctx:   ctx,
+		items: make(map[string]*daprItem),
+		ops:   make(map[string]daprOperation),
+	}
+}
+
+// load reads the keys that have not been read in this transaction yet.
+func (tx *daprTx) load(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if _, ok := tx.items[key]; !ok && !containsString(missing, key) {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	items, err := tx.state.bulkGet(tx.ctx, missing)
+	if err != nil {
+		return err
+	}
+	for key, item := range items {
+		tx.items[key] = item
+	}
+	return nil
+}
+
+// get decodes the value of key into v and reports whether it exists. Values
+// written earlier in the transaction are returned.
+func (tx *daprTx) get(key string, v interface{}) (bool, error) {
+	if err := tx.load(key); err != nil {
+		return false, err
+	}
+
+	data := tx.items[key].Data
+	if op, ok := tx.ops[key]; ok {
+		data = op.Request.Value
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("error unmarshaling %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// put stores v under key when the transaction commits. A ttl of zero keeps
+// the value forever.
+func (tx *daprTx) put(key string, v interface{}, ttl time.Duration) error {
+	if err := tx.load(key); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling %s: %w", key, err)
+	}
+
+	req := daprRequest{
+		Key:     key,
+		Value:   data,
+		ETag:    tx.items[key].ETag,
+		Options: &daprOptions{Concurrency: "first-write"},
+	}
+	if ttl > 0 {
+		seconds := int64((ttl + time.Second - 1) / time.Second)
+		req.Metadata = map[string]string{"ttlInSeconds": strconv.FormatInt(seconds, 10)}
+	}
+	tx.queue(daprOperation{Operation: "upsert", Request: req})
+	return nil
+}
+
+// delete removes key when the transaction commits.
+func (tx *daprTx) delete(key string) error {
+	if err := tx.load(key); err != nil {
+		return err
+	}
+	item := tx.items[key]
+	if len(item.Data) == 0 {
+		delete(tx.ops, key)
+		return nil
+	}
+	tx.queue(daprOperation{Operation: "delete", Request: daprRequest{
+		Key:     key,
+		ETag:    item.ETag,
+		Options: &daprOptions{Concurrency: "first-write"},
+	}})
+	return nil
+}
+
+func (tx *daprTx) queue(op daprOperation) {
+	if _, ok := tx.ops[op.Request.Key]; !ok {
+		tx.order = append(tx.order, op.Request.Key)
+	}
+	tx.ops[op.Request.Key] = op
+}
+
+// onCommit registers fn to run after the transaction has been committed.
+func (tx *daprTx) onCommit(fn func()) {
+	tx.committed = append(tx.committed, fn)
+}
+
+func (tx *daprTx) commit() error {
+	var operations []daprOperation
+	for _, key := range tx.order {
+		if op, ok := tx.ops[key]; ok {
+			operations = append(operations, op)
+		}
+	}
+	if len(operations) == 0 {
+		return nil
+	}
+	return tx.state.transact(tx.ctx, operations)
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"daprps/internal/basket-service/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// maxHistoryEvents caps the length of a basket's history. Beyond it, the
+// oldest half of the events is folded into a snapshot that replaces them.
+const maxHistoryEvents = 10000
+
+// historyWrite is a change of a basket's history, prepared within the
+// transaction of the basket change and queued with it.
+type historyWrite struct {
+	userID  string
+	entries [][]byte
+	// base is the new snapshot of the events trimmed, keep the number of
+	// entries left; both are only set when the stream is trimmed.
+	base []byte
+	keep int64
+}
+
+// prepareHistory prepares the events of a basket change within its
+// transaction. The basket before the change is read back from the
+// transaction, which watches its key; every history write also writes the
+// basket, so the watch covers the history too.
+func (r *BasketRepositoryImpl) prepareHistory(ctx context.Context, tx *redis.Tx, after *model.Basket) (*historyWrite, error) {
+	before, err := loadBasket(ctx, tx, after.UserID)
+	if err != nil {
+		return nil, err
+	}
+	w := &historyWrite{userID: after.UserID}
+	for _, event := range model.ChangeEvents(before, after, after.UpdatedAt.Add(r.ttl)) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling basket event: %w", err)
+		}
+		w.entries = append(w.entries, data)
+	}
+	if len(w.entries) == 0 {
+		return w, nil
+	}
+
+	key := historyKey(after.UserID)
+	length, err := tx.XLen(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting basket history length: %w", err)
+	}
+	if length+int64(len(w.entries)) <= maxHistoryEvents {
+		return w, nil
+	}
+
+	// Fold the oldest entries into the base snapshot before they are trimmed
+	drop := min(length+int64(len(w.entries))-maxHistoryEvents/2, length)
+	entries, err := tx.XRangeN(ctx, key, "-", "+", drop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting basket history: %w", err)
+	}
+	base, err := loadHistoryBase(ctx, tx, after.UserID)
+	if err != nil {
+		return nil, err
+	}
+	folded, err := decodeHistory(entries)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		folded = append([]model.BasketEvent{*base}, folded...)
+	}
+	snapshot := model.SnapshotEvent(after.UserID, folded)
+	snapshot.ID = folded[len(folded)-1].ID
+	if w.base, err = json.Marshal(snapshot); err != nil {
+		return nil, fmt.Errorf("error marshaling basket snapshot: %w", err)
+	}
+	w.keep = length - int64(len(entries))
+	return w, nil
+}
+
+// queueHistory queues the commands of a prepared history write. The history
+// expires retention after the last change, which outlasts the basket.
+func (r *BasketRepositoryImpl) queueHistory(ctx context.Context, pipe redis.Pipeliner, w *historyWrite) {
+	if len(w.entries) == 0 {
+		return
+	}
+	key := historyKey(w.userID)
+	baseKey := historyBaseKey(w.userID)
+	if w.base != nil {
+		pipe.Set(ctx, baseKey, w.base, 0)
+		pipe.XTrimMaxLen(ctx, key, w.keep)
+	}
+	for _, data := range w.entries {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			Values: map[string]interface{}{"event": data},
+		})
+	}
+	pipe.Expire(ctx, key, r.retention)
+	pipe.Expire(ctx, baseKey, r.retention)
+}
+
+// Get reads the base snapshot, if any, and the stream up to until. Stream IDs
+// start with the time an entry was appended, which bounds the range; events
+// are then filtered by the time of the change.
+func (r *BasketRepositoryImpl) Get(userID string, until time.Time) ([]model.BasketEvent, error) {
+	ctx := context.Background()
+	end := "+"
+	if !until.IsZero() {
+		end = strconv.FormatInt(until.UnixMilli()+1, 10)
+	}
+
+	// Read the snapshot and the stream in one MULTI, so that a trim in
+	// between cannot lose events
+	var baseCmd *redis.StringCmd
+	var rangeCmd *redis.XMessageSliceCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		baseCmd = pipe.Get(ctx, historyBaseKey(userID))
+		rangeCmd = pipe.XRange(ctx, historyKey(userID), "-", end)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error getting basket history: %w", err)
+	}
+	var base *model.BasketEvent
+	if data, err := baseCmd.Result(); err == nil {
+		base = &model.BasketEvent{}
+		if err := json.Unmarshal([]byte(data), base); err != nil {
+			return nil, fmt.Errorf("error unmarshaling basket history snapshot: %w", err)
+		}
+	}
+	entries, err := rangeCmd.Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting basket history: %w", err)
+	}
+
+	decoded, err := decodeHistory(entries)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]model.BasketEvent, 0, len(decoded)+1)
+	if base != nil {
+		if !until.IsZero() && until.Before(base.At) {
+			return nil, model.ErrHistoryTrimmed
+		}
+		events = append(events, *base)
+	}
+	for _, event := range decoded {
+		if !until.IsZero() && event.At.After(until) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func loadHistoryBase(ctx context.Context, c redis.Cmdable, userID string) (*model.BasketEvent, error) {
+	data, err := c.Get(ctx, historyBaseKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting basket history snapshot: %w", err)
+	}
+	var base model.BasketEvent
+	if err := json.Unmarshal([]byte(data), &base); err != nil {
+		return nil, fmt.Errorf("error unmarshaling basket history snapshot: %w", err)
+	}
+	return &base, nil
+}
+
+func decodeHistory(entries []redis.XMessage) ([]model.BasketEvent, error) {
+	events := make([]model.BasketEvent, 0, len(entries))
+	for _, entry := range entries {
+		data, ok := entry.Values["event"].(string)
+		if !ok {
+			continue
+		}
+		var event model.BasketEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, fmt.Errorf("error unmarshaling basket event: %w", err)
+		}
+		event.ID = entry.ID
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func historyKey(userID string) string {
+	return fmt.Sprintf("basket:%s:history", userID)
+}
+
+func historyBaseKey(userID string) string {
+	return fmt.Sprintf("basket:%s:history:base", userID)
+}
+
+// eventLog is a basket history kept as one value, by the memory and Dapr
+// repositories. Base stands in for the events trimmed from the front.
+type eventLog struct {
+	Base   *model.BasketEvent  `json:"base,omitempty"`
+	Events []model.BasketEvent `json:"events"`
+	NextID int64               `json:"next_id"`
+}
+
+// append numbers and adds events, folding the oldest half of them into Base
+// once there are more than max.
+func (l *eventLog) append(userID string, events []model.BasketEvent, max int) {
+	for _, event := range events {
+		l.NextID++
+		event.ID = strconv.FormatInt(l.NextID, 10)
+		l.Events = append(l.Events, event)
+	}
+	if len(l.Events) <= max {
+		return
+	}
+
+	drop := len(l.Events) - max/2
+	folded := append([]model.BasketEvent(nil), l.Events[:drop]...)
+	if l.Base != nil {
+		folded = append([]model.BasketEvent{*l.Base}, folded...)
+	}
+	base := model.SnapshotEvent(userID, folded)
+	base.ID = folded[len(folded)-1].ID
+	l.Base = &base
+	l.Events = append([]model.BasketEvent(nil), l.Events[drop:]...)
+}
+
+// get returns the events up to until, starting with Base.
+func (l *eventLog) get(until time.Time) ([]model.BasketEvent, error) {
+	events := make([]model.BasketEvent, 0, len(l.Events)+1)
+	if l.Base != nil {
+		if !until.IsZero() && until.Before(l.Base.At) {
+			return nil, model.ErrHistoryTrimmed
+		}
+		events = append(events, *l.Base)
+	}
+	for _, event := range l.Events {
+		if !until.IsZero() && event.At.After(until) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"daprps/internal/basket-service/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Saved lists are stored per user as one JSON document that does not expire.
+
+func (r *BasketRepositoryImpl) GetLists(userID string) ([]*model.List, error) {
+	return loadLists(context.Background(), r.client, userID)
+}
+
+func (r *BasketRepositoryImpl) CreateList(userID, name string) (*model.List, error) {
+	now := time.Now()
+	list := &model.List{
+		ID:        fmt.Sprintf("list_%d", now.UnixNano()),
+		Name:      name,
+		Items:     []model.BasketItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		return append(lists, list), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *BasketRepositoryImpl) DeleteList(userID, listID string) error {
+	if listID == model.DefaultListID {
+		return model.ErrDefaultList
+	}
+
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		for i, list := range lists {
+			if list.ID == listID {
+				return append(lists[:i], lists[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", model.ErrListNotFound, listID)
+	})
+}
+
+func (r *BasketRepositoryImpl) AddToList(userID, listID string, item model.BasketItem) error {
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return nil, err
+		}
+		addToList(list, item)
+		return lists, nil
+	})
+}
+
+func (r *BasketRepositoryImpl) RemoveFromList(userID, listID, productID string) error {
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := takeFromList(list, productID); err != nil {
+			return nil, err
+		}
+		return lists, nil
+	})
+}
+
+func (r *BasketRepositoryImpl) MoveToList(userID, listID, productID string) error {
+	return r.move(userID, func(basket *model.Basket, lists []*model.List) error {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return err
+		}
+
+		for i, item := range basket.Items {
+			if item.ProductID == productID {
+				basket.Items = append(basket.Items[:i], basket.Items[i+1:]...)
+				addToList(list, item)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", model.ErrItemNotFound, productID)
+	})
+}
+
+func (r *BasketRepositoryImpl) MoveToBasket(userID, listID string, item model.BasketItem, check func(basket *model.Basket, moved model.BasketItem) error) error {
+	return r.move(userID, func(basket *model.Basket, lists []*model.List) error {
+		return moveToBasket(basket, lists, listID, item, check)
+	})
+}
+
+// mutateLists applies fn to the user's lists in an optimistic transaction.
+func (r *BasketRepositoryImpl) mutateLists(userID string, fn func(lists []*model.List) ([]*model.List, error)) error {
+	ctx := context.Background()
+	key := listsKey(userID)
+
+	return r.withRetry(ctx, []string{key}, func(tx *redis.Tx) error {
+		lists, err := loadLists(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		lists, err = fn(lists)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(lists)
+		if err != nil {
+			return fmt.Errorf("error marshaling lists: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			return nil
+		})
+		if err != nil && err != redis.TxFailedErr {
+			return fmt.Errorf("error saving lists: %w", err)
+		}
+		return err
+	})
+}
+
+// move applies fn to the basket and the lists of a user in one optimistic
+// transaction, so items are never lost or duplicated between them.
+func (r *BasketRepositoryImpl) move(userID string, fn func(basket *model.Basket, lists []*model.List) error) error {
+	ctx := context.Background()
+	key := listsKey(userID)
+
+	return r.withRetry(ctx, []string{basketKey(userID), key, lockKey(userID)}, func(tx *redis.Tx) error {
+		if err := ensureUnlocked(ctx, tx, userID); err != nil {
+			return err
+		}
+		basket, err := loadBasket(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		lists, err := loadLists(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(basket, lists); err != nil {
+			return err
+		}
+		if err := updateTotal(basket); err != nil {
+			return err
+		}
+		touch(basket)
+
+		basketData, err := json.Marshal(basket)
+		if err != nil {
+			return fmt.Errorf("error marshaling basket: %w", err)
+		}
+		listsData, err := json.Marshal(lists)
+		if err != nil {
+			return fmt.Errorf("error marshaling lists: %w", err)
+		}
+		history, err := r.prepareHistory(ctx, tx, basket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueSave(ctx, pipe, basket, basketData, history)
+			pipe.Set(ctx, key, listsData, 0)
+			return nil
+		})
+		if err != nil && err != redis.TxFailedErr {
+			return fmt.Errorf("error saving basket and lists: %w", err)
+		}
+		return err
+	})
+}
+
+// loadLists returns the user's lists, always starting with the saved for
+// later list.
+func loadLists(ctx context.Context, c redis.Cmdable, userID string) ([]*model.List, error) {
+	var lists []*model.List
+
+	data, err := c.Get(ctx, listsKey(userID)).Bytes()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error getting lists: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &lists); err != nil {
+			return nil, fmt.Errorf("error unmarshaling lists: %w", err)
+		}
+	}
+
+	return withDefaultList(lists), nil
+}
+
+// withDefaultList adds the saved for later list in front of lists if the user
+// has none yet.
+func withDefaultList(lists []*model.List) []*model.List {
+	if _, err := findList(lists, model.DefaultListID); err == nil {
+		return lists
+	}
+	now := time.Now()
+	return append([]*model.List{{
+		ID:        model.DefaultListID,
+		Name:      model.DefaultListName,
+		Items:     []model.BasketItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}, lists...)
+}
+
+func findList(lists []*model.List, listID string) (*model.List, error) {
+	if listID == "" {
+		listID = model.DefaultListID
+	}
+	for _, list := range lists {
+		if list.ID == listID {
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", model.ErrListNotFound, listID)
+}
+
+// addToList adds an item to a list, adding up quantities of the same product.
+func addToList(list *model.List, item model.BasketItem) {
+	list.UpdatedAt = time.Now()
+	if i := list.FindItem(item.ProductID); i >= 0 {
+		list.Items[i].Quantity += item.Quantity
+		return
+	}
+	list.Items = append(list.Items, item)
+}
+
+// moveToBasket takes item's product off a list and adds item to the basket
+// with the quantity saved in the list, then runs check, if any, against the
+// updated basket and the moved item.
+func moveToBasket(basket *model.Basket, lists []*model.List, listID string, item model.BasketItem, check func(basket *model.Basket, moved model.BasketItem) error) error {
+	list, err := findList(lists, listID)
+	if err != nil {
+		return err
+	}
+	saved, err := takeFromList(list, item.ProductID)
+	if err != nil {
+		return err
+	}
+
+	item.Quantity = saved.Quantity
+	basket.AddItem(item)
+	if check != nil {
+		return check(basket, item)
+	}
+	return nil
+}
+
+func takeFromList(list *model.List, productID string) (model.BasketItem, error) {
+	i := list.FindItem(productID)
+	if i < 0 {
+		return model.BasketItem{}, fmt.Errorf("%w: %s", model.ErrItemNotFound, productID)
+	}
+	item := list.Items[i]
+	list.Items = append(list.Items[:i], list.Items[i+1:]...)
+	list.UpdatedAt = time.Now()
+	return item, nil
+}
+
+func listsKey(userID string) string {
+	return fmt.Sprintf("lists:%s", userID)
+}
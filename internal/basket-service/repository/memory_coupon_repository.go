@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"daprps/internal/basket-service/model"
+)
+
+// MemoryCouponRepository keeps coupons in process memory, alongside
+// MemoryBasketRepository.
+type MemoryCouponRepository struct {
+	mu          sync.Mutex
+	coupons     map[string]model.Coupon
+	redemptions map[string]map[string]bool      // order IDs per coupon code
+	holds       map[string]map[string]time.Time // held order IDs and their expiry per coupon code
+}
+
+func NewMemoryCouponRepository() model.CouponRepository {
+	return &MemoryCouponRepository{
+		coupons:     make(map[string]model.Coupon),
+		redemptions: make(map[string]map[string]bool),
+		holds:       make(map[string]map[string]time.Time),
+	}
+}
+
+func (r *MemoryCouponRepository) Save(coupon *model.Coupon) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.coupons[coupon.Code] = copyCoupon(coupon)
+	return nil
+}
+
+func (r *MemoryCouponRepository) Get(code string) (*model.Coupon, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	coupon, ok := r.coupons[code]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", model.ErrCouponNotFound, code)
+	}
+	c := copyCoupon(&coupon)
+	return &c, nil
+}
+
+func (r *MemoryCouponRepository) Redemptions(code string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int64(len(r.redemptions[code])), nil
+}
+
+// Reserve holds a use of the coupon for the order, dropping expired holds
+// first.
+func (r *MemoryCouponRepository) Reserve(code, orderID string, limit int64, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	holds := r.holds[code]
+	for heldBy, expiresAt := range holds {
+		if !now.Before(expiresAt) {
+			delete(holds, heldBy)
+		}
+	}
+	if r.redemptions[code][orderID] {
+		return nil
+	}
+	if _, held := holds[orderID]; !held && limit > 0 && int64(len(r.redemptions[code])+len(holds)) >= limit {
+		return fmt.Errorf("%w: %s", model.ErrCouponUsageExceeded, code)
+	}
+	if holds == nil {
+		holds = make(map[string]time.Time)
+		r.holds[code] = holds
+	}
+	holds[orderID] = now.Add(ttl)
+	return nil
+}
+
+// Redeem records the order against the coupon, so the count is idempotent
+// per order.
+func (r *MemoryCouponRepository) Redeem(code, orderID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orders := r.redemptions[code]
+	if orders == nil {
+		orders = make(map[string]bool)
+		r.redemptions[code] = orders
+	}
+	orders[orderID] = true
+	delete(r.holds[code], orderID)
+	return nil
+}
+
+func (r *MemoryCouponRepository) Release(code, orderID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.holds[code], orderID)
+	return nil
+}
+
+func copyCoupon(coupon *model.Coupon) model.Coupon {
+	c := *coupon
+	c.ProductIDs = append([]string(nil), coupon.ProductIDs...)
+	c.Categories = append([]string(nil), coupon.Categories...)
+	return c
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"time"
+
+	"daprps/internal/basket-service/model"
+)
+
+// Get returns the history of a user's basket. Histories are kept retention
+// after the last change and capped like the Redis ones.
+func (r *MemoryBasketRepository) Get(userID string, until time.Time) ([]model.BasketEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, ok := r.history[userID]
+	if !ok {
+		return []model.BasketEvent{}, nil
+	}
+	return history.get(until)
+}
+
+// record appends the events of a change to the basket's history. r.mu must
+// be held.
+func (r *MemoryBasketRepository) record(before, after *model.Basket, expiresAt time.Time) {
+	events := model.ChangeEvents(before, after, expiresAt)
+	if len(events) == 0 {
+		return
+	}
+	history, ok := r.history[after.UserID]
+	if !ok {
+		history = &memoryHistory{}
+		r.history[after.UserID] = history
+	}
+	history.append(after.UserID, events, maxHistoryEvents)
+	history.expiresAt = time.Now().Add(r.retention)
+}
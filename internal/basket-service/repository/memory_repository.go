@@ -0,0 +1,750 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+// evictInterval is how often expired entries are removed from memory.
+// Expired baskets, locks and checkout orders are never returned in between;
+// histories, lists and deleted product flags may outlive their retention by
+// up to evictInterval.
+const evictInterval = time.Minute
+
+// MemoryBasketRepository keeps baskets in process memory. It behaves like the
+// Redis repository, including basket expiry, but its state is lost on restart
+// and not shared between replicas, so it is meant for development and as a
+// fallback when Redis is unavailable. It is also the BasketWatcher and the
+// BasketHistory for the baskets it stores.
+type MemoryBasketRepository struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	retention time.Duration
+
+	baskets   map[string]memoryBasket
+	activity  map[string]time.Time // last update of non-empty baskets
+	abandoned map[string]time.Time // last activity of abandoned baskets
+	lists     map[string][]*model.List
+	listsAt   map[string]time.Time // last change of each user's lists
+	locks     map[string]memoryLock
+	pending   map[string]memoryOrder  // orders of unfinished checkouts
+	charges   map[string]memoryCharge // records of pending orders
+	orders    map[string]time.Time    // checkout orders and their expiry
+	deleted   map[string]time.Time    // deleted products and when they were
+	history   map[string]*memoryHistory
+	lastEvict time.Time
+
+	watchers basketWatchers
+}
+
+type memoryBasket struct {
+	basket    *model.Basket
+	expiresAt time.Time
+}
+
+type memoryLock struct {
+	token     string
+	expiresAt time.Time
+}
+
+type memoryOrder struct {
+	orderID   string
+	expiresAt time.Time
+}
+
+type memoryCharge struct {
+	order     model.PendingOrder
+	expiresAt time.Time
+}
+
+type memoryHistory struct {
+	eventLog
+	expiresAt time.Time
+}
+
+// NewMemoryBasketRepository creates an in-memory basket repository. Baskets
+// expire ttl after their last update. Histories, saved lists and deleted
+// product flags are dropped retention after their last change, so that the
+// memory held stays bounded.
+func NewMemoryBasketRepository(ttl, retention time.Duration) model.BasketRepository {
+	return &MemoryBasketRepository{
+		ttl:       ttl,
+		retention: retention,
+		baskets:   make(map[string]memoryBasket),
+		activity:  make(map[string]time.Time),
+		abandoned: make(map[string]time.Time),
+		lists:     make(map[string][]*model.List),
+		listsAt:   make(map[string]time.Time),
+		locks:     make(map[string]memoryLock),
+		pending:   make(map[string]memoryOrder),
+		charges:   make(map[string]memoryCharge),
+		orders:    make(map[string]time.Time),
+		deleted:   make(map[string]time.Time),
+		history:   make(map[string]*memoryHistory),
+		lastEvict: time.Now(),
+	}
+}
+
+func (r *MemoryBasketRepository) GetByUserID(userID string) (*model.Basket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.load(userID), nil
+}
+
+func (r *MemoryBasketRepository) AddItem(userID string, item model.BasketItem) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.AddItem(item)
+	})
+}
+
+func (r *MemoryBasketRepository) RemoveItem(userID, productID string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.RemoveItem(productID)
+	})
+}
+
+func (r *MemoryBasketRepository) UpdateQuantity(userID, productID string, quantity int32) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.SetQuantity(productID, quantity)
+	})
+}
+
+func (r *MemoryBasketRepository) Clear(userID string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.Clear()
+	})
+}
+
+func (r *MemoryBasketRepository) UpdatePrices(userID string, prices map[string]money.Money) error {
+	now := time.Now()
+	return r.mutate(userID, func(basket *model.Basket) {
+		for i, item := range basket.Items {
+			if price, ok := prices[item.ProductID]; ok {
+				basket.Items[i].Price = price
+				basket.Items[i].PriceSnapshotAt = now
+			}
+		}
+	})
+}
+
+func (r *MemoryBasketRepository) AddCoupon(userID, code string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.AddCoupon(code)
+	})
+}
+
+func (r *MemoryBasketRepository) RemoveCoupon(userID, code string) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.RemoveCoupon(code)
+	})
+}
+
+func (r *MemoryBasketRepository) SetDestination(userID string, destination *model.Address) error {
+	return r.mutate(userID, func(basket *model.Basket) {
+		basket.Destination = destination
+	})
+}
+
+func (r *MemoryBasketRepository) UpdateTotalAmount(userID string, totalAmount money.Money) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locked(userID) {
+		return model.ErrBasketLocked
+	}
+	basket := r.load(userID)
+	basket.TotalAmount = totalAmount
+	touch(basket)
+	r.save(basket)
+	return nil
+}
+
+// Merge moves the items of one basket into another and deletes the source
+// basket. Lines present in both baskets are combined according to policy.
+func (r *MemoryBasketRepository) Merge(fromUserID, toUserID, policy string, check func(before []model.BasketItem, merged *model.Basket) error) (*model.Basket, error) {
+	switch policy {
+	case model.MergeSum, model.MergeMax, model.MergeNewest:
+	default:
+		return nil, fmt.Errorf("%w: %s", model.ErrInvalidMergePolicy, policy)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locked(fromUserID) || r.locked(toUserID) {
+		return nil, model.ErrBasketLocked
+	}
+	from := r.load(fromUserID)
+	to := r.load(toUserID)
+
+	before := to.Items
+	to.Items = mergeItems(from, to, policy)
+	if to.Destination == nil {
+		to.Destination = from.Destination
+	}
+	for _, code := range from.CouponCodes {
+		if !containsString(to.CouponCodes, code) {
+			to.CouponCodes = append(to.CouponCodes, code)
+		}
+	}
+	if err := updateTotal(to); err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(before, to); err != nil {
+			return nil, err
+		}
+	}
+	touch(to)
+
+	r.save(to)
+	r.record(r.load(fromUserID), &model.Basket{UserID: fromUserID, Version: model.NewBasketVersion}, time.Time{})
+	delete(r.baskets, fromUserID)
+	delete(r.activity, fromUserID)
+	r.watchers.notify(fromUserID)
+
+	return copyBasket(to), nil
+}
+
+// GetIdleBaskets returns up to limit non-empty baskets that have not been
+// updated since idleSince, least recently updated first.
+func (r *MemoryBasketRepository) GetIdleBaskets(idleSince time.Time, limit int64) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evictExpired()
+	var userIDs []string
+	for _, userID := range sortedByTime(r.activity) {
+		if int64(len(userIDs)) >= limit {
+			break
+		}
+		if r.activity[userID].After(idleSince) {
+			break
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// MarkAbandoned flags a basket as abandoned if it is still idle since
+// idleSince. It reports false if the basket was updated or already claimed.
+func (r *MemoryBasketRepository) MarkAbandoned(userID string, idleSince time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lastActivity, ok := r.activity[userID]
+	if !ok || lastActivity.After(idleSince) {
+		return false, nil
+	}
+	delete(r.activity, userID)
+	r.abandoned[userID] = lastActivity
+	return true, nil
+}
+
+// UnmarkAbandoned reverts MarkAbandoned, unless the basket has been updated
+// in the meantime.
+func (r *MemoryBasketRepository) UnmarkAbandoned(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lastActivity, ok := r.abandoned[userID]
+	if !ok {
+		return nil
+	}
+	delete(r.abandoned, userID)
+	if _, ok := r.activity[userID]; !ok {
+		r.activity[userID] = lastActivity
+	}
+	return nil
+}
+
+// GetAbandonedBaskets returns up to limit abandoned baskets with the time of
+// their last activity before they were abandoned.
+func (r *MemoryBasketRepository) GetAbandonedBaskets(limit int64) (map[string]time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	abandoned := make(map[string]time.Time)
+	for _, userID := range sortedByTime(r.abandoned) {
+		if int64(len(abandoned)) >= limit {
+			break
+		}
+		abandoned[userID] = r.abandoned[userID]
+	}
+	return abandoned, nil
+}
+
+// ClearAbandoned stops tracking an abandoned basket. It reports false if the
+// basket was not tracked.
+func (r *MemoryBasketRepository) ClearAbandoned(userID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.abandoned[userID]; !ok {
+		return false, nil
+	}
+	delete(r.abandoned, userID)
+	return true, nil
+}
+
+func (r *MemoryBasketRepository) SetProductDeleted(productID string, deleted bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if deleted {
+		r.deleted[productID] = time.Now()
+	} else {
+		delete(r.deleted, productID)
+	}
+	return nil
+}
+
+func (r *MemoryBasketRepository) GetDeletedProducts(productIDs []string) (map[string]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := make(map[string]bool)
+	for _, id := range productIDs {
+		if _, ok := r.deleted[id]; ok {
+			deleted[id] = true
+		}
+	}
+	return deleted, nil
+}
+
+func (r *MemoryBasketRepository) GetLists(userID string) ([]*model.List, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return copyLists(withDefaultList(r.lists[userID])), nil
+}
+
+func (r *MemoryBasketRepository) CreateList(userID, name string) (*model.List, error) {
+	now := time.Now()
+	list := &model.List{
+		ID:        fmt.Sprintf("list_%d", now.UnixNano()),
+		Name:      name,
+		Items:     []model.BasketItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		return append(lists, copyList(list)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *MemoryBasketRepository) DeleteList(userID, listID string) error {
+	if listID == model.DefaultListID {
+		return model.ErrDefaultList
+	}
+
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		for i, list := range lists {
+			if list.ID == listID {
+				return append(lists[:i], lists[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", model.ErrListNotFound, listID)
+	})
+}
+
+func (r *MemoryBasketRepository) AddToList(userID, listID string, item model.BasketItem) error {
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return nil, err
+		}
+		addToList(list, item)
+		return lists, nil
+	})
+}
+
+func (r *MemoryBasketRepository) RemoveFromList(userID, listID, productID string) error {
+	return r.mutateLists(userID, func(lists []*model.List) ([]*model.List, error) {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := takeFromList(list, productID); err != nil {
+			return nil, err
+		}
+		return lists, nil
+	})
+}
+
+func (r *MemoryBasketRepository) MoveToList(userID, listID, productID string) error {
+	return r.move(userID, func(basket *model.Basket, lists []*model.List) error {
+		list, err := findList(lists, listID)
+		if err != nil {
+			return err
+		}
+
+		for i, item := range basket.Items {
+			if item.ProductID == productID {
+				basket.Items = append(basket.Items[:i], basket.Items[i+1:]...)
+				addToList(list, item)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", model.ErrItemNotFound, productID)
+	})
+}
+
+func (r *MemoryBasketRepository) MoveToBasket(userID, listID string, item model.BasketItem, check func(basket *model.Basket, moved model.BasketItem) error) error {
+	return r.move(userID, func(basket *model.Basket, lists []*model.List) error {
+		return moveToBasket(basket, lists, listID, item, check)
+	})
+}
+
+// LockBasket locks a basket against changes for the duration of a checkout.
+// The pending order is kept until the checkout completes or is unlocked, so
+// a checkout retried after its lock expired charges the same order.
+func (r *MemoryBasketRepository) LockBasket(userID, orderID string, ttl time.Duration) (*model.CheckoutLock, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating lock token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locked(userID) {
+		return nil, model.ErrBasketLocked
+	}
+	now := time.Now()
+	r.locks[userID] = memoryLock{token: token, expiresAt: now.Add(ttl)}
+	if pending, ok := r.pending[userID]; ok && now.Before(pending.expiresAt) {
+		orderID = pending.orderID
+	} else {
+		r.pending[userID] = memoryOrder{orderID: orderID, expiresAt: now.Add(model.PendingOrderTTL)}
+	}
+	return &model.CheckoutLock{Token: token, OrderID: orderID}, nil
+}
+
+func (r *MemoryBasketRepository) UnlockBasket(userID, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if lock, ok := r.locks[userID]; ok && lock.token == token {
+		delete(r.locks, userID)
+		delete(r.charges, r.pendingOrder(userID))
+		delete(r.pending, userID)
+	}
+	return nil
+}
+
+func (r *MemoryBasketRepository) SetPendingOrder(order *model.PendingOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt := time.Now().Add(model.PendingOrderTTL)
+	r.charges[order.OrderID] = memoryCharge{order: copyPendingOrder(order), expiresAt: expiresAt}
+	r.pending[order.UserID] = memoryOrder{orderID: order.OrderID, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *MemoryBasketRepository) GetPendingOrder(orderID string) (*model.PendingOrder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	charge, ok := r.charges[orderID]
+	if !ok || !time.Now().Before(charge.expiresAt) {
+		return nil, nil
+	}
+	order := copyPendingOrder(&charge.order)
+	return &order, nil
+}
+
+// CompleteCheckout takes the lines of a paid order out of the basket, records
+// the order and releases the checkout lock if it is held with token or for
+// the order.
+func (r *MemoryBasketRepository) CompleteCheckout(userID, token string, order *model.PendingOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	basket := r.load(userID)
+	basket.RemoveOrdered(order)
+	if err := updateTotal(basket); err != nil {
+		return err
+	}
+	touch(basket)
+	r.save(basket)
+
+	orderID := order.OrderID
+	r.orders[orderID] = time.Now().Add(checkoutOrderTTL)
+	delete(r.charges, orderID)
+	forOrder := r.pendingOrder(userID) == orderID
+	if lock, ok := r.locks[userID]; ok && (lock.token == token || forOrder) {
+		delete(r.locks, userID)
+	}
+	if forOrder {
+		delete(r.pending, userID)
+	}
+	return nil
+}
+
+func (r *MemoryBasketRepository) IsCheckoutOrder(orderID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt, ok := r.orders[orderID]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+func (r *MemoryBasketRepository) PendingOrder(userID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.pendingOrder(userID), nil
+}
+
+// pendingOrder returns the order of an unfinished checkout. r.mu must be held.
+func (r *MemoryBasketRepository) pendingOrder(userID string) string {
+	pending, ok := r.pending[userID]
+	if !ok || !time.Now().Before(pending.expiresAt) {
+		return ""
+	}
+	return pending.orderID
+}
+
+// Update applies fn to a copy of the stored basket, recalculates its total
+// and stores it. Updates of the same repository are serialised.
+func (r *MemoryBasketRepository) Update(userID string, expectedVersion int64, fn func(basket *model.Basket) error) (*model.Basket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locked(userID) {
+		return nil, model.ErrBasketLocked
+	}
+	basket := r.load(userID)
+	if err := checkVersion(basket, expectedVersion); err != nil {
+		return nil, err
+	}
+	if err := fn(basket); err != nil {
+		return nil, err
+	}
+
+	if err := updateTotal(basket); err != nil {
+		return nil, err
+	}
+	touch(basket)
+	r.save(basket)
+	return copyBasket(basket), nil
+}
+
+// mutate applies fn to the stored basket whatever its version.
+func (r *MemoryBasketRepository) mutate(userID string, fn func(basket *model.Basket)) error {
+	_, err := r.Update(userID, 0, func(basket *model.Basket) error {
+		fn(basket)
+		return nil
+	})
+	return err
+}
+
+// mutateLists applies fn to a copy of the user's lists and stores the result
+// unless fn fails.
+func (r *MemoryBasketRepository) mutateLists(userID string, fn func(lists []*model.List) ([]*model.List, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evictExpired()
+	lists, err := fn(copyLists(withDefaultList(r.lists[userID])))
+	if err != nil {
+		return err
+	}
+	r.lists[userID] = lists
+	r.listsAt[userID] = time.Now()
+	return nil
+}
+
+// move applies fn to copies of the basket and the lists of a user and stores
+// both unless fn fails.
+func (r *MemoryBasketRepository) move(userID string, fn func(basket *model.Basket, lists []*model.List) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.locked(userID) {
+		return model.ErrBasketLocked
+	}
+	basket := r.load(userID)
+	lists := copyLists(withDefaultList(r.lists[userID]))
+
+	if err := fn(basket, lists); err != nil {
+		return err
+	}
+	if err := updateTotal(basket); err != nil {
+		return err
+	}
+	touch(basket)
+
+	r.save(basket)
+	r.lists[userID] = lists
+	r.listsAt[userID] = time.Now()
+	return nil
+}
+
+// load returns a copy of the stored basket, or a new empty basket if there
+// is none or it has expired. r.mu must be held.
+func (r *MemoryBasketRepository) load(userID string) *model.Basket {
+	r.evictExpired()
+
+	stored, ok := r.baskets[userID]
+	if !ok || !time.Now().Before(stored.expiresAt) {
+		now := time.Now()
+		return &model.Basket{
+			UserID:    userID,
+			Items:     []model.BasketItem{},
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   model.NewBasketVersion,
+		}
+	}
+	return copyBasket(stored.basket)
+}
+
+// save stores a copy of the basket, refreshes its expiry, records the change
+// in its history, keeps the activity index up to date and notifies the
+// basket's watchers. Empty baskets are not tracked. r.mu must be held.
+func (r *MemoryBasketRepository) save(basket *model.Basket) {
+	expiresAt := time.Now().Add(r.ttl)
+	r.record(r.load(basket.UserID), basket, expiresAt)
+	r.baskets[basket.UserID] = memoryBasket{
+		basket:    copyBasket(basket),
+		expiresAt: expiresAt,
+	}
+	if len(basket.Items) > 0 {
+		r.activity[basket.UserID] = basket.UpdatedAt
+	} else {
+		delete(r.activity, basket.UserID)
+	}
+	r.watchers.notify(basket.UserID)
+}
+
+// Watch signals changes of a user's basket. Expiry is signalled when the
+// basket is evicted.
+func (r *MemoryBasketRepository) Watch(userID string) (<-chan struct{}, func()) {
+	return r.watchers.watch(userID)
+}
+
+// locked reports whether a checkout holds the basket. r.mu must be held.
+func (r *MemoryBasketRepository) locked(userID string) bool {
+	lock, ok := r.locks[userID]
+	return ok && time.Now().Before(lock.expiresAt)
+}
+
+// evictExpired removes expired baskets, locks, checkout orders, histories,
+// lists and deleted product flags, at most once per evictInterval. Abandoned
+// baskets stay tracked so that their expiry can be reported. r.mu must be
+// held.
+func (r *MemoryBasketRepository) evictExpired() {
+	now := time.Now()
+	if now.Sub(r.lastEvict) < evictInterval {
+		return
+	}
+	r.lastEvict = now
+
+	for userID, stored := range r.baskets {
+		if !now.Before(stored.expiresAt) {
+			delete(r.baskets, userID)
+			delete(r.activity, userID)
+			r.watchers.notify(userID)
+		}
+	}
+	for userID, lock := range r.locks {
+		if !now.Before(lock.expiresAt) {
+			delete(r.locks, userID)
+		}
+	}
+	for userID, pending := range r.pending {
+		if !now.Before(pending.expiresAt) {
+			delete(r.pending, userID)
+		}
+	}
+	for orderID, charge := range r.charges {
+		if !now.Before(charge.expiresAt) {
+			delete(r.charges, orderID)
+		}
+	}
+	for orderID, expiresAt := range r.orders {
+		if !now.Before(expiresAt) {
+			delete(r.orders, orderID)
+		}
+	}
+	for userID, history := range r.history {
+		if !now.Before(history.expiresAt) {
+			delete(r.history, userID)
+		}
+	}
+	for userID, changedAt := range r.listsAt {
+		if !now.Before(changedAt.Add(r.retention)) {
+			delete(r.lists, userID)
+			delete(r.listsAt, userID)
+		}
+	}
+	for productID, deletedAt := range r.deleted {
+		if !now.Before(deletedAt.Add(r.retention)) {
+			delete(r.deleted, productID)
+		}
+	}
+}
+
+// sortedByTime returns the keys of m, earliest time first.
+func sortedByTime(m map[string]time.Time) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return m[keys[i]].Before(m[keys[j]])
+	})
+	return keys
+}
+
+func copyBasket(basket *model.Basket) *model.Basket {
+	c := *basket
+	c.Items = append([]model.BasketItem{}, basket.Items...)
+	if basket.CouponCodes != nil {
+		c.CouponCodes = append([]string{}, basket.CouponCodes...)
+	}
+	if basket.Destination != nil {
+		destination := *basket.Destination
+		c.Destination = &destination
+	}
+	return &c
+}
+
+func copyPendingOrder(order *model.PendingOrder) model.PendingOrder {
+	c := *order
+	c.Items = append([]model.BasketItem(nil), order.Items...)
+	c.CouponCodes = append([]string(nil), order.CouponCodes...)
+	c.Redeemed = append([]string(nil), order.Redeemed...)
+	return c
+}
+
+func copyLists(lists []*model.List) []*model.List {
+	c := make([]*model.List, len(lists))
+	for i, list := range lists {
+		c[i] = copyList(list)
+	}
+	return c
+}
+
+func copyList(list *model.List) *model.List {
+	c := *list
+	c.Items = append([]model.BasketItem{}, list.Items...)
+	return &c
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/repository/repositorytest"
+	"daprps/internal/money"
+)
+
+func TestMemoryRepositoryConformance(t *testing.T) {
+	if err := repositorytest.TestBasketRepository(NewMemoryBasketRepository(time.Hour, 24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMemoryRepositoryEviction checks that histories, lists and deleted
+// product flags are dropped once their retention has passed.
+func TestMemoryRepositoryEviction(t *testing.T) {
+	repo := NewMemoryBasketRepository(time.Millisecond, 10*time.Millisecond).(*MemoryBasketRepository)
+
+	if err := repo.AddItem("user", model.BasketItem{ProductID: "a", Price: money.New(100, "USD"), Quantity: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateList("user", "later"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetProductDeleted("a", true); err != nil {
+		t.Fatal(err)
+	}
+
+	evict := func() {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.lastEvict = time.Time{}
+		repo.evictExpired()
+	}
+
+	evict()
+	if len(repo.history) != 1 || len(repo.lists) != 1 || len(repo.deleted) != 1 {
+		t.Fatalf("evicted before the retention: %d histories, %d lists, %d deleted products, want 1 each",
+			len(repo.history), len(repo.lists), len(repo.deleted))
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	evict()
+	if len(repo.baskets) != 0 || len(repo.history) != 0 || len(repo.lists) != 0 || len(repo.listsAt) != 0 || len(repo.deleted) != 0 {
+		t.Errorf("after the retention: %d baskets, %d histories, %d lists, %d deleted products, want none",
+			len(repo.baskets), len(repo.history), len(repo.lists), len(repo.deleted))
+	}
+}
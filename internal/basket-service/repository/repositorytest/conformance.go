@@ -0,0 +1,961 @@
+// Package repositorytest checks that basket repository backends behave the
+// same way.
+//
+// Every model.BasketRepository implementation must pass TestBasketRepository,
+// for instance from a test of the backend:
+//
+//	if err := repositorytest.TestBasketRepository(repository.NewMemoryBasketRepository(time.Hour, 24*time.Hour)); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// Repositories that are also a model.BasketHistory must record every change
+// in it.
+//
+// The checks use basket, list and product IDs unique to each run, so they can
+// also run against a shared store such as a development Redis.
+package repositorytest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+type check struct {
+	name string
+	run  func(repo model.BasketRepository, id string) error
+}
+
+var checks = []check{
+	{"empty basket", checkEmptyBasket},
+	{"add item", checkAddItem},
+	{"update quantity", checkUpdateQuantity},
+	{"remove item", checkRemoveItem},
+	{"clear", checkClear},
+	{"coupons", checkCoupons},
+	{"destination", checkDestination},
+	{"update prices", checkUpdatePrices},
+	{"merge", checkMerge},
+	{"merge policies", checkMergePolicies},
+	{"checkout lock", checkCheckoutLock},
+	{"complete checkout", checkCompleteCheckout},
+	{"lists", checkLists},
+	{"list moves", checkListMoves},
+	{"abandonment", checkAbandonment},
+	{"deleted products", checkDeletedProducts},
+	{"concurrent updates", checkConcurrentUpdates},
+	{"versions", checkVersions},
+	{"currencies", checkCurrencies},
+	{"history", checkHistory},
+}
+
+// TestBasketRepository runs every conformance check against repo and returns
+// the failures joined into one error, or nil.
+func TestBasketRepository(repo model.BasketRepository) error {
+	prefix := fmt.Sprintf("conformance-%d", time.Now().UnixNano())
+
+	var errs []error
+	for i, c := range checks {
+		if err := c.run(repo, fmt.Sprintf("%s-%d", prefix, i)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkEmptyBasket(repo model.BasketRepository, id string) error {
+	basket, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	if basket.UserID != id {
+		return fmt.Errorf("user ID %q, want %q", basket.UserID, id)
+	}
+	if len(basket.Items) != 0 || !basket.TotalAmount.IsZero() {
+		return fmt.Errorf("new basket has %d items totalling %s", len(basket.Items), basket.TotalAmount)
+	}
+	return nil
+}
+
+func checkAddItem(repo model.BasketRepository, id string) error {
+	if err := repo.AddItem(id, item("a", 2.5, 2)); err != nil {
+		return err
+	}
+	if err := repo.AddItem(id, item("b", 10, 1)); err != nil {
+		return err
+	}
+	if err := repo.AddItem(id, item("a", 2.5, 3)); err != nil {
+		return err
+	}
+	return expectBasket(repo, id, map[string]int32{"a": 5, "b": 1}, 22.5)
+}
+
+func checkUpdateQuantity(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 1), item("b", 2, 1)); err != nil {
+		return err
+	}
+	if err := repo.UpdateQuantity(id, "a", 4); err != nil {
+		return err
+	}
+	if err := repo.UpdateQuantity(id, "b", 0); err != nil {
+		return err
+	}
+	if err := repo.UpdateQuantity(id, "missing", 3); err != nil {
+		return err
+	}
+	return expectBasket(repo, id, map[string]int32{"a": 4}, 4)
+}
+
+func checkRemoveItem(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 1), item("b", 2, 1)); err != nil {
+		return err
+	}
+	if err := repo.RemoveItem(id, "a"); err != nil {
+		return err
+	}
+	if err := repo.RemoveItem(id, "missing"); err != nil {
+		return err
+	}
+	return expectBasket(repo, id, map[string]int32{"b": 1}, 2)
+}
+
+func checkClear(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 1)); err != nil {
+		return err
+	}
+	if err := repo.AddCoupon(id, "SAVE10"); err != nil {
+		return err
+	}
+	if err := repo.Clear(id); err != nil {
+		return err
+	}
+	basket, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	if len(basket.CouponCodes) != 0 {
+		return fmt.Errorf("coupons %v kept after clear", basket.CouponCodes)
+	}
+	return expectBasket(repo, id, nil, 0)
+}
+
+func checkCoupons(repo model.BasketRepository, id string) error {
+	for _, code := range []string{"A", "B", "A"} {
+		if err := repo.AddCoupon(id, code); err != nil {
+			return err
+		}
+	}
+	if err := repo.RemoveCoupon(id, "A"); err != nil {
+		return err
+	}
+	basket, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	if len(basket.CouponCodes) != 1 || basket.CouponCodes[0] != "B" {
+		return fmt.Errorf("coupons %v, want [B]", basket.CouponCodes)
+	}
+	return nil
+}
+
+func checkDestination(repo model.BasketRepository, id string) error {
+	destination := &model.Address{Country: "US", Region: "CA", PostalCode: "94107"}
+	if err := repo.SetDestination(id, destination); err != nil {
+		return err
+	}
+	destination.Country = "changed"
+
+	basket, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	if basket.Destination == nil || basket.Destination.Country != "US" || basket.Destination.Region != "CA" {
+		return fmt.Errorf("destination %+v, want US/CA", basket.Destination)
+	}
+	return nil
+}
+
+func checkUpdatePrices(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 2), item("b", 5, 1)); err != nil {
+		return err
+	}
+	before := time.Now()
+	if err := repo.UpdatePrices(id, map[string]money.Money{"a": usd(3)}); err != nil {
+		return err
+	}
+
+	basket, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	for _, line := range basket.Items {
+		if line.ProductID == "a" && (line.Price != usd(3) || line.PriceSnapshotAt.Before(before.Truncate(time.Second))) {
+			return fmt.Errorf("line a has price %s snapshotted at %s", line.Price, line.PriceSnapshotAt)
+		}
+		if line.ProductID == "b" && line.Price != usd(5) {
+			return fmt.Errorf("line b repriced to %s", line.Price)
+		}
+	}
+	return expectBasket(repo, id, map[string]int32{"a": 2, "b": 1}, 11)
+}
+
+func checkMerge(repo model.BasketRepository, id string) error {
+	guest := id + "-guest"
+	if err := addItems(repo, guest, item("a", 1, 1), item("b", 2, 2)); err != nil {
+		return err
+	}
+	if err := repo.AddCoupon(guest, "WELCOME"); err != nil {
+		return err
+	}
+	if err := addItems(repo, id, item("a", 1, 2)); err != nil {
+		return err
+	}
+
+	// A rejected merge leaves both baskets as they were
+	errRejected := errors.New("rejected")
+	var seen, seenBefore int32
+	_, err := repo.Merge(guest, id, model.MergeSum, func(before []model.BasketItem, merged *model.Basket) error {
+		if i := merged.FindItem("a"); i >= 0 {
+			seen = merged.Items[i].Quantity
+		}
+		if len(before) == 1 && before[0].ProductID == "a" {
+			seenBefore = before[0].Quantity
+		}
+		return errRejected
+	})
+	if !errors.Is(err, errRejected) || seen != 3 || seenBefore != 2 {
+		return fmt.Errorf("rejected merge returned %v and saw quantity %d after and %d before, want %v, 3 and 2", err, seen, seenBefore, errRejected)
+	}
+	if err := expectBasket(repo, guest, map[string]int32{"a": 1, "b": 2}, 5); err != nil {
+		return fmt.Errorf("guest basket after rejected merge: %w", err)
+	}
+	if err := expectBasket(repo, id, map[string]int32{"a": 2}, 2); err != nil {
+		return fmt.Errorf("basket after rejected merge: %w", err)
+	}
+
+	merged, err := repo.Merge(guest, id, model.MergeSum, nil)
+	if err != nil {
+		return err
+	}
+	if merged.UserID != id || len(merged.Items) != 2 {
+		return fmt.Errorf("merged basket %s has %d lines, want %s with 2", merged.UserID, len(merged.Items), id)
+	}
+	if len(merged.CouponCodes) != 1 || merged.CouponCodes[0] != "WELCOME" {
+		return fmt.Errorf("merged coupons %v, want [WELCOME]", merged.CouponCodes)
+	}
+	if err := expectBasket(repo, id, map[string]int32{"a": 3, "b": 2}, 7); err != nil {
+		return err
+	}
+	if err := expectBasket(repo, guest, nil, 0); err != nil {
+		return fmt.Errorf("guest basket: %w", err)
+	}
+
+	if _, err := repo.Merge(guest, id, "unknown", nil); !errors.Is(err, model.ErrInvalidMergePolicy) {
+		return fmt.Errorf("invalid policy: got %v, want %v", err, model.ErrInvalidMergePolicy)
+	}
+	return nil
+}
+
+func checkMergePolicies(repo model.BasketRepository, id string) error {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+
+	for _, tc := range []struct {
+		policy   string
+		quantity int32
+		price    float64
+	}{
+		{model.MergeMax, 3, 1},
+		{model.MergeNewest, 1, 2},
+	} {
+		from, to := id+"-"+tc.policy+"-from", id+"-"+tc.policy+"-to"
+
+		fromItem := item("a", 2, 1)
+		fromItem.PriceSnapshotAt = newer
+		toItem := item("a", 1, 3)
+		toItem.PriceSnapshotAt = older
+		if err := addItems(repo, from, fromItem); err != nil {
+			return err
+		}
+		if err := addItems(repo, to, toItem); err != nil {
+			return err
+		}
+
+		merged, err := repo.Merge(from, to, tc.policy, nil)
+		if err != nil {
+			return err
+		}
+		if len(merged.Items) != 1 || merged.Items[0].Quantity != tc.quantity || merged.Items[0].Price != usd(tc.price) {
+			return fmt.Errorf("%s: merged lines %+v, want quantity %d at %.2f", tc.policy, merged.Items, tc.quantity, tc.price)
+		}
+	}
+	return nil
+}
+
+func checkCheckoutLock(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 1)); err != nil {
+		return err
+	}
+	lock, err := repo.LockBasket(id, id+"-order-1", time.Minute)
+	if err != nil {
+		return err
+	}
+	if lock.OrderID != id+"-order-1" {
+		return fmt.Errorf("lock order: got %q, want %q", lock.OrderID, id+"-order-1")
+	}
+	if _, err := pendingOrder(repo, id, lock.OrderID); err != nil {
+		return err
+	}
+	if _, err := repo.LockBasket(id, id+"-order-2", time.Minute); !errors.Is(err, model.ErrBasketLocked) {
+		return fmt.Errorf("second lock: got %v, want %v", err, model.ErrBasketLocked)
+	}
+	if err := repo.AddItem(id, item("b", 1, 1)); !errors.Is(err, model.ErrBasketLocked) {
+		return fmt.Errorf("add to locked basket: got %v, want %v", err, model.ErrBasketLocked)
+	}
+	if err := repo.MoveToList(id, "", "a"); !errors.Is(err, model.ErrBasketLocked) {
+		return fmt.Errorf("move from locked basket: got %v, want %v", err, model.ErrBasketLocked)
+	}
+
+	// Only the holder of the lock can release it
+	if err := repo.UnlockBasket(id, "wrong-token"); err != nil {
+		return err
+	}
+	if err := repo.Clear(id); !errors.Is(err, model.ErrBasketLocked) {
+		return fmt.Errorf("unlocked with a wrong token: got %v, want %v", err, model.ErrBasketLocked)
+	}
+	if err := repo.UnlockBasket(id, lock.Token); err != nil {
+		return err
+	}
+	if pending, err := repo.PendingOrder(id); err != nil || pending != "" {
+		return fmt.Errorf("pending order after unlock: got %q (%v), want none", pending, err)
+	}
+	if recorded, err := repo.GetPendingOrder(id + "-order-1"); err != nil || recorded != nil {
+		return fmt.Errorf("order record after unlock: got %+v (%v), want none", recorded, err)
+	}
+	if err := repo.AddItem(id, item("b", 1, 1)); err != nil {
+		return fmt.Errorf("add after unlock: %w", err)
+	}
+
+	// Locks expire, but the order of the checkout stays pending and is
+	// reused by the next one
+	if _, err := repo.LockBasket(id, id+"-order-3", 10*time.Millisecond); err != nil {
+		return err
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := repo.AddItem(id, item("c", 1, 1)); err != nil {
+		return fmt.Errorf("add after lock expiry: %w", err)
+	}
+	if pending, err := repo.PendingOrder(id); err != nil || pending != id+"-order-3" {
+		return fmt.Errorf("pending order after lock expiry: got %q (%v), want %q", pending, err, id+"-order-3")
+	}
+	lock, err = repo.LockBasket(id, id+"-order-4", time.Minute)
+	if err != nil {
+		return err
+	}
+	if lock.OrderID != id+"-order-3" {
+		return fmt.Errorf("retried checkout order: got %q, want %q", lock.OrderID, id+"-order-3")
+	}
+
+	// A replaced order stays recorded for its payment event
+	if _, err := pendingOrder(repo, id, lock.OrderID); err != nil {
+		return err
+	}
+	if _, err := pendingOrder(repo, id, id+"-order-5"); err != nil {
+		return err
+	}
+	if err := repo.UnlockBasket(id, lock.Token); err != nil {
+		return err
+	}
+	if recorded, err := repo.GetPendingOrder(id + "-order-5"); err != nil || recorded != nil {
+		return fmt.Errorf("order record after unlock: got %+v (%v), want none", recorded, err)
+	}
+	if recorded, err := repo.GetPendingOrder(id + "-order-3"); err != nil || recorded == nil {
+		return fmt.Errorf("replaced order record: got %+v (%v), want it kept", recorded, err)
+	}
+	return nil
+}
+
+func checkCompleteCheckout(repo model.BasketRepository, id string) error {
+	orderID := id + "-order"
+	if err := addItems(repo, id, item("a", 1, 1)); err != nil {
+		return err
+	}
+	if err := repo.AddCoupon(id, "SAVE10"); err != nil {
+		return err
+	}
+	lock, err := repo.LockBasket(id, orderID, time.Minute)
+	if err != nil {
+		return err
+	}
+	order, err := pendingOrder(repo, id, orderID)
+	if err != nil {
+		return err
+	}
+
+	if checkedOut, err := repo.IsCheckoutOrder(orderID); err != nil || checkedOut {
+		return fmt.Errorf("order reported checked out before checkout (%v)", err)
+	}
+	if err := repo.CompleteCheckout(id, lock.Token, order); err != nil {
+		return err
+	}
+	if checkedOut, err := repo.IsCheckoutOrder(orderID); err != nil || !checkedOut {
+		return fmt.Errorf("order not reported checked out (%v)", err)
+	}
+	if err := expectBasket(repo, id, nil, 0); err != nil {
+		return err
+	}
+	if pending, err := repo.PendingOrder(id); err != nil || pending != "" {
+		return fmt.Errorf("pending order after checkout: got %q (%v), want none", pending, err)
+	}
+	if recorded, err := repo.GetPendingOrder(orderID); err != nil || recorded != nil {
+		return fmt.Errorf("order record after checkout: got %+v (%v), want none", recorded, err)
+	}
+	if err := repo.AddItem(id, item("a", 1, 1)); err != nil {
+		return fmt.Errorf("lock kept after checkout: %w", err)
+	}
+
+	// The payment event completes a checkout without the lock token
+	if _, err := repo.LockBasket(id, orderID+"-2", time.Minute); err != nil {
+		return err
+	}
+	if order, err = pendingOrder(repo, id, orderID+"-2"); err != nil {
+		return err
+	}
+	if err := repo.CompleteCheckout(id, "", order); err != nil {
+		return err
+	}
+	if err := expectBasket(repo, id, nil, 0); err != nil {
+		return err
+	}
+	if err := repo.AddItem(id, item("a", 1, 2)); err != nil {
+		return fmt.Errorf("lock kept after checkout by event: %w", err)
+	}
+
+	// A payment event that arrives after the lock expired takes only the
+	// charged lines out of the basket
+	if _, err := repo.LockBasket(id, orderID+"-3", 10*time.Millisecond); err != nil {
+		return err
+	}
+	if order, err = pendingOrder(repo, id, orderID+"-3"); err != nil {
+		return err
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := addItems(repo, id, item("a", 1, 1), item("b", 2, 1)); err != nil {
+		return err
+	}
+	if err := repo.CompleteCheckout(id, "", order); err != nil {
+		return err
+	}
+	return expectBasket(repo, id, map[string]int32{"a": 1, "b": 1}, 3)
+}
+
+// pendingOrder records the basket as the charge of orderID, as a checkout
+// does before taking the payment.
+func pendingOrder(repo model.BasketRepository, userID, orderID string) (*model.PendingOrder, error) {
+	basket, err := repo.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	order := &model.PendingOrder{
+		OrderID:     orderID,
+		UserID:      userID,
+		Amount:      basket.TotalAmount,
+		Items:       basket.Items,
+		CouponCodes: basket.CouponCodes,
+	}
+	if err := repo.SetPendingOrder(order); err != nil {
+		return nil, err
+	}
+	recorded, err := repo.GetPendingOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if recorded == nil || !recorded.SameCharge(order) {
+		return nil, fmt.Errorf("order record: got %+v, want %+v", recorded, order)
+	}
+	if pending, err := repo.PendingOrder(userID); err != nil || pending != orderID {
+		return nil, fmt.Errorf("pending order: got %q (%v), want %q", pending, err, orderID)
+	}
+	return order, nil
+}
+
+func checkLists(repo model.BasketRepository, id string) error {
+	lists, err := repo.GetLists(id)
+	if err != nil {
+		return err
+	}
+	if len(lists) != 1 || lists[0].ID != model.DefaultListID {
+		return fmt.Errorf("new user has %d lists, want only %s", len(lists), model.DefaultListID)
+	}
+
+	wishlist, err := repo.CreateList(id, "Birthday")
+	if err != nil {
+		return err
+	}
+	if err := repo.AddToList(id, wishlist.ID, item("a", 1, 1)); err != nil {
+		return err
+	}
+	if err := repo.AddToList(id, wishlist.ID, item("a", 1, 2)); err != nil {
+		return err
+	}
+	if err := repo.AddToList(id, "", item("b", 1, 1)); err != nil {
+		return err
+	}
+
+	lists, err = repo.GetLists(id)
+	if err != nil {
+		return err
+	}
+	if len(lists) != 2 {
+		return fmt.Errorf("%d lists, want 2", len(lists))
+	}
+	if err := expectList(lists, wishlist.ID, map[string]int32{"a": 3}); err != nil {
+		return err
+	}
+	if err := expectList(lists, model.DefaultListID, map[string]int32{"b": 1}); err != nil {
+		return err
+	}
+
+	if err := repo.RemoveFromList(id, wishlist.ID, "missing"); !errors.Is(err, model.ErrItemNotFound) {
+		return fmt.Errorf("remove missing item: got %v, want %v", err, model.ErrItemNotFound)
+	}
+	if err := repo.AddToList(id, "missing", item("a", 1, 1)); !errors.Is(err, model.ErrListNotFound) {
+		return fmt.Errorf("add to missing list: got %v, want %v", err, model.ErrListNotFound)
+	}
+	if err := repo.DeleteList(id, model.DefaultListID); !errors.Is(err, model.ErrDefaultList) {
+		return fmt.Errorf("delete default list: got %v, want %v", err, model.ErrDefaultList)
+	}
+	if err := repo.DeleteList(id, wishlist.ID); err != nil {
+		return err
+	}
+	if err := repo.DeleteList(id, wishlist.ID); !errors.Is(err, model.ErrListNotFound) {
+		return fmt.Errorf("delete deleted list: got %v, want %v", err, model.ErrListNotFound)
+	}
+	return nil
+}
+
+func checkListMoves(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 2), item("b", 3, 1)); err != nil {
+		return err
+	}
+	if err := repo.MoveToList(id, "", "a"); err != nil {
+		return err
+	}
+	if err := repo.MoveToList(id, "", "missing"); !errors.Is(err, model.ErrItemNotFound) {
+		return fmt.Errorf("move missing item: got %v, want %v", err, model.ErrItemNotFound)
+	}
+	if err := expectBasket(repo, id, map[string]int32{"b": 1}, 3); err != nil {
+		return err
+	}
+
+	lists, err := repo.GetLists(id)
+	if err != nil {
+		return err
+	}
+	if err := expectList(lists, model.DefaultListID, map[string]int32{"a": 2}); err != nil {
+		return err
+	}
+
+	// A failing check sees the moved line and leaves both sides untouched
+	errRejected := errors.New("rejected")
+	var seen int32
+	err = repo.MoveToBasket(id, "", item("a", 4, 5), func(basket *model.Basket, moved model.BasketItem) error {
+		if i := basket.FindItem("a"); i >= 0 && moved.Quantity == 2 {
+			seen = basket.Items[i].Quantity
+		}
+		return errRejected
+	})
+	if !errors.Is(err, errRejected) || seen != 2 {
+		return fmt.Errorf("rejected move: got %v with quantity %d, want %v with quantity 2", err, seen, errRejected)
+	}
+	if err := expectBasket(repo, id, map[string]int32{"b": 1}, 3); err != nil {
+		return err
+	}
+
+	// Moving back uses the item given by the caller, at the current price,
+	// with the quantity saved in the list
+	if err := repo.MoveToBasket(id, "", item("a", 4, 5), nil); err != nil {
+		return err
+	}
+	if err := repo.MoveToBasket(id, "", item("a", 4, 2), nil); !errors.Is(err, model.ErrItemNotFound) {
+		return fmt.Errorf("move item twice: got %v, want %v", err, model.ErrItemNotFound)
+	}
+	if err := expectBasket(repo, id, map[string]int32{"a": 2, "b": 1}, 11); err != nil {
+		return err
+	}
+	lists, err = repo.GetLists(id)
+	if err != nil {
+		return err
+	}
+	return expectList(lists, model.DefaultListID, nil)
+}
+
+func checkAbandonment(repo model.BasketRepository, id string) error {
+	if err := addItems(repo, id, item("a", 1, 1)); err != nil {
+		return err
+	}
+	idleSince := time.Now().Add(time.Second)
+
+	idle, err := repo.GetIdleBaskets(idleSince, 1<<20)
+	if err != nil {
+		return err
+	}
+	if !contains(idle, id) {
+		return fmt.Errorf("basket not reported idle")
+	}
+	if idle, err := repo.GetIdleBaskets(time.Now().Add(-time.Hour), 1<<20); err != nil || contains(idle, id) {
+		return fmt.Errorf("recently updated basket reported idle (%v)", err)
+	}
+
+	if claimed, err := repo.MarkAbandoned(id, idleSince); err != nil || !claimed {
+		return fmt.Errorf("basket not marked abandoned (%v)", err)
+	}
+	if claimed, err := repo.MarkAbandoned(id, idleSince); err != nil || claimed {
+		return fmt.Errorf("basket marked abandoned twice (%v)", err)
+	}
+	abandoned, err := repo.GetAbandonedBaskets(1 << 20)
+	if err != nil {
+		return err
+	}
+	if _, ok := abandoned[id]; !ok {
+		return fmt.Errorf("abandoned basket not listed")
+	}
+
+	if err := repo.UnmarkAbandoned(id); err != nil {
+		return err
+	}
+	if claimed, err := repo.MarkAbandoned(id, idleSince); err != nil || !claimed {
+		return fmt.Errorf("unmarked basket not marked abandoned again (%v)", err)
+	}
+	if cleared, err := repo.ClearAbandoned(id); err != nil || !cleared {
+		return fmt.Errorf("abandoned basket not cleared (%v)", err)
+	}
+	if cleared, err := repo.ClearAbandoned(id); err != nil || cleared {
+		return fmt.Errorf("abandoned basket cleared twice (%v)", err)
+	}
+
+	// Emptied baskets are no longer idle
+	if err := repo.Clear(id); err != nil {
+		return err
+	}
+	if idle, err := repo.GetIdleBaskets(idleSince, 1<<20); err != nil || contains(idle, id) {
+		return fmt.Errorf("empty basket reported idle (%v)", err)
+	}
+	return nil
+}
+
+func checkDeletedProducts(repo model.BasketRepository, id string) error {
+	deletedID, keptID := id+"-deleted", id+"-kept"
+	if err := repo.SetProductDeleted(deletedID, true); err != nil {
+		return err
+	}
+
+	deleted, err := repo.GetDeletedProducts([]string{deletedID, keptID})
+	if err != nil {
+		return err
+	}
+	if !deleted[deletedID] || deleted[keptID] {
+		return fmt.Errorf("deleted products %v, want only %s", deleted, deletedID)
+	}
+
+	if err := repo.SetProductDeleted(deletedID, false); err != nil {
+		return err
+	}
+	deleted, err = repo.GetDeletedProducts([]string{deletedID})
+	if err != nil {
+		return err
+	}
+	if deleted[deletedID] {
+		return fmt.Errorf("restored product still deleted")
+	}
+	return nil
+}
+
+func checkConcurrentUpdates(repo model.BasketRepository, id string) error {
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.AddItem(id, item("a", 1, 1))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Writers may give up with ErrBasketConflict, but no update may be lost
+	var added int32
+	for err := range errs {
+		switch {
+		case err == nil:
+			added++
+		case !errors.Is(err, model.ErrBasketConflict):
+			return err
+		}
+	}
+	return expectBasket(repo, id, map[string]int32{"a": added}, float64(added))
+}
+
+func checkVersions(repo model.BasketRepository, id string) error {
+	basket, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	if basket.Version != model.NewBasketVersion {
+		return fmt.Errorf("new basket at version %d, want %d", basket.Version, model.NewBasketVersion)
+	}
+
+	// The first update can expect the version of the new basket
+	first, err := repo.Update(id, model.NewBasketVersion, func(basket *model.Basket) error {
+		basket.AddItem(item("a", 1, 1))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if first.Version <= model.NewBasketVersion {
+		return fmt.Errorf("basket at version %d after one update, want more than %d", first.Version, model.NewBasketVersion)
+	}
+	_, err = repo.Update(id, model.NewBasketVersion, func(basket *model.Basket) error {
+		basket.AddItem(item("a", 1, 1))
+		return nil
+	})
+	if !errors.Is(err, model.ErrVersionMismatch) {
+		return fmt.Errorf("second update of the new basket returned %v, want ErrVersionMismatch", err)
+	}
+
+	updated, err := repo.Update(id, first.Version, func(basket *model.Basket) error {
+		basket.SetQuantity("a", 3)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if updated.Version <= first.Version || updated.TotalAmount != usd(3) {
+		return fmt.Errorf("update returned version %d with total %v, want more than %d with total 3", updated.Version, updated.TotalAmount, first.Version)
+	}
+
+	_, err = repo.Update(id, first.Version, func(basket *model.Basket) error {
+		basket.SetQuantity("a", 5)
+		return nil
+	})
+	if !errors.Is(err, model.ErrVersionMismatch) {
+		return fmt.Errorf("update of stale version returned %v, want ErrVersionMismatch", err)
+	}
+
+	abort := errors.New("abort")
+	_, err = repo.Update(id, 0, func(basket *model.Basket) error {
+		basket.SetQuantity("a", 5)
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		return fmt.Errorf("aborted update returned %v, want the error of fn", err)
+	}
+	if basket, err = repo.GetByUserID(id); err != nil {
+		return err
+	}
+	if basket.Version != updated.Version {
+		return fmt.Errorf("basket at version %d after aborted update, want %d", basket.Version, updated.Version)
+	}
+	if err := expectBasket(repo, id, map[string]int32{"a": 3}, 3); err != nil {
+		return err
+	}
+
+	// A basket stored again after it was merged away does not repeat its
+	// versions
+	if _, err := repo.Merge(id, id+"-to", model.MergeSum, nil); err != nil {
+		return err
+	}
+	again, err := repo.Update(id, 0, func(basket *model.Basket) error {
+		basket.AddItem(item("a", 1, 1))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if again.Version <= updated.Version {
+		return fmt.Errorf("basket at version %d after merging it away, want more than %d", again.Version, updated.Version)
+	}
+	return nil
+}
+
+func checkCurrencies(repo model.BasketRepository, id string) error {
+	// Minor units add up exactly where 0.1 + 0.2 would not
+	if err := addItems(repo, id, item("a", 0.1, 3), item("b", 0.2, 1)); err != nil {
+		return err
+	}
+	if err := expectBasket(repo, id, map[string]int32{"a": 3, "b": 1}, 0.5); err != nil {
+		return err
+	}
+
+	euros := item("c", 1, 1)
+	euros.Price = money.New(100, "EUR")
+	if err := repo.AddItem(id, euros); !errors.Is(err, money.ErrCurrencyMismatch) {
+		return fmt.Errorf("adding a line in another currency returned %v, want ErrCurrencyMismatch", err)
+	}
+	return expectBasket(repo, id, map[string]int32{"a": 3, "b": 1}, 0.5)
+}
+
+// item returns a basket line priced in major units of the default currency.
+func checkHistory(repo model.BasketRepository, id string) error {
+	history, ok := repo.(model.BasketHistory)
+	if !ok {
+		return nil
+	}
+	guest := id + "-guest"
+	if err := addItems(repo, guest, item("b", 2, 1)); err != nil {
+		return err
+	}
+	if err := addItems(repo, id, item("a", 1, 1)); err != nil {
+		return err
+	}
+	if err := repo.UpdateQuantity(id, "a", 3); err != nil {
+		return err
+	}
+	if err := repo.AddCoupon(id, "WELCOME"); err != nil {
+		return err
+	}
+	if _, err := repo.Merge(guest, id, model.MergeSum, nil); err != nil {
+		return err
+	}
+
+	// A change that fails records nothing
+	before, err := history.Get(id, time.Time{})
+	if err != nil {
+		return err
+	}
+	errRejected := errors.New("rejected")
+	_, err = repo.Update(id, 0, func(basket *model.Basket) error {
+		basket.Clear()
+		return errRejected
+	})
+	if !errors.Is(err, errRejected) {
+		return fmt.Errorf("rejected update returned %v, want %v", err, errRejected)
+	}
+	events, err := history.Get(id, time.Time{})
+	if err != nil {
+		return err
+	}
+	if len(events) != len(before) {
+		return fmt.Errorf("rejected update recorded %d events", len(events)-len(before))
+	}
+
+	// Replaying the history rebuilds the stored basket
+	stored, err := repo.GetByUserID(id)
+	if err != nil {
+		return err
+	}
+	replayed, _ := model.ReplayBasket(id, events, time.Time{})
+	if err := expectItems(replayed.Items, map[string]int32{"a": 3, "b": 1}); err != nil {
+		return fmt.Errorf("replayed basket: %w", err)
+	}
+	if replayed.Version != stored.Version || len(replayed.CouponCodes) != 1 {
+		return fmt.Errorf("replayed basket at version %d with coupons %v, want %d with [WELCOME]", replayed.Version, replayed.CouponCodes, stored.Version)
+	}
+
+	// The merged guest basket was deleted, and the basket expires after its
+	// TTL unless it is changed
+	guestEvents, err := history.Get(guest, time.Time{})
+	if err != nil {
+		return err
+	}
+	if len(guestEvents) == 0 || guestEvents[len(guestEvents)-1].Type != model.EventBasketDeleted {
+		return fmt.Errorf("guest history %v does not end with %s", eventTypes(guestEvents), model.EventBasketDeleted)
+	}
+	expired, replayedEvents := model.ReplayBasket(id, events, events[len(events)-1].ExpiresAt)
+	if len(expired.Items) != 0 || replayedEvents[len(replayedEvents)-1].Type != model.EventBasketExpired {
+		return fmt.Errorf("history replayed past the expiry has %d lines and ends with %v", len(expired.Items), eventTypes(replayedEvents))
+	}
+	return nil
+}
+
+func eventTypes(events []model.BasketEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func item(productID string, price float64, quantity int32) model.BasketItem {
+	return model.BasketItem{
+		ProductID:       productID,
+		ProductName:     "Product " + productID,
+		Price:           usd(price),
+		Quantity:        quantity,
+		PriceSnapshotAt: time.Now(),
+	}
+}
+
+func addItems(repo model.BasketRepository, userID string, items ...model.BasketItem) error {
+	for _, item := range items {
+		if err := repo.AddItem(userID, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectBasket compares the stored basket with the expected quantities per
+// product and total.
+func expectBasket(repo model.BasketRepository, userID string, quantities map[string]int32, total float64) error {
+	basket, err := repo.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if err := expectItems(basket.Items, quantities); err != nil {
+		return err
+	}
+	if c, err := basket.TotalAmount.Cmp(usd(total)); err != nil || c != 0 {
+		return fmt.Errorf("total %s, want %s", basket.TotalAmount, usd(total))
+	}
+	return nil
+}
+
+func usd(major float64) money.Money {
+	return money.FromMajor(major, money.DefaultCurrency)
+}
+
+func expectList(lists []*model.List, listID string, quantities map[string]int32) error {
+	for _, list := range lists {
+		if list.ID == listID {
+			if err := expectItems(list.Items, quantities); err != nil {
+				return fmt.Errorf("list %s: %w", listID, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("list %s not found", listID)
+}
+
+func expectItems(items []model.BasketItem, quantities map[string]int32) error {
+	if len(items) != len(quantities) {
+		return fmt.Errorf("%d lines, want %d", len(items), len(quantities))
+	}
+	for _, item := range items {
+		want, ok := quantities[item.ProductID]
+		if !ok {
+			return fmt.Errorf("unexpected line %s", item.ProductID)
+		}
+		if item.Quantity != want {
+			return fmt.Errorf("line %s has quantity %d, want %d", item.ProductID, item.Quantity, want)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package repositorytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewDaprSidecar starts a fake Dapr sidecar that implements the parts of the
+// state API used by the Dapr basket repository: bulk get and transactions
+// with ETags, first-write concurrency and TTLs. Every store name shares the
+// same state. Close the server when done.
+func NewDaprSidecar() *httptest.Server {
+	sidecar := &daprSidecar{entries: make(map[string]sidecarEntry)}
+	return httptest.NewServer(sidecar)
+}
+
+type daprSidecar struct {
+	mu      sync.Mutex
+	entries map[string]sidecarEntry
+	version int64
+}
+
+type sidecarEntry struct {
+	data      json.RawMessage
+	etag      string
+	expiresAt time.Time
+}
+
+type sidecarOperation struct {
+	Operation string `json:"operation"`
+	Request   struct {
+		Key      string            `json:"key"`
+		Value    json.RawMessage   `json:"value"`
+		ETag     string            `json:"etag"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"request"`
+}
+
+func (s *daprSidecar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1.0/state/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch parts[1] {
+	case "bulk":
+		s.bulkGet(w, r)
+	case "transaction":
+		s.transact(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *daprSidecar) bulkGet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type item struct {
+		Key  string          `json:"key"`
+		Data json.RawMessage `json:"data,omitempty"`
+		ETag string          `json:"etag,omitempty"`
+	}
+	items := make([]item, 0, len(req.Keys))
+	for _, key := range req.Keys {
+		if entry, ok := s.get(key); ok {
+			items = append(items, item{Key: key, Data: entry.data, ETag: entry.etag})
+		} else {
+			items = append(items, item{Key: key})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (s *daprSidecar) transact(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operations []sidecarOperation `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check every ETag before applying anything, so the transaction is atomic
+	for _, op := range req.Operations {
+		entry, exists := s.get(op.Request.Key)
+		if op.Request.ETag == "" && exists || op.Request.ETag != "" && (!exists || entry.etag != op.Request.ETag) {
+			http.Error(w, `{"errorCode":"ERR_STATE_TRANSACTION","message":"possible etag mismatch"}`, http.StatusConflict)
+			return
+		}
+	}
+
+	for _, op := range req.Operations {
+		switch op.Operation {
+		case "upsert":
+			s.version++
+			entry := sidecarEntry{data: op.Request.Value, etag: strconv.FormatInt(s.version, 10)}
+			if ttl, err := strconv.Atoi(op.Request.Metadata["ttlInSeconds"]); err == nil && ttl > 0 {
+				entry.expiresAt = time.Now().Add(time.Duration(ttl) * time.Second)
+			}
+			s.entries[op.Request.Key] = entry
+		case "delete":
+			delete(s.entries, op.Request.Key)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// get returns an entry unless it is missing or expired. s.mu must be held.
+func (s *daprSidecar) get(key string) (sidecarEntry, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return sidecarEntry{}, false
+	}
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(s.entries, key)
+		return sidecarEntry{}, false
+	}
+	return entry, true
+}
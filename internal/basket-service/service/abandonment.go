@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"daprps/api/proto/events"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+// AbandonmentConfig configures the abandoned basket scanner.
+type AbandonmentConfig struct {
+	// Threshold is how long a basket must be idle to count as abandoned. It
+	// must be shorter than the basket TTL so the event is published before
+	// the basket expires.
+	Threshold time.Duration
+	// TTL is the basket TTL; abandoned baskets idle for longer have expired.
+	TTL time.Duration
+	// Interval is the time between scans.
+	Interval time.Duration
+	// BatchSize bounds how many baskets are handled per scan.
+	BatchSize int64
+}
+
+// RunAbandonmentScanner periodically publishes BasketAbandoned events for
+// idle baskets and BasketRecovered events for abandoned baskets that were
+// used again. It blocks until ctx is cancelled. Several replicas may run it
+// at once; each basket is reported by one of them.
+func (s *BasketService) RunAbandonmentScanner(ctx context.Context, config AbandonmentConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.scanRecovered(ctx, config)
+			s.scanAbandoned(ctx, config)
+		}
+	}
+}
+
+func (s *BasketService) scanAbandoned(ctx context.Context, config AbandonmentConfig) {
+	idleSince := time.Now().Add(-config.Threshold)
+
+	userIDs, err := s.repo.GetIdleBaskets(idleSince, config.BatchSize)
+	if err != nil {
+		log.Printf("Failed to find idle baskets: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		claimed, err := s.repo.MarkAbandoned(userID, idleSince)
+		if err != nil {
+			log.Printf("Failed to mark basket of user %s abandoned: %v", userID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		basket, err := s.repo.GetByUserID(userID)
+		if err != nil {
+			log.Printf("Failed to get abandoned basket of user %s: %v", userID, err)
+			s.unmarkAbandoned(userID)
+			continue
+		}
+
+		// Baskets that expired or were emptied in the meantime are not reported
+		if len(basket.Items) == 0 {
+			s.repo.ClearAbandoned(userID)
+			continue
+		}
+
+		if err := s.publisher.PublishBasketAbandoned(ctx, s.abandonedEvent(basket)); err != nil {
+			log.Printf("Failed to publish basket abandoned event for user %s: %v", userID, err)
+			s.unmarkAbandoned(userID)
+			continue
+		}
+		log.Printf("Basket of user %s abandoned after %s idle", userID, time.Since(basket.UpdatedAt).Round(time.Second))
+	}
+}
+
+func (s *BasketService) scanRecovered(ctx context.Context, config AbandonmentConfig) {
+	abandoned, err := s.repo.GetAbandonedBaskets(config.BatchSize)
+	if err != nil {
+		log.Printf("Failed to get abandoned baskets: %v", err)
+		return
+	}
+
+	for userID, lastActivity := range abandoned {
+		// The TTL is refreshed on every update, so this basket has expired
+		if time.Since(lastActivity) > config.TTL {
+			if claimed, err := s.repo.ClearAbandoned(userID); err == nil && claimed {
+				s.publishBasketCleared(ctx, userID, nil, ClearedByExpiry, lastActivity.Add(config.TTL))
+			}
+			continue
+		}
+
+		basket, err := s.repo.GetByUserID(userID)
+		if err != nil {
+			log.Printf("Failed to get abandoned basket of user %s: %v", userID, err)
+			continue
+		}
+		if !basket.UpdatedAt.After(lastActivity) {
+			continue
+		}
+
+		claimed, err := s.repo.ClearAbandoned(userID)
+		if err != nil || !claimed {
+			continue
+		}
+
+		quote, _ := s.price(basket)
+		event := &events.BasketRecoveredEvent{
+			UserId:         userID,
+			LastActivityAt: lastActivity.Format(time.RFC3339),
+			RecoveredAt:    basket.UpdatedAt.Format(time.RFC3339),
+			ItemCount:      int32(len(basket.Items)),
+			TotalAmount:    money.ToProto(quote.Total),
+		}
+		if err := s.publisher.PublishBasketRecovered(ctx, event); err != nil {
+			log.Printf("Failed to publish basket recovered event for user %s: %v", userID, err)
+		}
+	}
+}
+
+func (s *BasketService) unmarkAbandoned(userID string) {
+	if err := s.repo.UnmarkAbandoned(userID); err != nil {
+		log.Printf("Failed to unmark abandoned basket of user %s: %v", userID, err)
+	}
+}
+
+func (s *BasketService) abandonedEvent(basket *model.Basket) *events.BasketAbandonedEvent {
+	quote, err := s.price(basket)
+	if err != nil {
+		log.Printf("Failed to price abandoned basket of user %s: %v", basket.UserID, err)
+	}
+
+	var items []*events.BasketItem
+	for _, item := range basket.Items {
+		items = append(items, eventItem(item))
+	}
+
+	return &events.BasketAbandonedEvent{
+		UserId:         basket.UserID,
+		Items:          items,
+		Subtotal:       money.ToProto(quote.Subtotal),
+		TotalAmount:    money.ToProto(quote.Total),
+		CouponCodes:    basket.CouponCodes,
+		LastActivityAt: basket.UpdatedAt.Format(time.RFC3339),
+		AbandonedAt:    time.Now().Format(time.RFC3339),
+	}
+}
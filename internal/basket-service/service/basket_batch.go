@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Operation types of BatchUpdateBasket
+const (
+	OperationAdd         = "add"
+	OperationRemove      = "remove"
+	OperationSetQuantity = "set_quantity"
+)
+
+// maxBatchOperations bounds the number of operations in one batch.
+const maxBatchOperations = 100
+
+// errBatchFailed aborts the basket update of a batch with a failed operation.
+var errBatchFailed = errors.New("batch operation failed")
+
+// BatchUpdateBasket applies a list of operations to a basket in one update,
+// looking up all products in a single catalog call. Either every operation is
+// applied or, if any of them fails, none is; the results report the outcome
+// of each operation either way. Adding a line and setting its quantity are
+// subject to the same stock checks and limits as AddItem and UpdateQuantity,
+// and set_quantity adds the line if the product is not in the basket yet.
+func (s *BasketService) BatchUpdateBasket(ctx context.Context, req *basketpb.BatchUpdateBasketRequest) (*basketpb.BatchUpdateBasketResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	if len(req.Operations) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "operations are required")
+	}
+	if len(req.Operations) > maxBatchOperations {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d operations are allowed in a batch", maxBatchOperations)
+	}
+
+	var productIDs []string
+	seen := make(map[string]bool)
+	for i, op := range req.Operations {
+		switch op.Type {
+		case OperationAdd, OperationRemove, OperationSetQuantity:
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "operation %d: unknown type %q", i, op.Type)
+		}
+		if op.ProductId == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "operation %d: product_id is required", i)
+		}
+		if op.Type != OperationRemove && !seen[op.ProductId] {
+			seen[op.ProductId] = true
+			productIDs = append(productIDs, op.ProductId)
+		}
+	}
+
+	products := map[string]*model.ProductInfo{}
+	if len(productIDs) > 0 {
+		var err error
+		products, err = s.products.GetProducts(ctx, productIDs)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "error looking up products: %v", err)
+		}
+	}
+
+	var results []*basketpb.BasketOperationResult
+	var before []model.BasketItem
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		before = append([]model.BasketItem(nil), stored.Items...)
+		results = s.applyOperations(stored, req.Operations, products, req.ClampToAvailable)
+		for _, result := range results {
+			if !result.Success {
+				return errBatchFailed
+			}
+		}
+		return s.limits.Check(stored)
+	})
+	if errors.Is(err, errBatchFailed) {
+		current, err := s.repo.GetByUserID(req.UserId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error getting basket: %v", err)
+		}
+		return &basketpb.BatchUpdateBasketResponse{
+			Basket:  s.convertBasket(ctx, current),
+			Results: results,
+		}, nil
+	}
+	if err != nil {
+		return nil, mutationError("error updating basket", err)
+	}
+
+	s.publishChanges(ctx, req.UserId, before, basket.Items)
+
+	return &basketpb.BatchUpdateBasketResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		Success: true,
+		Results: results,
+	}, nil
+}
+
+// applyOperations applies operations to a basket in order and returns the
+// result of each. Operations after a failed one are still tried so that all
+// failures are reported at once.
+func (s *BasketService) applyOperations(basket *model.Basket, operations []*basketpb.BasketOperation, products map[string]*model.ProductInfo, clamp bool) []*basketpb.BasketOperationResult {
+	now := time.Now()
+	results := make([]*basketpb.BasketOperationResult, len(operations))
+	for i, op := range operations {
+		result := &basketpb.BasketOperationResult{ProductId: op.ProductId}
+		results[i] = result
+		fail := func(reason, msg string) {
+			result.Reason, result.Error = reason, msg
+		}
+
+		// Quantities of zero or less remove the line, like UpdateQuantity
+		if op.Type == OperationRemove || op.Type == OperationSetQuantity && op.Quantity <= 0 {
+			basket.RemoveItem(op.ProductId)
+			result.Success = true
+			continue
+		}
+		if op.Type == OperationAdd && op.Quantity <= 0 {
+			fail("INVALID_QUANTITY", "quantity must be positive")
+			continue
+		}
+
+		product, ok := products[op.ProductId]
+		if !ok {
+			fail("PRODUCT_NOT_FOUND", fmt.Sprintf("product not found: %s", op.ProductId))
+			continue
+		}
+
+		existing, found := findBasketItem(basket, op.ProductId)
+		requested := op.Quantity
+		if op.Type == OperationAdd {
+			requested += existing.Quantity
+		}
+		allowed, clamped, err := checkStock(product, requested, clamp)
+		if err == nil && op.Type == OperationAdd && allowed <= existing.Quantity {
+			err = stockError(product.ID, requested, product.Available)
+		}
+		if err != nil {
+			fail("INSUFFICIENT_STOCK", status.Convert(err).Message())
+			continue
+		}
+		if err := s.limits.CheckLine(product, allowed); err != nil {
+			fail("BASKET_LIMIT_EXCEEDED", err.Error())
+			continue
+		}
+
+		if found {
+			basket.SetQuantity(op.ProductId, allowed)
+		} else {
+			basket.AddItem(model.BasketItem{
+				ProductID:       product.ID,
+				ProductName:     product.Name,
+				Price:           product.Price,
+				Category:        product.Category,
+				WeightGrams:     product.WeightGrams,
+				Quantity:        allowed,
+				PriceSnapshotAt: now,
+			})
+		}
+		result.Success = true
+		result.Quantity = allowed
+		result.Clamped = clamped
+	}
+	return results
+}
+
+// publishChanges publishes one event per line that a change added, removed
+// or changed the quantity of.
+func (s *BasketService) publishChanges(ctx context.Context, userID string, before, after []model.BasketItem) {
+	old := &model.Basket{Items: before}
+	updated := &model.Basket{Items: after}
+	for _, item := range before {
+		if updated.FindItem(item.ProductID) < 0 {
+			s.publishItemRemoved(ctx, userID, item)
+		}
+	}
+	for _, item := range after {
+		i := old.FindItem(item.ProductID)
+		switch {
+		case i < 0:
+			s.publishItemAdded(ctx, userID, item, item.Quantity)
+		case before[i].Quantity != item.Quantity:
+			s.publishQuantityChanged(ctx, userID, item.ProductID, before[i].Quantity, item.Quantity)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// CheckoutLockTTL bounds how long a checkout can hold a basket. The
+	// payment service timeout must be shorter, which is checked at startup.
+	CheckoutLockTTL = 2 * time.Minute
+
+	// completeRetries is how often completing a paid checkout is retried
+	// before it is left to the payment event.
+	completeRetries = 3
+	completeBackoff = 100 * time.Millisecond
+)
+
+// Checkout charges the basket through the payment service. The basket is
+// locked against changes, re-priced against the product service and charged
+// the server-computed amount. It is emptied only if the payment succeeds.
+//
+// The order ID is stored with the lock and is the idempotency key of the
+// payment. If the outcome of the payment is unknown, the basket stays locked
+// until the payment event completes the checkout or the lock expires, and a
+// retried checkout charges the same order again.
+func (s *BasketService) Checkout(ctx context.Context, req *basketpb.CheckoutRequest) (*basketpb.CheckoutResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	if req.PaymentMethod == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "payment_method is required")
+	}
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+
+	lock, err := s.repo.LockBasket(req.UserId, generateOrderID(), CheckoutLockTTL)
+	if err != nil {
+		if errors.Is(err, model.ErrBasketLocked) {
+			return nil, status.Errorf(codes.Aborted, "checkout already in progress")
+		}
+		return nil, status.Errorf(codes.Internal, "error locking basket: %v", err)
+	}
+
+	resp, charged, err := s.checkout(ctx, req, currency, lock)
+	if err != nil {
+		if charged {
+			// The payment may have been taken; keep the lock and the order
+			return nil, err
+		}
+		// Leave the basket as it was for the user to retry
+		if unlockErr := s.repo.UnlockBasket(req.UserId, lock.Token); unlockErr != nil {
+			log.Printf("Failed to unlock basket of user %s: %v", req.UserId, unlockErr)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkout prices and charges a locked basket. charged reports whether a
+// failed checkout may have taken the payment.
+func (s *BasketService) checkout(ctx context.Context, req *basketpb.CheckoutRequest, currency string, lock *model.CheckoutLock) (resp *basketpb.CheckoutResponse, charged bool, err error) {
+	basket, err := s.repo.GetByUserID(req.UserId)
+	if err != nil {
+		return nil, false, status.Errorf(codes.Internal, "error getting basket: %v", err)
+	}
+	if len(basket.Items) == 0 {
+		return nil, false, status.Errorf(codes.FailedPrecondition, "basket is empty")
+	}
+	if currency != "" && currency != basket.Currency() {
+		return nil, false, status.Errorf(codes.FailedPrecondition, "%v: basket is priced in %s, not %s", money.ErrCurrencyMismatch, basket.Currency(), currency)
+	}
+
+	pricesChanged, err := s.reprice(ctx, basket)
+	if err != nil {
+		return nil, false, err
+	}
+
+	quote, err := s.price(basket)
+	if err != nil {
+		return nil, false, status.Errorf(codes.FailedPrecondition, "error pricing basket: %v", err)
+	}
+
+	// A retried checkout charges the pending order of the earlier attempt,
+	// unless the basket changed since: the payment service refuses another
+	// amount for an order, so the basket is charged as a new order. The
+	// earlier order stays recorded for its payment event.
+	order := &model.PendingOrder{
+		OrderID:     lock.OrderID,
+		UserID:      req.UserId,
+		Amount:      quote.Total,
+		Items:       basket.Items,
+		CouponCodes: basket.CouponCodes,
+		Redeemed:    discountCodes(quote),
+	}
+	earlier, err := s.repo.GetPendingOrder(order.OrderID)
+	if err != nil {
+		return nil, false, status.Errorf(codes.Internal, "error getting pending order: %v", err)
+	}
+	if earlier != nil && !earlier.SameCharge(order) {
+		order.OrderID = generateOrderID()
+		log.Printf("Basket of user %s changed since order %s was charged, charging order %s instead", req.UserId, earlier.OrderID, order.OrderID)
+	}
+	orderID := order.OrderID
+
+	// Coupon uses are held before the charge, so one that ran out since it
+	// was applied fails the checkout instead of being used past its limit.
+	// They are counted once the order is paid.
+	if err := s.reserveCoupons(order.Redeemed, orderID); err != nil {
+		return nil, false, err
+	}
+
+	// The order is recorded before the charge, so that its payment event
+	// takes only the charged lines out of the basket
+	if err := s.repo.SetPendingOrder(order); err != nil {
+		s.releaseCoupons(order.Redeemed, orderID)
+		return nil, false, status.Errorf(codes.Internal, "error recording order: %v", err)
+	}
+
+	result, err := s.payments.ProcessPayment(ctx, &model.PaymentRequest{
+		OrderID:       orderID,
+		UserID:        req.UserId,
+		Amount:        quote.Total,
+		PaymentMethod: req.PaymentMethod,
+		CardNumber:    req.CardNumber,
+		CardHolder:    req.CardHolder,
+		ExpiryDate:    req.ExpiryDate,
+		CVV:           req.Cvv,
+		Items:         basket.Items,
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrPaymentDeclined) {
+			s.releaseCoupons(order.Redeemed, orderID)
+			return nil, false, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		if errors.Is(err, model.ErrPaymentNotTaken) {
+			s.releaseCoupons(order.Redeemed, orderID)
+			return nil, false, status.Errorf(status.Code(err), "%v", err)
+		}
+		log.Printf("Payment of order %s for user %s has an unknown outcome: %v", orderID, req.UserId, err)
+		return nil, true, status.Errorf(codes.Unavailable, "error processing payment, the checkout completes once the payment is confirmed: %v", err)
+	}
+
+	// The payment went through; failures from here on must not fail the checkout
+	s.redeemCoupons(order.Redeemed, orderID)
+	protoBasket := s.convertBasket(ctx, basket)
+	if err := s.completeCheckout(req.UserId, lock.Token, order); err != nil {
+		log.Printf("Failed to complete checkout of order %s for user %s, leaving it to the payment event: %v", orderID, req.UserId, err)
+	} else {
+		s.publishBasketCleared(ctx, req.UserId, basket.Items, ClearedByPayment, time.Now())
+	}
+
+	log.Printf("Checked out basket of user %s as order %s for %s", req.UserId, orderID, quote.Total)
+
+	return &basketpb.CheckoutResponse{
+		OrderId:       orderID,
+		PaymentId:     result.PaymentID,
+		Amount:        money.ToProto(quote.Total),
+		Basket:        protoBasket,
+		PricesChanged: pricesChanged,
+		Success:       true,
+	}, false, nil
+}
+
+// completeCheckout takes a paid order out of the basket, retrying failures.
+// If it still fails, the basket stays locked with the order pending and the
+// payment event completes it.
+func (s *BasketService) completeCheckout(userID, token string, order *model.PendingOrder) error {
+	var err error
+	backoff := completeBackoff
+	for attempt := 0; attempt < completeRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = s.repo.CompleteCheckout(userID, token, order); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// reprice updates every basket line to the current catalog name and checks
+// the stock. Changed prices are applied according to the price drift policy;
+// under PriceRequireAck the checkout is refused until they are acknowledged.
+// It reports whether any price changed.
+func (s *BasketService) reprice(ctx context.Context, basket *model.Basket) (bool, error) {
+	now := time.Now()
+	changed := false
+	var unacknowledged []priceChange
+	products, err := s.lookupProducts(ctx, basket.Items)
+	if err != nil {
+		return false, err
+	}
+	for i, item := range basket.Items {
+		product := products[item.ProductID]
+		if product == nil {
+			return false, status.Errorf(codes.FailedPrecondition, "product %s is no longer available", item.ProductID)
+		}
+
+		if _, _, err := checkStock(product, item.Quantity, false); err != nil {
+			return false, err
+		}
+
+		basket.Items[i].ProductName = product.Name
+		basket.Items[i].Category = product.Category
+		basket.Items[i].WeightGrams = product.WeightGrams
+
+		if product.Price == item.Price {
+			continue
+		}
+		changed = true
+		if s.pricePolicy.Mode == model.PriceRequireAck {
+			unacknowledged = append(unacknowledged, priceChange{productID: item.ProductID, previous: item.Price, current: product.Price})
+		} else if !s.pricePolicy.Honours(item, now) {
+			basket.Items[i].Price = product.Price
+		}
+	}
+	if len(unacknowledged) > 0 {
+		return false, priceChangeError(unacknowledged)
+	}
+
+	total, err := basket.Subtotal()
+	if err != nil {
+		return false, status.Errorf(codes.FailedPrecondition, "error pricing basket: %v", err)
+	}
+	basket.TotalAmount = total
+	return changed, nil
+}
+
+func generateOrderID() string {
+	return fmt.Sprintf("order_%d", time.Now().UnixNano())
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/pricing"
+	"daprps/internal/basket-service/promotion"
+	"daprps/internal/money"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *BasketService) ApplyCoupon(ctx context.Context, req *basketpb.ApplyCouponRequest) (*basketpb.ApplyCouponResponse, error) {
+	code := model.NormalizeCouponCode(req.Code)
+	if code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "coupon code is required")
+	}
+
+	coupon, err := s.coupons.Get(code)
+	if err != nil {
+		if errors.Is(err, model.ErrCouponNotFound) {
+			return nil, status.Errorf(codes.NotFound, "coupon not found: %s", code)
+		}
+		return nil, status.Errorf(codes.Internal, "error getting coupon: %v", err)
+	}
+	if err := s.checkCoupon(coupon, time.Now()); err != nil {
+		if errors.Is(err, model.ErrCouponNotActive) || errors.Is(err, model.ErrCouponUsageExceeded) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "error checking coupon: %v", err)
+	}
+
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		// Reject coupons that would not change the price of the basket as it is now
+		breakdown, err := promotion.Price(stored.Items, []*model.Coupon{coupon})
+		if err != nil {
+			return err
+		}
+		if len(breakdown.Discounts) == 0 {
+			return fmt.Errorf("%w: %s", model.ErrCouponNotApplicable, code)
+		}
+		stored.AddCoupon(code)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrCouponNotApplicable) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		return nil, mutationError("error applying coupon", err)
+	}
+
+	return &basketpb.ApplyCouponResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		Success: true,
+	}, nil
+}
+
+func (s *BasketService) RemoveCoupon(ctx context.Context, req *basketpb.RemoveCouponRequest) (*basketpb.RemoveCouponResponse, error) {
+	code := model.NormalizeCouponCode(req.Code)
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		stored.RemoveCoupon(code)
+		return nil
+	})
+	if err != nil {
+		return nil, mutationError("error removing coupon", err)
+	}
+
+	return &basketpb.RemoveCouponResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		Success: true,
+	}, nil
+}
+
+// CreateCoupon stores a coupon definition, replacing any coupon with the same code.
+func (s *BasketService) CreateCoupon(ctx context.Context, req *basketpb.CreateCouponRequest) (*basketpb.CreateCouponResponse, error) {
+	if req.Coupon == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "coupon is required")
+	}
+
+	coupon, err := couponFromProto(req.Coupon)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := promotion.Validate(coupon); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if err := s.coupons.Save(coupon); err != nil {
+		return nil, status.Errorf(codes.Internal, "error saving coupon: %v", err)
+	}
+
+	return &basketpb.CreateCouponResponse{
+		Coupon:  convertCoupon(coupon, 0),
+		Success: true,
+	}, nil
+}
+
+// checkCoupon verifies that a coupon can currently be used.
+func (s *BasketService) checkCoupon(coupon *model.Coupon, now time.Time) error {
+	if !coupon.ActiveAt(now) {
+		return model.ErrCouponNotActive
+	}
+	if coupon.UsageLimit > 0 {
+		redemptions, err := s.coupons.Redemptions(coupon.Code)
+		if err != nil {
+			return err
+		}
+		if redemptions >= coupon.UsageLimit {
+			return model.ErrCouponUsageExceeded
+		}
+	}
+	return nil
+}
+
+// activeCoupons loads the basket's coupons that can currently be used.
+// Coupons that expired or ran out since they were applied are skipped.
+func (s *BasketService) activeCoupons(basket *model.Basket) []*model.Coupon {
+	now := time.Now()
+
+	var coupons []*model.Coupon
+	for _, code := range basket.CouponCodes {
+		coupon, err := s.coupons.Get(code)
+		if err != nil {
+			log.Printf("Failed to load coupon %s for user %s: %v", code, basket.UserID, err)
+			continue
+		}
+		if err := s.checkCoupon(coupon, now); err != nil {
+			continue
+		}
+		coupons = append(coupons, coupon)
+	}
+	return coupons
+}
+
+// reserveCoupons holds a use of each coupon discounting a checkout before
+// the payment is taken, for as long as the order can stay pending. If one of
+// them has run out, the ones already held are released and the checkout
+// fails.
+func (s *BasketService) reserveCoupons(couponCodes []string, orderID string) error {
+	for i, code := range couponCodes {
+		coupon, err := s.coupons.Get(code)
+		if err == nil {
+			err = s.coupons.Reserve(code, orderID, coupon.UsageLimit, model.PendingOrderTTL)
+		}
+		if err != nil {
+			s.releaseCoupons(couponCodes[:i], orderID)
+			if errors.Is(err, model.ErrCouponUsageExceeded) || errors.Is(err, model.ErrCouponNotFound) {
+				return status.Errorf(codes.FailedPrecondition, "%v", err)
+			}
+			return status.Errorf(codes.Internal, "error reserving coupon: %v", err)
+		}
+	}
+	return nil
+}
+
+// releaseCoupons gives back the coupon uses held by an order that was not
+// paid.
+func (s *BasketService) releaseCoupons(couponCodes []string, orderID string) {
+	for _, code := range couponCodes {
+		if err := s.coupons.Release(code, orderID); err != nil {
+			log.Printf("Failed to release coupon %s for order %s: %v", code, orderID, err)
+		}
+	}
+}
+
+// redeemCoupons counts the coupons of a paid order, whether they were held
+// by a checkout or the order was paid outside Checkout.
+func (s *BasketService) redeemCoupons(couponCodes []string, orderID string) {
+	for _, code := range couponCodes {
+		if err := s.coupons.Redeem(code, orderID); err != nil {
+			log.Printf("Failed to redeem coupon %s for order %s: %v", code, orderID, err)
+		}
+	}
+}
+
+// discountCodes returns the codes of the coupons applied by a quote.
+func discountCodes(quote *pricing.Quote) []string {
+	var couponCodes []string
+	for _, discount := range quote.Discounts {
+		couponCodes = append(couponCodes, discount.Code)
+	}
+	return couponCodes
+}
+
+func couponFromProto(c *basketpb.Coupon) (*model.Coupon, error) {
+	coupon := &model.Coupon{
+		Code:        model.NormalizeCouponCode(c.Code),
+		Type:        c.Type,
+		Percent:     c.Percent,
+		Amount:      money.FromProto(c.Amount),
+		BuyQuantity: c.BuyQuantity,
+		GetQuantity: c.GetQuantity,
+		MinSubtotal: money.FromProto(c.MinSubtotal),
+		ProductIDs:  c.ProductIds,
+		Categories:  c.Categories,
+		UsageLimit:  c.UsageLimit,
+	}
+
+	var err error
+	if c.StartsAt != "" {
+		if coupon.StartsAt, err = time.Parse(time.RFC3339, c.StartsAt); err != nil {
+			return nil, errors.New("starts_at must be an RFC3339 timestamp")
+		}
+	}
+	if c.EndsAt != "" {
+		if coupon.EndsAt, err = time.Parse(time.RFC3339, c.EndsAt); err != nil {
+			return nil, errors.New("ends_at must be an RFC3339 timestamp")
+		}
+	}
+	return coupon, nil
+}
+
+func convertCoupon(coupon *model.Coupon, redemptions int64) *basketpb.Coupon {
+	protoCoupon := &basketpb.Coupon{
+		Code:        coupon.Code,
+		Type:        coupon.Type,
+		Percent:     coupon.Percent,
+		Amount:      money.ToProto(coupon.Amount),
+		BuyQuantity: coupon.BuyQuantity,
+		GetQuantity: coupon.GetQuantity,
+		MinSubtotal: money.ToProto(coupon.MinSubtotal),
+		ProductIds:  coupon.ProductIDs,
+		Categories:  coupon.Categories,
+		UsageLimit:  coupon.UsageLimit,
+		Redemptions: redemptions,
+	}
+	if !coupon.StartsAt.IsZero() {
+		protoCoupon.StartsAt = coupon.StartsAt.Format(time.RFC3339)
+	}
+	if !coupon.EndsAt.IsZero() {
+		protoCoupon.EndsAt = coupon.EndsAt.Format(time.RFC3339)
+	}
+	return protoCoupon
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"daprps/api/proto/events"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+)
+
+// Reasons reported in BasketClearedEvent
+const (
+	ClearedByUser    = "user"
+	ClearedByPayment = "payment"
+	ClearedByExpiry  = "expiry"
+)
+
+// Basket domain events are published after the change has been stored.
+// Publishing is best effort: failures are logged and never fail the request,
+// and nothing is published when Kafka is not available.
+
+func (s *BasketService) publishItemAdded(ctx context.Context, userID string, item model.BasketItem, newQuantity int32) {
+	if s.publisher == nil {
+		return
+	}
+	event := &events.BasketItemAddedEvent{
+		UserId:      userID,
+		Item:        eventItem(item),
+		NewQuantity: newQuantity,
+		AddedAt:     time.Now().Format(time.RFC3339),
+	}
+	if err := s.publisher.PublishItemAdded(ctx, event); err != nil {
+		log.Printf("Failed to publish item added event for user %s: %v", userID, err)
+	}
+}
+
+func (s *BasketService) publishItemRemoved(ctx context.Context, userID string, item model.BasketItem) {
+	if s.publisher == nil {
+		return
+	}
+	event := &events.BasketItemRemovedEvent{
+		UserId:    userID,
+		Item:      eventItem(item),
+		RemovedAt: time.Now().Format(time.RFC3339),
+	}
+	if err := s.publisher.PublishItemRemoved(ctx, event); err != nil {
+		log.Printf("Failed to publish item removed event for user %s: %v", userID, err)
+	}
+}
+
+func (s *BasketService) publishQuantityChanged(ctx context.Context, userID, productID string, oldQuantity, newQuantity int32) {
+	if s.publisher == nil {
+		return
+	}
+	event := &events.BasketQuantityChangedEvent{
+		UserId:      userID,
+		ProductId:   productID,
+		OldQuantity: oldQuantity,
+		NewQuantity: newQuantity,
+		ChangedAt:   time.Now().Format(time.RFC3339),
+	}
+	if err := s.publisher.PublishQuantityChanged(ctx, event); err != nil {
+		log.Printf("Failed to publish quantity changed event for user %s: %v", userID, err)
+	}
+}
+
+// publishBasketCleared reports the items a basket held when it was cleared.
+// Expired baskets are no longer readable, so their event carries no items.
+func (s *BasketService) publishBasketCleared(ctx context.Context, userID string, items []model.BasketItem, reason string, clearedAt time.Time) {
+	if s.publisher == nil {
+		return
+	}
+	event := &events.BasketClearedEvent{
+		UserId:    userID,
+		ClearedAt: clearedAt.Format(time.RFC3339),
+		Reason:    reason,
+	}
+	for _, item := range items {
+		event.Items = append(event.Items, eventItem(item))
+	}
+	if err := s.publisher.PublishBasketCleared(ctx, event); err != nil {
+		log.Printf("Failed to publish basket cleared event for user %s: %v", userID, err)
+	}
+}
+
+func eventItem(item model.BasketItem) *events.BasketItem {
+	return &events.BasketItem{
+		ProductId:   item.ProductID,
+		ProductName: item.ProductName,
+		Price:       money.ToProto(item.Price),
+		Quantity:    item.Quantity,
+	}
+}
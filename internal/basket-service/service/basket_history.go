@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetBasketHistory returns the changes of a user's basket up to as_of and the
+// basket as it was then, rebuilt from those changes. The rebuilt basket is
+// priced with the current coupons and pricing rules. A time before the
+// retained history is OutOfRange.
+func (s *BasketService) GetBasketHistory(ctx context.Context, req *basketpb.GetBasketHistoryRequest) (*basketpb.GetBasketHistoryResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	var asOf time.Time
+	if req.AsOf != "" {
+		var err error
+		asOf, err = time.Parse(time.RFC3339, req.AsOf)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid as_of: %v", err)
+		}
+	}
+
+	events, err := s.history.Get(req.UserId, asOf)
+	if errors.Is(err, model.ErrHistoryTrimmed) {
+		return nil, status.Errorf(codes.OutOfRange, "as_of is before the retained history of the basket")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting basket history: %v", err)
+	}
+
+	basket, events := model.ReplayBasket(req.UserId, events, asOf)
+	if total, err := basket.Subtotal(); err == nil {
+		basket.TotalAmount = total
+	}
+
+	// The products of the basket and of every snapshot are looked up at once
+	products := &model.Basket{UserID: req.UserId}
+	for _, b := range append([]*model.Basket{basket}, snapshots(events)...) {
+		for _, item := range b.Items {
+			if products.FindItem(item.ProductID) < 0 {
+				products.Items = append(products.Items, item)
+			}
+		}
+	}
+	unavailable := s.unavailableProducts(ctx, products)
+
+	protoEvents := make([]*basketpb.BasketHistoryEvent, 0, len(events))
+	for _, event := range events {
+		protoEvents = append(protoEvents, s.convertBasketEvent(event, unavailable))
+	}
+
+	return &basketpb.GetBasketHistoryResponse{
+		Events: protoEvents,
+		Basket: s.convertBasketWithUnavailable(basket, unavailable, nil),
+	}, nil
+}
+
+// snapshots returns the baskets recorded by snapshot events.
+func snapshots(events []model.BasketEvent) []*model.Basket {
+	var baskets []*model.Basket
+	for _, event := range events {
+		if event.Basket != nil {
+			baskets = append(baskets, event.Basket)
+		}
+	}
+	return baskets
+}
+
+func (s *BasketService) convertBasketEvent(event model.BasketEvent, unavailable map[string]bool) *basketpb.BasketHistoryEvent {
+	protoEvent := &basketpb.BasketHistoryEvent{
+		Id:          event.ID,
+		Type:        event.Type,
+		At:          event.At.Format(time.RFC3339),
+		Version:     event.Version,
+		ProductId:   event.ProductID,
+		Quantity:    event.Quantity,
+		CouponCode:  event.CouponCode,
+		Destination: convertAddress(event.Destination),
+	}
+	if event.Item != nil {
+		protoEvent.Item = convertBasketItems([]model.BasketItem{*event.Item}, nil, nil)[0]
+	}
+	if !event.ExpiresAt.IsZero() {
+		protoEvent.ExpiresAt = event.ExpiresAt.Format(time.RFC3339)
+	}
+	if event.Basket != nil {
+		protoEvent.Basket = s.convertBasketWithUnavailable(event.Basket, unavailable, nil)
+	}
+	return protoEvent
+}
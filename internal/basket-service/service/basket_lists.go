@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *BasketService) GetLists(ctx context.Context, req *basketpb.GetListsRequest) (*basketpb.GetListsResponse, error) {
+	lists, err := s.repo.GetLists(req.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting lists: %v", err)
+	}
+
+	var protoLists []*basketpb.SavedList
+	for _, list := range lists {
+		protoLists = append(protoLists, s.convertList(ctx, list))
+	}
+
+	return &basketpb.GetListsResponse{
+		Lists: protoLists,
+	}, nil
+}
+
+func (s *BasketService) CreateList(ctx context.Context, req *basketpb.CreateListRequest) (*basketpb.CreateListResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "list name is required")
+	}
+
+	list, err := s.repo.CreateList(req.UserId, name)
+	if err != nil {
+		return nil, listError("error creating list", err)
+	}
+
+	return &basketpb.CreateListResponse{
+		List:    s.convertList(ctx, list),
+		Success: true,
+	}, nil
+}
+
+func (s *BasketService) DeleteList(ctx context.Context, req *basketpb.DeleteListRequest) (*basketpb.DeleteListResponse, error) {
+	if err := s.repo.DeleteList(req.UserId, req.ListId); err != nil {
+		return nil, listError("error deleting list", err)
+	}
+
+	return &basketpb.DeleteListResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *BasketService) AddToList(ctx context.Context, req *basketpb.AddToListRequest) (*basketpb.AddToListResponse, error) {
+	quantity := req.Quantity
+	if quantity <= 0 {
+		quantity = 1
+	}
+
+	product, err := s.lookupProduct(ctx, req.ProductId)
+	if err != nil {
+		return nil, err
+	}
+
+	item := model.BasketItem{
+		ProductID:       product.ID,
+		ProductName:     product.Name,
+		Price:           product.Price,
+		Category:        product.Category,
+		WeightGrams:     product.WeightGrams,
+		Quantity:        quantity,
+		PriceSnapshotAt: time.Now(),
+	}
+	if err := s.repo.AddToList(req.UserId, req.ListId, item); err != nil {
+		return nil, listError("error adding to list", err)
+	}
+
+	list, err := s.getList(req.UserId, req.ListId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &basketpb.AddToListResponse{
+		List:    s.convertList(ctx, list),
+		Success: true,
+	}, nil
+}
+
+func (s *BasketService) RemoveFromList(ctx context.Context, req *basketpb.RemoveFromListRequest) (*basketpb.RemoveFromListResponse, error) {
+	if err := s.repo.RemoveFromList(req.UserId, req.ListId, req.ProductId); err != nil {
+		return nil, listError("error removing from list", err)
+	}
+
+	list, err := s.getList(req.UserId, req.ListId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &basketpb.RemoveFromListResponse{
+		List:    s.convertList(ctx, list),
+		Success: true,
+	}, nil
+}
+
+// MoveToList moves a basket line, with its saved price, into a list.
+func (s *BasketService) MoveToList(ctx context.Context, req *basketpb.MoveToListRequest) (*basketpb.MoveToListResponse, error) {
+	current, err := s.repo.GetByUserID(req.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting basket: %v", err)
+	}
+
+	if err := s.repo.MoveToList(req.UserId, req.ListId, req.ProductId); err != nil {
+		return nil, listError("error moving item to list", err)
+	}
+
+	if moved, ok := findBasketItem(current, req.ProductId); ok {
+		s.publishItemRemoved(ctx, req.UserId, moved)
+	}
+
+	basket, list, err := s.getBasketAndList(req.UserId, req.ListId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &basketpb.MoveToListResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		List:    s.convertList(ctx, list),
+		Success: true,
+	}, nil
+}
+
+// MoveToBasket moves a list item into the basket at the current catalog price,
+// subject to the same stock check as AddItem.
+func (s *BasketService) MoveToBasket(ctx context.Context, req *basketpb.MoveToBasketRequest) (*basketpb.MoveToBasketResponse, error) {
+	product, err := s.lookupProduct(ctx, req.ProductId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The quantity comes from the list inside the transaction
+	item := model.BasketItem{
+		ProductID:       product.ID,
+		ProductName:     product.Name,
+		Price:           product.Price,
+		Category:        product.Category,
+		WeightGrams:     product.WeightGrams,
+		PriceSnapshotAt: time.Now(),
+	}
+
+	// The stock and the limits are checked against the stored basket with
+	// the line moved in, inside the transaction
+	var newQuantity int32
+	var stockErr error
+	err = s.repo.MoveToBasket(req.UserId, req.ListId, item, func(stored *model.Basket, moved model.BasketItem) error {
+		line, _ := findBasketItem(stored, item.ProductID)
+		item.Quantity = moved.Quantity
+		newQuantity = line.Quantity
+		_, _, stockErr = checkStock(product, line.Quantity, false)
+		if stockErr != nil {
+			return stockErr
+		}
+		return s.checkLimits(stored, product)
+	})
+	if stockErr != nil {
+		return nil, stockErr
+	}
+	if err != nil {
+		return nil, listError("error moving item to basket", err)
+	}
+
+	basket, list, err := s.getBasketAndList(req.UserId, req.ListId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishItemAdded(ctx, req.UserId, item, newQuantity)
+
+	return &basketpb.MoveToBasketResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		List:    s.convertList(ctx, list),
+		Success: true,
+	}, nil
+}
+
+func (s *BasketService) getList(userID, listID string) (*model.List, error) {
+	if listID == "" {
+		listID = model.DefaultListID
+	}
+
+	lists, err := s.repo.GetLists(userID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting lists: %v", err)
+	}
+	for _, list := range lists {
+		if list.ID == listID {
+			return list, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "%v: %s", model.ErrListNotFound, listID)
+}
+
+func (s *BasketService) getBasketAndList(userID, listID string) (*model.Basket, *model.List, error) {
+	basket, err := s.repo.GetByUserID(userID)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "error getting updated basket: %v", err)
+	}
+	list, err := s.getList(userID, listID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return basket, list, nil
+}
+
+// convertList builds the proto list, comparing every saved price with the
+// current catalog price.
+func (s *BasketService) convertList(ctx context.Context, list *model.List) *basketpb.SavedList {
+	protoList := &basketpb.SavedList{
+		Id:        list.ID,
+		Name:      list.Name,
+		CreatedAt: list.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: list.UpdatedAt.Format(time.RFC3339),
+	}
+
+	// Without the catalog the saved prices are shown as current
+	products, err := s.lookupProducts(ctx, list.Items)
+	if err != nil {
+		log.Printf("Failed to get current prices of list %s: %v", list.ID, err)
+	}
+
+	for _, item := range list.Items {
+		protoItem := &basketpb.ListItem{
+			ProductId:    item.ProductID,
+			ProductName:  item.ProductName,
+			Quantity:     item.Quantity,
+			SavedPrice:   money.ToProto(item.Price),
+			CurrentPrice: money.ToProto(item.Price),
+		}
+		if !item.PriceSnapshotAt.IsZero() {
+			protoItem.SavedAt = item.PriceSnapshotAt.Format(time.RFC3339)
+		}
+
+		product, found := products[item.ProductID]
+		switch {
+		case products == nil:
+		case !found:
+			protoItem.Unavailable = true
+		default:
+			protoItem.CurrentPrice = money.ToProto(product.Price)
+			c, err := product.Price.Cmp(item.Price)
+			protoItem.PriceDropped = err == nil && c < 0
+		}
+
+		if protoItem.PriceDropped {
+			protoList.HasPriceDrops = true
+		}
+		protoList.Items = append(protoList.Items, protoItem)
+	}
+	return protoList
+}
+
+// listError maps repository errors from list operations to gRPC status errors.
+func listError(msg string, err error) error {
+	switch {
+	case errors.Is(err, model.ErrListNotFound), errors.Is(err, model.ErrItemNotFound):
+		return status.Errorf(codes.NotFound, "%s: %v", msg, err)
+	case errors.Is(err, model.ErrDefaultList):
+		return status.Errorf(codes.FailedPrecondition, "%s: %v", msg, err)
+	}
+	return mutationError(msg, err)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/pricing"
+	"daprps/internal/money"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetDestination sets the address a basket ships to, which determines its
+// shipping and tax lines.
+func (s *BasketService) SetDestination(ctx context.Context, req *basketpb.SetDestinationRequest) (*basketpb.SetDestinationResponse, error) {
+	if req.Destination == nil || strings.TrimSpace(req.Destination.Country) == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "destination country is required")
+	}
+
+	destination := &model.Address{
+		Country:    strings.ToUpper(strings.TrimSpace(req.Destination.Country)),
+		Region:     strings.ToUpper(strings.TrimSpace(req.Destination.Region)),
+		PostalCode: strings.TrimSpace(req.Destination.PostalCode),
+		City:       strings.TrimSpace(req.Destination.City),
+	}
+
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		// Refuse destinations the basket cannot be shipped to
+		stored.Destination = destination
+		_, err := s.price(stored)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrNoShippingRate) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		return nil, mutationError("error setting destination", err)
+	}
+
+	return &basketpb.SetDestinationResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		Success: true,
+	}, nil
+}
+
+// price runs the basket through the pricing pipeline. The quote is never nil;
+// on error it holds the discounted goods total without shipping and taxes.
+func (s *BasketService) price(basket *model.Basket) (*pricing.Quote, error) {
+	return s.pricing.Quote(basket, s.activeCoupons(basket))
+}
+
+func convertAddress(address *model.Address) *basketpb.Address {
+	if address == nil {
+		return nil
+	}
+	return &basketpb.Address{
+		Country:    address.Country,
+		Region:     address.Region,
+		PostalCode: address.PostalCode,
+		City:       address.City,
+	}
+}
+
+func convertShipping(shipping *model.ShippingLine) *basketpb.ShippingLine {
+	if shipping == nil {
+		return nil
+	}
+	return &basketpb.ShippingLine{
+		Name:   shipping.Name,
+		Amount: money.ToProto(shipping.Amount),
+	}
+}
+
+func convertTaxes(taxes []model.TaxLine) []*basketpb.TaxLine {
+	var protoTaxes []*basketpb.TaxLine
+	for _, tax := range taxes {
+		protoTaxes = append(protoTaxes, &basketpb.TaxLine{
+			Name:   tax.Name,
+			Rate:   tax.Rate,
+			Amount: money.ToProto(tax.Amount),
+		})
+	}
+	return protoTaxes
+}
@@ -2,25 +2,49 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	basketpb "daprps/api/proto/basket"
 	"daprps/api/proto/events"
 	"daprps/internal/basket-service/model"
+	"daprps/internal/basket-service/pricing"
+	"daprps/internal/money"
+	"daprps/kafka/publisher"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type BasketService struct {
 	basketpb.UnimplementedBasketServiceServer
-	repo model.BasketRepository
+	repo        model.BasketRepository
+	products    model.ProductCatalog
+	coupons     model.CouponRepository
+	pricing     *pricing.Pipeline
+	publisher   *publisher.BasketPublisher
+	payments    model.PaymentGateway
+	pricePolicy model.PriceDriftPolicy
+	limits      model.BasketLimits
+	history     model.BasketHistory
+	watcher     model.BasketWatcher
 }
 
-func NewBasketService(repo model.BasketRepository) *BasketService {
+func NewBasketService(repo model.BasketRepository, products model.ProductCatalog, coupons model.CouponRepository, pricing *pricing.Pipeline, publisher *publisher.BasketPublisher, payments model.PaymentGateway, pricePolicy model.PriceDriftPolicy, limits model.BasketLimits, history model.BasketHistory, watcher model.BasketWatcher) *BasketService {
 	return &BasketService{
-		repo: repo,
+		repo:        repo,
+		products:    products,
+		coupons:     coupons,
+		pricing:     pricing,
+		publisher:   publisher,
+		payments:    payments,
+		pricePolicy: pricePolicy,
+		limits:      limits,
+		history:     history,
+		watcher:     watcher,
 	}
 }
 
@@ -30,105 +54,245 @@ func (s *BasketService) GetBasket(ctx context.Context, req *basketpb.GetBasketRe
 		return nil, status.Errorf(codes.Internal, "error getting basket: %v", err)
 	}
 
+	if req.RevalidatePrices {
+		changes, err := s.revalidatePrices(ctx, basket)
+		if err != nil {
+			return nil, err
+		}
+		return &basketpb.GetBasketResponse{
+			Basket: s.convertBasketWithChanges(ctx, basket, changes),
+		}, nil
+	}
+
 	return &basketpb.GetBasketResponse{
-		Basket: &basketpb.Basket{
-			UserId:      basket.UserID,
-			Items:       convertBasketItems(basket.Items),
-			TotalAmount: basket.TotalAmount,
-			CreatedAt:   basket.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   basket.UpdatedAt.Format(time.RFC3339),
-		},
+		Basket: s.convertBasket(ctx, basket),
 	}, nil
 }
 
 func (s *BasketService) AddItem(ctx context.Context, req *basketpb.AddItemRequest) (*basketpb.AddItemResponse, error) {
-	// Note: In a real application, you would get product details from Product service
-	// For now, we'll create a placeholder item
-	item := model.BasketItem{
-		ProductID:   req.ProductId,
-		ProductName: "Product " + req.ProductId, // Placeholder
-		Price:       0.0,                        // Will be updated from Product service
-		Quantity:    req.Quantity,
+	if req.Quantity <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "quantity must be positive")
 	}
 
-	err := s.repo.AddItem(req.UserId, item)
+	// Snapshot the current catalog name and price on the basket line
+	product, err := s.lookupProduct(ctx, req.ProductId)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error adding item: %v", err)
+		return nil, err
 	}
 
-	// Get updated basket
-	basket, err := s.repo.GetByUserID(req.UserId)
+	item := model.BasketItem{
+		ProductID:       product.ID,
+		ProductName:     product.Name,
+		Price:           product.Price,
+		Category:        product.Category,
+		WeightGrams:     product.WeightGrams,
+		PriceSnapshotAt: time.Now(),
+	}
+
+	// The stock check covers what is already in the basket, so it runs on
+	// the stored basket within the update
+	var clamped bool
+	var stockErr error
+	var newQuantity int32
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		existing, _ := findBasketItem(stored, product.ID)
+		var allowed int32
+		allowed, clamped, stockErr = checkStock(product, existing.Quantity+req.Quantity, req.ClampToAvailable)
+		if stockErr == nil && allowed <= existing.Quantity {
+			stockErr = stockError(product.ID, existing.Quantity+req.Quantity, product.Available)
+		}
+		if stockErr != nil {
+			return stockErr
+		}
+
+		item.Quantity = allowed - existing.Quantity
+		newQuantity = allowed
+		stored.AddItem(item)
+		return s.checkLimits(stored, product)
+	})
+	if stockErr != nil {
+		return nil, stockErr
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error getting updated basket: %v", err)
+		return nil, mutationError("error adding item", err)
 	}
 
+	s.publishItemAdded(ctx, req.UserId, item, newQuantity)
+
 	return &basketpb.AddItemResponse{
-		Basket: &basketpb.Basket{
-			UserId:      basket.UserID,
-			Items:       convertBasketItems(basket.Items),
-			TotalAmount: basket.TotalAmount,
-			CreatedAt:   basket.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   basket.UpdatedAt.Format(time.RFC3339),
-		},
+		Basket:  s.convertBasket(ctx, basket),
 		Success: true,
+		Clamped: clamped,
 	}, nil
 }
 
 func (s *BasketService) RemoveItem(ctx context.Context, req *basketpb.RemoveItemRequest) (*basketpb.RemoveItemResponse, error) {
-	err := s.repo.RemoveItem(req.UserId, req.ProductId)
+	// Keep the line so the removed item can be reported
+	var removed model.BasketItem
+	var found bool
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		removed, found = findBasketItem(stored, req.ProductId)
+		stored.RemoveItem(req.ProductId)
+		return nil
+	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error removing item: %v", err)
+		return nil, mutationError("error removing item", err)
 	}
 
-	// Get updated basket
-	basket, err := s.repo.GetByUserID(req.UserId)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error getting updated basket: %v", err)
+	if found {
+		s.publishItemRemoved(ctx, req.UserId, removed)
 	}
 
 	return &basketpb.RemoveItemResponse{
-		Basket: &basketpb.Basket{
-			UserId:      basket.UserID,
-			Items:       convertBasketItems(basket.Items),
-			TotalAmount: basket.TotalAmount,
-			CreatedAt:   basket.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   basket.UpdatedAt.Format(time.RFC3339),
-		},
+		Basket:  s.convertBasket(ctx, basket),
 		Success: true,
 	}, nil
 }
 
 func (s *BasketService) UpdateQuantity(ctx context.Context, req *basketpb.UpdateQuantityRequest) (*basketpb.UpdateQuantityResponse, error) {
-	err := s.repo.UpdateQuantity(req.UserId, req.ProductId, req.Quantity)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error updating quantity: %v", err)
+	quantity := req.Quantity
+	clamped := false
+
+	// Quantities of zero or less remove the line and need no stock check
+	var product *model.ProductInfo
+	if quantity > 0 {
+		var err error
+		product, err = s.lookupProduct(ctx, req.ProductId)
+		if err != nil {
+			return nil, err
+		}
+		quantity, clamped, err = checkStock(product, quantity, req.ClampToAvailable)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Get updated basket
-	basket, err := s.repo.GetByUserID(req.UserId)
+	var previous model.BasketItem
+	var found, added bool
+	var item model.BasketItem
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		previous, found = findBasketItem(stored, req.ProductId)
+		added = false
+		// With add_missing, setting the quantity of a product that is not in
+		// the basket adds it, in the same update as the lookup
+		if !found && req.AddMissing && product != nil {
+			item = model.BasketItem{
+				ProductID:       product.ID,
+				ProductName:     product.Name,
+				Price:           product.Price,
+				Category:        product.Category,
+				WeightGrams:     product.WeightGrams,
+				Quantity:        quantity,
+				PriceSnapshotAt: time.Now(),
+			}
+			stored.AddItem(item)
+			added = true
+			return s.checkLimits(stored, product)
+		}
+		stored.SetQuantity(req.ProductId, quantity)
+		// Lowering a quantity is always allowed, even over the limits
+		if !found || quantity <= previous.Quantity {
+			return nil
+		}
+		return s.checkLimits(stored, product)
+	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error getting updated basket: %v", err)
+		return nil, mutationError("error updating quantity", err)
+	}
+
+	if added {
+		s.publishItemAdded(ctx, req.UserId, item, quantity)
+	}
+	if found {
+		if quantity <= 0 {
+			s.publishItemRemoved(ctx, req.UserId, previous)
+		} else if quantity != previous.Quantity {
+			s.publishQuantityChanged(ctx, req.UserId, req.ProductId, previous.Quantity, quantity)
+		}
 	}
 
 	return &basketpb.UpdateQuantityResponse{
-		Basket: &basketpb.Basket{
-			UserId:      basket.UserID,
-			Items:       convertBasketItems(basket.Items),
-			TotalAmount: basket.TotalAmount,
-			CreatedAt:   basket.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   basket.UpdatedAt.Format(time.RFC3339),
-		},
+		Basket:  s.convertBasket(ctx, basket),
 		Success: true,
+		Clamped: clamped,
+		Added:   added,
 	}, nil
 }
 
 func (s *BasketService) ClearBasket(ctx context.Context, req *basketpb.ClearBasketRequest) (*basketpb.ClearBasketResponse, error) {
-	err := s.repo.Clear(req.UserId)
+	var cleared []model.BasketItem
+	basket, err := s.repo.Update(req.UserId, req.ExpectedVersion, func(stored *model.Basket) error {
+		cleared = stored.Items
+		stored.Clear()
+		return nil
+	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error clearing basket: %v", err)
+		return nil, mutationError("error clearing basket", err)
+	}
+
+	if len(cleared) > 0 {
+		s.publishBasketCleared(ctx, req.UserId, cleared, ClearedByUser, time.Now())
 	}
 
 	return &basketpb.ClearBasketResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		Success: true,
+	}, nil
+}
+
+// MergeBaskets moves a guest basket into a user's basket, typically on login.
+func (s *BasketService) MergeBaskets(ctx context.Context, req *basketpb.MergeBasketsRequest) (*basketpb.MergeBasketsResponse, error) {
+	if req.FromUserId == "" || req.ToUserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "from_user_id and to_user_id are required")
+	}
+	if req.FromUserId == req.ToUserId {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot merge a basket into itself")
+	}
+	if !model.IsGuestBasket(req.FromUserId) {
+		return nil, status.Errorf(codes.InvalidArgument, "only guest baskets can be merged")
+	}
+
+	policy := req.Policy
+	if policy == "" {
+		policy = model.MergeSum
+	}
+
+	// Per-order limits are set in the catalog, so the guest's products are
+	// looked up first; the merged basket is checked within the merge
+	guest, err := s.repo.GetByUserID(req.FromUserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting guest basket: %v", err)
+	}
+	products, err := s.lookupProducts(ctx, guest.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var before []model.BasketItem
+	basket, err := s.repo.Merge(req.FromUserId, req.ToUserId, policy, func(previous []model.BasketItem, merged *model.Basket) error {
+		before = previous
+		for _, item := range merged.Items {
+			product, ok := products[item.ProductID]
+			if !ok {
+				product = &model.ProductInfo{ID: item.ProductID}
+			}
+			if err := s.limits.CheckLine(product, item.Quantity); err != nil {
+				return err
+			}
+		}
+		return s.limits.Check(merged)
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidMergePolicy) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return nil, mutationError("error merging baskets", err)
+	}
+
+	s.publishChanges(ctx, req.ToUserId, before, basket.Items)
+
+	return &basketpb.MergeBasketsResponse{
+		Basket:  s.convertBasket(ctx, basket),
 		Success: true,
 	}, nil
 }
@@ -146,27 +310,235 @@ func (s *BasketService) ClearBasketByUserID(ctx context.Context, userID string)
 func (s *BasketService) HandlePaymentCompleted(ctx context.Context, event *events.PaymentCompletedEvent) error {
 	log.Printf("Received payment completed event for order %s, user %s", event.OrderId, event.UserId)
 
-	// Clear the user's basket after successful payment
-	err := s.repo.Clear(event.UserId)
+	// Baskets checked out through Checkout have already been emptied
+	checkedOut, err := s.repo.IsCheckoutOrder(event.OrderId)
+	if err != nil {
+		log.Printf("Failed to check order %s: %v", event.OrderId, err)
+		return err
+	}
+	if checkedOut {
+		return nil
+	}
+
+	// A checkout whose payment outcome was unknown, or that could not be
+	// completed, is completed by its payment event, also after a later
+	// checkout replaced its order. Only the charged lines are taken out of
+	// the basket, and the coupon uses held by the order are counted.
+	order, err := s.repo.GetPendingOrder(event.OrderId)
+	if err != nil {
+		log.Printf("Failed to get pending order %s: %v", event.OrderId, err)
+		return err
+	}
+	if order != nil {
+		if err := s.repo.CompleteCheckout(order.UserID, "", order); err != nil {
+			log.Printf("Failed to complete checkout of order %s for user %s: %v", event.OrderId, order.UserID, err)
+			return err
+		}
+		s.redeemCoupons(order.Redeemed, event.OrderId)
+		if len(order.Items) > 0 {
+			s.publishBasketCleared(ctx, order.UserID, order.Items, ClearedByPayment, time.Now())
+		}
+		log.Printf("Completed checkout of order %s for user %s from its payment event", event.OrderId, order.UserID)
+		return nil
+	}
+
+	basket, err := s.repo.GetByUserID(event.UserId)
+	if err != nil {
+		log.Printf("Failed to get basket for user %s: %v", event.UserId, err)
+		return err
+	}
+
+	// Count the coupons used by the order, then clear the user's basket
+	s.redeemCoupons(basket.CouponCodes, event.OrderId)
+
+	err = s.repo.Clear(event.UserId)
 	if err != nil {
 		log.Printf("Failed to clear basket for user %s: %v", event.UserId, err)
 		return err
 	}
+	if len(basket.Items) > 0 {
+		s.publishBasketCleared(ctx, event.UserId, basket.Items, ClearedByPayment, time.Now())
+	}
 
 	log.Printf("Successfully cleared basket for user %s after payment completion", event.UserId)
 	return nil
 }
 
+// HandleStockUpdated implements ProductEventHandler interface
+func (s *BasketService) HandleStockUpdated(ctx context.Context, event *events.StockUpdatedEvent) error {
+	return nil
+}
+
+// HandleProductUpdated implements ProductEventHandler interface
+func (s *BasketService) HandleProductUpdated(ctx context.Context, event *events.ProductUpdatedEvent) error {
+	switch event.Operation {
+	case "deleted":
+		log.Printf("Product %s deleted, flagging basket lines", event.ProductId)
+		return s.repo.SetProductDeleted(event.ProductId, true)
+	case "restored":
+		return s.repo.SetProductDeleted(event.ProductId, false)
+	}
+	return nil
+}
+
 // Helper functions
-func convertBasketItems(items []model.BasketItem) []*basketpb.BasketItem {
+
+// mutationError maps repository errors from basket mutations to gRPC status
+// errors. Conflicts are reported as Aborted so that clients can retry, stale
+// expected versions as Aborted with a VERSION_MISMATCH reason so that clients
+// reload first, and changes during a checkout or lines in another currency
+// than the basket as FailedPrecondition. Violated basket limits are reported
+// by limitError.
+func mutationError(msg string, err error) error {
+	var limitErr *model.LimitError
+	if errors.As(err, &limitErr) {
+		return limitError(limitErr)
+	}
+	if errors.Is(err, model.ErrVersionMismatch) {
+		return versionError(msg, err)
+	}
+	if errors.Is(err, model.ErrBasketConflict) {
+		return status.Errorf(codes.Aborted, "%s: %v", msg, err)
+	}
+	if errors.Is(err, model.ErrBasketLocked) || errors.Is(err, money.ErrCurrencyMismatch) {
+		return status.Errorf(codes.FailedPrecondition, "%s: %v", msg, err)
+	}
+	return status.Errorf(codes.Internal, "%s: %v", msg, err)
+}
+
+// versionError reports an update that expected an outdated basket version.
+func versionError(msg string, err error) error {
+	st := status.New(codes.Aborted, fmt.Sprintf("%s: %v", msg, err))
+	detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: "VERSION_MISMATCH",
+		Domain: stockErrorDomain,
+	})
+	if detailErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// findBasketItem returns the basket line for a product.
+func findBasketItem(basket *model.Basket, productID string) (model.BasketItem, bool) {
+	for _, item := range basket.Items {
+		if item.ProductID == productID {
+			return item, true
+		}
+	}
+	return model.BasketItem{}, false
+}
+
+// convertBasket builds the priced proto basket, flagging lines whose product
+// is no longer in the catalog.
+func (s *BasketService) convertBasket(ctx context.Context, basket *model.Basket) *basketpb.Basket {
+	return s.convertBasketWithChanges(ctx, basket, nil)
+}
+
+// convertBasketWithChanges is convertBasket for a revalidated basket, also
+// flagging lines whose price differs from the catalog.
+func (s *BasketService) convertBasketWithChanges(ctx context.Context, basket *model.Basket, changes map[string]priceChange) *basketpb.Basket {
+	return s.convertBasketWithUnavailable(basket, s.unavailableProducts(ctx, basket), changes)
+}
+
+// convertBasketWithUnavailable is convertBasketWithChanges with the
+// unavailable products already looked up. unavailable may hold products of
+// other baskets as well.
+func (s *BasketService) convertBasketWithUnavailable(basket *model.Basket, unavailable map[string]bool, changes map[string]priceChange) *basketpb.Basket {
+	hasUnavailable := false
+	for _, item := range basket.Items {
+		hasUnavailable = hasUnavailable || unavailable[item.ProductID]
+	}
+
+	// A basket that cannot be fully priced is still returned, flagged so
+	// that the incomplete totals are not taken as final
+	var pricingError string
+	quote, err := s.price(basket)
+	if err != nil {
+		log.Printf("Failed to price basket for user %s: %v", basket.UserID, err)
+		pricingError = err.Error()
+	}
+
+	var discounts []*basketpb.DiscountLine
+	for _, discount := range quote.Discounts {
+		discounts = append(discounts, &basketpb.DiscountLine{
+			Code:        discount.Code,
+			Description: discount.Description,
+			Amount:      money.ToProto(discount.Amount),
+		})
+	}
+
+	return &basketpb.Basket{
+		UserId:                 basket.UserID,
+		Items:                  convertBasketItems(basket.Items, unavailable, changes),
+		TotalAmount:            money.ToProto(quote.Total),
+		CreatedAt:              basket.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:              basket.UpdatedAt.Format(time.RFC3339),
+		HasUnavailableItems:    hasUnavailable,
+		Subtotal:               money.ToProto(quote.Subtotal),
+		Discounts:              discounts,
+		CouponCodes:            basket.CouponCodes,
+		Destination:            convertAddress(basket.Destination),
+		Shipping:               convertShipping(quote.Shipping),
+		Taxes:                  convertTaxes(quote.Taxes),
+		HasPriceChanges:        len(changes) > 0,
+		PriceChangesRequireAck: len(changes) > 0 && s.pricePolicy.Mode == model.PriceRequireAck,
+		Version:                basket.Version,
+		PricingError:           pricingError,
+	}
+}
+
+// unavailableProducts looks the products of a basket up in the catalog and
+// returns those that have been deleted or purged. If the catalog cannot be
+// reached, the deletions announced by product events are used instead.
+func (s *BasketService) unavailableProducts(ctx context.Context, basket *model.Basket) map[string]bool {
+	unavailable := make(map[string]bool)
+	if len(basket.Items) == 0 {
+		return unavailable
+	}
+	productIDs := make([]string, 0, len(basket.Items))
+	for _, item := range basket.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	products, err := s.products.GetProducts(ctx, productIDs)
+	if err == nil {
+		for _, productID := range productIDs {
+			if products[productID] == nil {
+				unavailable[productID] = true
+			}
+		}
+		return unavailable
+	}
+	log.Printf("Failed to look up products for user %s, using product events: %v", basket.UserID, err)
+
+	deleted, err := s.repo.GetDeletedProducts(productIDs)
+	if err != nil {
+		log.Printf("Failed to check deleted products for user %s: %v", basket.UserID, err)
+		return unavailable
+	}
+	return deleted
+}
+
+func convertBasketItems(items []model.BasketItem, unavailable map[string]bool, changes map[string]priceChange) []*basketpb.BasketItem {
 	var protoItems []*basketpb.BasketItem
 	for _, item := range items {
-		protoItems = append(protoItems, &basketpb.BasketItem{
+		protoItem := &basketpb.BasketItem{
 			ProductId:   item.ProductID,
 			ProductName: item.ProductName,
-			Price:       item.Price,
+			Price:       money.ToProto(item.Price),
 			Quantity:    item.Quantity,
-		})
+			Unavailable: unavailable[item.ProductID],
+		}
+		if !item.PriceSnapshotAt.IsZero() {
+			protoItem.PriceSnapshotAt = item.PriceSnapshotAt.Format(time.RFC3339)
+		}
+		if change, ok := changes[item.ProductID]; ok {
+			protoItem.PriceChanged = true
+			protoItem.PreviousPrice = money.ToProto(change.previous)
+			protoItem.CurrentPrice = money.ToProto(change.current)
+		}
+		protoItems = append(protoItems, protoItem)
 	}
 	return protoItems
 }
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stockErrorDomain identifies the ErrorInfo details attached to stock errors.
+const stockErrorDomain = "basket.daprps"
+
+// ValidateBasket checks every basket line against the catalog and reports
+// lines whose product is gone or whose quantity exceeds the available stock.
+func (s *BasketService) ValidateBasket(ctx context.Context, req *basketpb.ValidateBasketRequest) (*basketpb.ValidateBasketResponse, error) {
+	basket, err := s.repo.GetByUserID(req.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting basket: %v", err)
+	}
+
+	products, err := s.lookupProducts(ctx, basket.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []*basketpb.BasketIssue
+	for _, item := range basket.Items {
+		product := products[item.ProductID]
+		if product == nil {
+			issues = append(issues, &basketpb.BasketIssue{
+				ProductId:         item.ProductID,
+				Reason:            model.IssueUnavailable,
+				RequestedQuantity: item.Quantity,
+			})
+			continue
+		}
+
+		if item.Quantity > product.Available {
+			issues = append(issues, &basketpb.BasketIssue{
+				ProductId:         item.ProductID,
+				Reason:            model.IssueInsufficientStock,
+				RequestedQuantity: item.Quantity,
+				AvailableQuantity: product.Available,
+			})
+		}
+	}
+
+	return &basketpb.ValidateBasketResponse{
+		Basket: s.convertBasket(ctx, basket),
+		Valid:  len(issues) == 0,
+		Issues: issues,
+	}, nil
+}
+
+// lookupProduct fetches a product from the catalog as a gRPC status error.
+func (s *BasketService) lookupProduct(ctx context.Context, productID string) (*model.ProductInfo, error) {
+	product, err := s.products.GetProduct(ctx, productID)
+	if err != nil {
+		if errors.Is(err, model.ErrProductNotFound) {
+			return nil, status.Errorf(codes.NotFound, "product not found: %s", productID)
+		}
+		return nil, status.Errorf(codes.Unavailable, "error looking up product: %v", err)
+	}
+	return product, nil
+}
+
+// lookupProducts fetches the products of basket lines from the catalog in
+// one call, keyed by ID, as a gRPC status error. Deleted products are left
+// out.
+func (s *BasketService) lookupProducts(ctx context.Context, items []model.BasketItem) (map[string]*model.ProductInfo, error) {
+	if len(items) == 0 {
+		return map[string]*model.ProductInfo{}, nil
+	}
+	productIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	products, err := s.products.GetProducts(ctx, productIDs)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "error looking up products: %v", err)
+	}
+	return products, nil
+}
+
+// checkStock returns the quantity that may be put in the basket for a
+// requested total quantity. With clamp set the quantity is reduced to the
+// available stock instead of failing.
+func checkStock(product *model.ProductInfo, requested int32, clamp bool) (int32, bool, error) {
+	if requested <= product.Available {
+		return requested, false, nil
+	}
+	if !clamp || product.Available <= 0 {
+		return 0, false, stockError(product.ID, requested, product.Available)
+	}
+	return product.Available, true, nil
+}
+
+// stockError builds a FailedPrecondition status carrying the available
+// quantity in its details.
+func stockError(productID string, requested, available int32) error {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("insufficient stock for product %s: requested %d, available %d", productID, requested, available))
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: "INSUFFICIENT_STOCK",
+			Domain: stockErrorDomain,
+			Metadata: map[string]string{
+				"product_id":         productID,
+				"requested_quantity": fmt.Sprint(requested),
+				"available_quantity": fmt.Sprint(available),
+			},
+		},
+		&errdetails.PreconditionFailure{
+			Violations: []*errdetails.PreconditionFailure_Violation{{
+				Type:        "STOCK",
+				Subject:     productID,
+				Description: fmt.Sprintf("only %d available", available),
+			}},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// checkLimits checks a basket after the line of product was added or raised.
+func (s *BasketService) checkLimits(basket *model.Basket, product *model.ProductInfo) error {
+	if item, ok := findBasketItem(basket, product.ID); ok {
+		if err := s.limits.CheckLine(product, item.Quantity); err != nil {
+			return err
+		}
+	}
+	return s.limits.Check(basket)
+}
+
+// limitError reports a violated basket limit: InvalidArgument for the
+// quantity of a line, ResourceExhausted for the size of the basket. The
+// ErrorInfo names the limit.
+func limitError(err *model.LimitError) error {
+	code := codes.ResourceExhausted
+	if err.PerLine() {
+		code = codes.InvalidArgument
+	}
+	metadata := map[string]string{
+		"limit":  err.Limit,
+		"max":    err.Max,
+		"actual": err.Actual,
+	}
+	if err.ProductID != "" {
+		metadata["product_id"] = err.ProductID
+	}
+
+	st := status.New(code, err.Error())
+	detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "BASKET_LIMIT_EXCEEDED",
+		Domain:   stockErrorDomain,
+		Metadata: metadata,
+	})
+	if detailErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Types of basket updates
+const (
+	BasketSnapshot = "snapshot"
+	BasketUpdated  = "updated"
+)
+
+// watchResyncInterval is how often a watch re-reads the basket without a
+// change signal, covering notifications lost while the watcher reconnects.
+const watchResyncInterval = 30 * time.Second
+
+func (s *BasketService) WatchBasket(req *basketpb.WatchBasketRequest, stream basketpb.BasketService_WatchBasketServer) error {
+	return s.Watch(stream.Context(), req, stream.Send, nil)
+}
+
+// Watch sends the user's basket and then the whole basket again after every
+// change, wherever it was made, until ctx is done. Changes in quick
+// succession may be sent as one update. It is shared by the gRPC stream and
+// the HTTP event stream. If idle is set, it is called on every resync that
+// found no change, so that idle streams can be kept open.
+func (s *BasketService) Watch(ctx context.Context, req *basketpb.WatchBasketRequest, send func(*basketpb.BasketUpdate) error, idle func() error) error {
+	if req.UserId == "" {
+		return status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	if s.watcher == nil {
+		return status.Errorf(codes.Unimplemented, "basket watching is not enabled")
+	}
+
+	// Watch before the first read so that no change in between is missed
+	changes, stop := s.watcher.Watch(req.UserId)
+	defer stop()
+
+	resync := time.NewTicker(watchResyncInterval)
+	defer resync.Stop()
+
+	updateType := BasketSnapshot
+	var version int64
+	var updatedAt time.Time
+	resynced := false
+	for {
+		basket, err := s.repo.GetByUserID(req.UserId)
+		if err != nil {
+			return status.Errorf(codes.Internal, "error getting basket: %v", err)
+		}
+
+		// Signals for writes that left the basket as it was are not passed on
+		if updateType == BasketSnapshot || basket.Version != version || !basket.UpdatedAt.Equal(updatedAt) {
+			if err := send(&basketpb.BasketUpdate{Type: updateType, Basket: s.convertBasket(ctx, basket)}); err != nil {
+				return err
+			}
+			updateType = BasketUpdated
+			version, updatedAt = basket.Version, basket.UpdatedAt
+		} else if resynced && idle != nil {
+			if err := idle(); err != nil {
+				return err
+			}
+		}
+
+		resynced = false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+		case <-resync.C:
+			resynced = true
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	basketpb "daprps/api/proto/basket"
+	"daprps/internal/basket-service/model"
+	"daprps/internal/money"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// priceChange is a basket line whose snapshot price differs from the catalog.
+type priceChange struct {
+	productID string
+	previous  money.Money
+	current   money.Money
+}
+
+// AcknowledgePriceChanges moves every changed basket line to the price the
+// user acknowledged, which unblocks checkout under the PriceRequireAck
+// policy. It fails, listing the current prices, if a changed line was not
+// acknowledged or its catalog price changed again since it was shown.
+func (s *BasketService) AcknowledgePriceChanges(ctx context.Context, req *basketpb.AcknowledgePriceChangesRequest) (*basketpb.AcknowledgePriceChangesResponse, error) {
+	basket, err := s.repo.GetByUserID(req.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting basket: %v", err)
+	}
+
+	changes, err := s.findPriceChanges(ctx, basket)
+	if err != nil {
+		return nil, err
+	}
+
+	var unacknowledged []priceChange
+	for productID, change := range changes {
+		acknowledged, ok := req.Prices[productID]
+		if cmp, err := money.FromProto(acknowledged).Cmp(change.current); !ok || err != nil || cmp != 0 {
+			unacknowledged = append(unacknowledged, change)
+		}
+	}
+	if len(unacknowledged) > 0 {
+		return nil, priceChangeError(unacknowledged)
+	}
+
+	if len(changes) > 0 {
+		prices := make(map[string]money.Money, len(changes))
+		for productID, change := range changes {
+			prices[productID] = change.current
+		}
+		if err := s.repo.UpdatePrices(req.UserId, prices); err != nil {
+			return nil, mutationError("error updating prices", err)
+		}
+	}
+
+	basket, err = s.repo.GetByUserID(req.UserId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting updated basket: %v", err)
+	}
+
+	return &basketpb.AcknowledgePriceChangesResponse{
+		Basket:  s.convertBasket(ctx, basket),
+		Success: true,
+	}, nil
+}
+
+// revalidatePrices compares the basket with the catalog. Lines whose snapshot
+// the price drift policy no longer honours are moved to the current price,
+// in the basket and in the store. It returns every line whose snapshot
+// differed from the catalog.
+func (s *BasketService) revalidatePrices(ctx context.Context, basket *model.Basket) (map[string]priceChange, error) {
+	changes, err := s.findPriceChanges(ctx, basket)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	updates := make(map[string]money.Money)
+	for i, item := range basket.Items {
+		change, ok := changes[item.ProductID]
+		if !ok || s.pricePolicy.Honours(item, now) {
+			continue
+		}
+		basket.Items[i].Price = change.current
+		basket.Items[i].PriceSnapshotAt = now
+		updates[item.ProductID] = change.current
+	}
+	if len(updates) == 0 {
+		return changes, nil
+	}
+
+	// A catalog price in another currency leaves the total as it was; saving
+	// the update fails below
+	if total, err := basket.Subtotal(); err == nil {
+		basket.TotalAmount = total
+	}
+
+	// The updated prices are still returned if they cannot be saved, for
+	// instance while the basket is locked by a checkout
+	if err := s.repo.UpdatePrices(basket.UserID, updates); err != nil {
+		log.Printf("Failed to update prices of basket for user %s: %v", basket.UserID, err)
+	}
+	return changes, nil
+}
+
+// findPriceChanges looks up the current price of every basket line. Products
+// deleted from the catalog are skipped; they are flagged as unavailable.
+func (s *BasketService) findPriceChanges(ctx context.Context, basket *model.Basket) (map[string]priceChange, error) {
+	products, err := s.lookupProducts(ctx, basket.Items)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]priceChange)
+	for _, item := range basket.Items {
+		product := products[item.ProductID]
+		if product == nil {
+			continue
+		}
+		if product.Price != item.Price {
+			changes[item.ProductID] = priceChange{productID: item.ProductID, previous: item.Price, current: product.Price}
+		}
+	}
+	return changes, nil
+}
+
+// priceChangeError builds a FailedPrecondition status listing the price
+// changes that must be acknowledged before checkout.
+func priceChangeError(changes []priceChange) error {
+	st := status.New(codes.FailedPrecondition, "basket prices changed, acknowledge them with AcknowledgePriceChanges")
+
+	violations := make([]*errdetails.PreconditionFailure_Violation, 0, len(changes))
+	for _, change := range changes {
+		violations = append(violations, &errdetails.PreconditionFailure_Violation{
+			Type:        "PRICE_CHANGED",
+			Subject:     change.productID,
+			Description: fmt.Sprintf("price changed from %s to %s", change.previous, change.current),
+		})
+	}
+
+	detailed, err := st.WithDetails(&errdetails.PreconditionFailure{Violations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,57 @@
+package money
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// MigrateColumn fills the columns of an embedded Money field, prefix+"minor"
+// and prefix+"currency", from a legacy column holding the amount as a decimal
+// number of major units. currencyColumn names a legacy column with the
+// currency of each row; if it is empty, DefaultCurrency is assumed. Rows that
+// already have a currency are left alone, so it is safe to run on every start
+// after AutoMigrate. Tables without the legacy column are skipped.
+func MigrateColumn(db *gorm.DB, table, legacyColumn, prefix, currencyColumn string) error {
+	if !db.Migrator().HasColumn(table, legacyColumn) {
+		return nil
+	}
+
+	currencyExpr := "''"
+	if currencyColumn != "" {
+		currencyExpr = fmt.Sprintf("COALESCE(%s, '')", currencyColumn)
+	}
+	rows, err := db.Table(table).
+		Select(fmt.Sprintf("id, %s, %s", legacyColumn, currencyExpr)).
+		Where(fmt.Sprintf("%s IS NOT NULL AND (%scurrency IS NULL OR %scurrency = '')", legacyColumn, prefix, prefix)).
+		Rows()
+	if err != nil {
+		return fmt.Errorf("error reading %s.%s: %w", table, legacyColumn, err)
+	}
+
+	updates := make(map[string]Money)
+	for rows.Next() {
+		var id, currency string
+		var amount float64
+		if err := rows.Scan(&id, &amount, &currency); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading %s.%s: %w", table, legacyColumn, err)
+		}
+		updates[id] = FromMajor(amount, currency)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s.%s: %w", table, legacyColumn, err)
+	}
+
+	for id, amount := range updates {
+		err := db.Table(table).Where("id = ?", id).Updates(map[string]interface{}{
+			prefix + "minor":    amount.Amount,
+			prefix + "currency": amount.Currency,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("error migrating %s %s: %w", table, id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+// Package money represents amounts of money as an integer number of minor
+// units (cents for USD) with an ISO 4217 currency code, so that totals add up
+// exactly. Wherever an amount has to be rounded, such as percentages and
+// conversions from decimal values, it is rounded half to even (banker's
+// rounding).
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used for amounts without a currency, including amounts
+// stored before currencies were recorded.
+const DefaultCurrency = "USD"
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are
+// combined.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// exponents lists the currencies whose minor unit is not a hundredth.
+var exponents = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+}
+
+// Money is an amount in the minor unit of its currency.
+type Money struct {
+	Amount   int64  `json:"amount" gorm:"column:minor"`
+	Currency string `json:"currency" gorm:"column:currency;type:varchar(3)"`
+}
+
+// New returns amount minor units of currency. An empty currency means
+// DefaultCurrency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: normalizeCurrency(currency)}
+}
+
+// Zero returns no money in currency.
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// FromMajor converts a decimal amount in major units, such as dollars, to
+// Money. Digits beyond the minor unit are rounded half to even.
+func FromMajor(amount float64, currency string) Money {
+	m, err := Parse(strconv.FormatFloat(amount, 'f', -1, 64), currency)
+	if err != nil {
+		// Only NaN, infinities and amounts beyond int64 fail to parse
+		return Zero(currency)
+	}
+	return m
+}
+
+// Parse reads a decimal amount in major units, such as "12.50". Digits
+// beyond the minor unit are rounded half to even.
+func Parse(s, currency string) (Money, error) {
+	currency = normalizeCurrency(currency)
+	digits := strings.TrimSpace(s)
+	negative := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(strings.TrimPrefix(digits, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	exponent := Exponent(currency)
+	fraction += strings.Repeat("0", exponent)
+	kept, dropped := fraction[:exponent], strings.TrimRight(fraction[exponent:], "0")
+
+	amount, err := strconv.ParseInt(whole+kept, 10, 64)
+	if whole+kept == "" {
+		amount, err = 0, nil
+	}
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+
+	// Round the dropped digits half to even
+	if dropped != "" {
+		switch {
+		case dropped[0] > '5', dropped[0] == '5' && len(dropped) > 1, dropped == "5" && amount%2 == 1:
+			amount++
+		}
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Exponent returns the number of decimal digits of the minor unit of a
+// currency.
+func Exponent(currency string) int {
+	if exponent, ok := exponents[normalizeCurrency(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Major returns the amount in major units. It is meant for display and for
+// rates, never for further arithmetic on amounts.
+func (m Money) Major() float64 {
+	return float64(m.Amount) / math.Pow10(Exponent(m.Currency))
+}
+
+// Add returns m + other.
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.check(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: currency}, nil
+}
+
+// Sub returns m - other.
+func (m Money) Sub(other Money) (Money, error) {
+	currency, err := m.check(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: currency}, nil
+}
+
+// Cmp compares m with other, returning -1, 0 or +1.
+func (m Money) Cmp(other Money) (int, error) {
+	if _, err := m.check(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Mul returns m multiplied by a quantity.
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.currency()}
+}
+
+// Percent returns percent per cent of m, rounded half to even. The rate is
+// taken as the shortest decimal that reads back as percent, such as 7.25,
+// and the product is computed exactly in integers.
+func (m Money) Percent(percent float64) Money {
+	rate, ok := new(big.Rat).SetString(strconv.FormatFloat(percent, 'g', -1, 64))
+	if !ok {
+		// NaN and infinities
+		return Zero(m.currency())
+	}
+	product := rate.Mul(rate, big.NewRat(m.Amount, 100))
+	return Money{Amount: roundHalfEven(product), Currency: m.currency()}
+}
+
+// roundHalfEven rounds r to the nearest integer, ties to even.
+func roundHalfEven(r *big.Rat) int64 {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	twice := new(big.Int).Lsh(new(big.Int).Abs(remainder), 1)
+	if cmp := twice.Cmp(r.Denom()); cmp > 0 || cmp == 0 && quotient.Bit(0) == 1 {
+		quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+	}
+	return quotient.Int64()
+}
+
+// Min returns the smaller of m and other.
+func (m Money) Min(other Money) (Money, error) {
+	currency, err := m.check(other)
+	if err != nil {
+		return Money{}, err
+	}
+	if other.Amount < m.Amount {
+		return Money{Amount: other.Amount, Currency: currency}, nil
+	}
+	return Money{Amount: m.Amount, Currency: currency}, nil
+}
+
+// IsZero reports whether the amount is zero, whatever the currency.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// String formats m in major units followed by its currency, such as
+// "12.50 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency()
+}
+
+// Decimal formats the amount of m in major units, such as "12.50", which
+// Parse reads back.
+func (m Money) Decimal() string {
+	exponent := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	scale := int64(math.Pow10(exponent))
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, exponent, amount%scale)
+}
+
+// UnmarshalJSON accepts a Money object as well as a bare number, which is
+// how amounts were stored before: a decimal amount in major units of
+// DefaultCurrency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		return nil
+	}
+	if !strings.HasPrefix(trimmed, "{") {
+		parsed, err := Parse(trimmed, DefaultCurrency)
+		if err != nil {
+			// Large and tiny floats are encoded with an exponent
+			major, floatErr := strconv.ParseFloat(trimmed, 64)
+			if floatErr != nil {
+				return err
+			}
+			parsed = FromMajor(major, DefaultCurrency)
+		}
+		*m = parsed
+		return nil
+	}
+
+	type plain Money
+	var decoded plain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*m = Money{Amount: decoded.Amount, Currency: normalizeCurrency(decoded.Currency)}
+	return nil
+}
+
+// check verifies that other can be combined with m and returns the currency
+// of the result. The zero Money combines with any currency.
+func (m Money) check(other Money) (string, error) {
+	switch {
+	case m == Money{}:
+		return other.currency(), nil
+	case other == Money{}, m.currency() == other.currency():
+		return m.currency(), nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency(), other.currency())
+}
+
+func (m Money) currency() string {
+	return normalizeCurrency(m.Currency)
+}
+
+func normalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return currency
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
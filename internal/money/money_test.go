@@ -0,0 +1,314 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     int64
+	}{
+		{"12.50", "USD", 1250},
+		{"1", "USD", 100},
+		{".5", "USD", 50},
+		{"5.", "USD", 500},
+		{"+3.10", "USD", 310},
+		{" 7.25 ", "usd", 725},
+		// Half to even
+		{"0.125", "USD", 12},
+		{"0.135", "USD", 14},
+		{"0.1250001", "USD", 13},
+		{"0.124", "USD", 12},
+		{"0.126", "USD", 13},
+		{"0.12500", "USD", 12},
+		{"-0.125", "USD", -12},
+		{"-0.135", "USD", -14},
+		{"-0.126", "USD", -13},
+		// Zero-decimal currencies
+		{"1234", "JPY", 1234},
+		{"1234.5", "JPY", 1234},
+		{"1235.5", "JPY", 1236},
+		{"0.5", "JPY", 0},
+		{".6", "JPY", 1},
+		{"-1235.5", "KRW", -1236},
+		// Three-decimal currencies
+		{"2", "KWD", 2000},
+		{"0.001", "KWD", 1},
+		{"1.2345", "KWD", 1234},
+		{"1.2355", "KWD", 1236},
+		{"-1.2345", "BHD", -1234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.in, tt.currency)
+			if err != nil {
+				t.Fatalf("Parse(%q, %q) failed: %v", tt.in, tt.currency, err)
+			}
+			want := New(tt.want, tt.currency)
+			if got != want {
+				t.Errorf("Parse(%q, %q) = %v, want %v", tt.in, tt.currency, got, want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", ".", "-", "abc", "1.2.3", "1e5", "1,50", "--1", "99999999999999999999"} {
+		if got, err := Parse(in, "USD"); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		percent  float64
+		want     int64
+	}{
+		{1000, "USD", 10, 100},
+		{1999, "USD", 7.25, 145},
+		{125, "USD", 10, 12},
+		{135, "USD", 10, 14},
+		{0, "USD", 50, 0},
+		{1000, "USD", 0, 0},
+		{1000, "USD", 100, 1000},
+		// Negative amounts round half to even as well, away from zero
+		// only when the truncated amount is odd
+		{-125, "USD", 10, -12},
+		{-135, "USD", 10, -14},
+		{-124, "USD", 10, -12},
+		{-126, "USD", 10, -13},
+		{-1999, "USD", 7.25, -145},
+		{1000, "USD", -10, -100},
+		// Zero- and three-decimal currencies round in their own minor unit
+		{1005, "JPY", 10, 100},
+		{1015, "JPY", 10, 102},
+		{12345, "KWD", 50, 6172},
+		{12355, "KWD", 50, 6178},
+		{math.MaxInt64 / 100, "USD", 100, math.MaxInt64 / 100},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %s %g%%", tt.amount, tt.currency, tt.percent), func(t *testing.T) {
+			got := New(tt.amount, tt.currency).Percent(tt.percent)
+			if want := New(tt.want, tt.currency); got != want {
+				t.Errorf("Percent = %v, want %v", got, want)
+			}
+		})
+	}
+
+	for _, percent := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if got := New(1000, "USD").Percent(percent); !got.IsZero() {
+			t.Errorf("Percent(%g) = %v, want zero", percent, got)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{1250, "USD", "12.50"},
+		{5, "USD", "0.05"},
+		{-5, "USD", "-0.05"},
+		{0, "USD", "0.00"},
+		{1234, "JPY", "1234"},
+		{-1234, "JPY", "-1234"},
+		{1234, "KWD", "1.234"},
+		{-5, "KWD", "-0.005"},
+		{7, "", "0.07"},
+	}
+	for _, tt := range tests {
+		m := New(tt.amount, tt.currency)
+		if got := m.Decimal(); got != tt.want {
+			t.Errorf("%d %s: Decimal() = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+		if back, err := Parse(m.Decimal(), m.Currency); err != nil || back != m {
+			t.Errorf("%d %s: Parse(Decimal()) = %v, %v, want %v", tt.amount, tt.currency, back, err, m)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	usd := func(amount int64) Money { return New(amount, "USD") }
+
+	if got, err := usd(150).Add(usd(-200)); err != nil || got != usd(-50) {
+		t.Errorf("Add = %v, %v, want -0.50 USD", got, err)
+	}
+	if got, err := (Money{}).Add(New(5, "JPY")); err != nil || got != New(5, "JPY") {
+		t.Errorf("zero Add = %v, %v, want 5 JPY", got, err)
+	}
+	if _, err := usd(1).Sub(New(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Sub across currencies: err = %v, want ErrCurrencyMismatch", err)
+	}
+	if got, err := usd(3).Cmp(usd(-3)); err != nil || got != 1 {
+		t.Errorf("Cmp = %d, %v, want 1", got, err)
+	}
+	if got, err := usd(3).Min(usd(-3)); err != nil || got != usd(-3) {
+		t.Errorf("Min = %v, %v, want -0.03 USD", got, err)
+	}
+	if got := usd(-125).Mul(3); got != usd(-375) {
+		t.Errorf("Mul = %v, want -3.75 USD", got)
+	}
+}
+
+// TestFromMajor covers the conversion of legacy amounts, which were stored as
+// floats in major units.
+func TestFromMajor(t *testing.T) {
+	tests := []struct {
+		major    float64
+		currency string
+		want     int64
+	}{
+		{19.99, "USD", 1999},
+		{-19.99, "USD", -1999},
+		{0.1 + 0.2, "USD", 30},
+		{1.005, "USD", 100},
+		{2.675, "USD", 268},
+		{1e-9, "USD", 0},
+		{1500, "JPY", 1500},
+		{1500.5, "JPY", 1500},
+		{1.2345, "KWD", 1234},
+		{3, "", 300},
+		{math.NaN(), "USD", 0},
+		{math.Inf(1), "USD", 0},
+		{1e30, "USD", 0},
+	}
+	for _, tt := range tests {
+		if got, want := FromMajor(tt.major, tt.currency), New(tt.want, tt.currency); got != want {
+			t.Errorf("FromMajor(%g, %q) = %v, want %v", tt.major, tt.currency, got, want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{`{"amount":1250,"currency":"usd"}`, New(1250, "USD")},
+		{`{"amount":5,"currency":"JPY"}`, New(5, "JPY")},
+		{`{"amount":5}`, New(5, DefaultCurrency)},
+		// Legacy amounts are decimal major units of DefaultCurrency
+		{`12.5`, New(1250, DefaultCurrency)},
+		{`0.125`, New(12, DefaultCurrency)},
+		{`-3`, New(-300, DefaultCurrency)},
+		{`1.5e2`, New(15000, DefaultCurrency)},
+		{`null`, Money{}},
+	}
+	for _, tt := range tests {
+		var got Money
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Errorf("Unmarshal(%s) failed: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`"12.50"`), &m); err == nil {
+		t.Errorf("Unmarshal of a string = %v, want an error", m)
+	}
+}
+
+// testDB returns the Postgres database tests may use, skipping the test when
+// MONEY_TEST_POSTGRES_DSN is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("MONEY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("MONEY_TEST_POSTGRES_DSN not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	return db
+}
+
+func TestMigrateColumn(t *testing.T) {
+	db := testDB(t)
+	table := fmt.Sprintf("money_migrate_test_%d", time.Now().UnixNano())
+	err := db.Exec(fmt.Sprintf(`CREATE TABLE %s (
+		id text PRIMARY KEY,
+		price double precision,
+		currency text,
+		price_minor bigint,
+		price_currency varchar(3)
+	)`, table)).Error
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	defer db.Exec("DROP TABLE " + table)
+
+	err = db.Exec(fmt.Sprintf(`INSERT INTO %s (id, price, currency, price_minor, price_currency) VALUES
+		('usd', 19.99, 'USD', NULL, NULL),
+		('jpy', 1500, 'JPY', NULL, ''),
+		('kwd', 1.2345, 'kwd', NULL, NULL),
+		('tie', 2.675, NULL, NULL, NULL),
+		('done', 5, 'EUR', 7, 'EUR'),
+		('none', NULL, 'USD', NULL, NULL)`, table)).Error
+	if err != nil {
+		t.Fatalf("Failed to insert rows: %v", err)
+	}
+
+	// Running twice must not change migrated rows
+	for i := 0; i < 2; i++ {
+		if err := MigrateColumn(db, table, "price", "price_", "currency"); err != nil {
+			t.Fatalf("MigrateColumn failed: %v", err)
+		}
+	}
+
+	want := map[string]Money{
+		"usd":  New(1999, "USD"),
+		"jpy":  New(1500, "JPY"),
+		"kwd":  New(1234, "KWD"),
+		"tie":  New(268, DefaultCurrency),
+		"done": New(7, "EUR"),
+	}
+	type row struct {
+		ID            string
+		PriceMinor    *int64
+		PriceCurrency *string
+	}
+	var rows []row
+	if err := db.Table(table).Select("id, price_minor, price_currency").Scan(&rows).Error; err != nil {
+		t.Fatalf("Failed to read rows: %v", err)
+	}
+	for _, r := range rows {
+		expected, ok := want[r.ID]
+		if !ok {
+			if r.PriceMinor != nil {
+				t.Errorf("%s: migrated to %d, want it left alone", r.ID, *r.PriceMinor)
+			}
+			continue
+		}
+		if r.PriceMinor == nil || r.PriceCurrency == nil {
+			t.Errorf("%s: not migrated, want %v", r.ID, expected)
+			continue
+		}
+		if got := New(*r.PriceMinor, *r.PriceCurrency); got != expected {
+			t.Errorf("%s: migrated to %v, want %v", r.ID, got, expected)
+		}
+	}
+
+	if err := MigrateColumn(db, table, "missing", "price_", ""); err != nil {
+		t.Errorf("MigrateColumn without the legacy column failed: %v", err)
+	}
+}
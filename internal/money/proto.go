@@ -0,0 +1,14 @@
+package money
+
+import moneypb "daprps/api/proto/money"
+
+// ToProto converts m to its proto message.
+func ToProto(m Money) *moneypb.Money {
+	return &moneypb.Money{Amount: m.Amount, Currency: m.currency()}
+}
+
+// FromProto converts a proto message to Money. A nil message is zero
+// DefaultCurrency.
+func FromProto(m *moneypb.Money) Money {
+	return New(m.GetAmount(), m.GetCurrency())
+}
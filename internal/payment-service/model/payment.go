@@ -1,17 +1,24 @@
 package model
 
 import (
+	"errors"
 	"time"
 
+	"daprps/internal/money"
+
 	"gorm.io/gorm"
 )
 
+// ErrOrderPaid is returned when a second payment is created for an order.
+var ErrOrderPaid = errors.New("order already has a payment")
+
+// Payment is a charge. An order has at most one payment, which makes the
+// order ID the idempotency key of charges.
 type Payment struct {
 	ID            string         `json:"id" gorm:"primaryKey;type:varchar(255)"`
-	OrderID       string         `json:"order_id" gorm:"type:varchar(255);not null"`
+	OrderID       string         `json:"order_id" gorm:"type:varchar(255);not null;uniqueIndex:idx_payments_order_id,where:order_id <> ''"`
 	UserID        string         `json:"user_id" gorm:"type:varchar(255)"`
-	Amount        float64        `json:"amount"`
-	Currency      string         `json:"currency" gorm:"type:varchar(10)"`
+	Amount        money.Money    `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
 	Status        string         `json:"status" gorm:"type:varchar(50);not null"`
 	PaymentMethod string         `json:"payment_method" gorm:"type:varchar(50)"`
 	CardNumber    string         `json:"card_number" gorm:"type:varchar(32)"`
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -35,8 +36,14 @@ func (r *PaymentRepositoryImpl) GetByOrderID(orderID string) (*model.Payment, er
 	return &payment, nil
 }
 
+// Create stores a new payment. It fails with ErrOrderPaid if the order
+// already has one, which the database must report as gorm.ErrDuplicatedKey.
 func (r *PaymentRepositoryImpl) Create(payment *model.Payment) error {
-	return r.db.Create(payment).Error
+	err := r.db.Create(payment).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return fmt.Errorf("%w: %s", model.ErrOrderPaid, payment.OrderID)
+	}
+	return err
 }
 
 func (r *PaymentRepositoryImpl) Update(payment *model.Payment) error {
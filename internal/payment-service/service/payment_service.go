@@ -2,11 +2,13 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"daprps/api/proto/events"
 	paymentpb "daprps/api/proto/payment"
+	"daprps/internal/money"
 	"daprps/internal/payment-service/model"
 	"daprps/kafka/publisher"
 
@@ -28,13 +30,20 @@ func NewPaymentService(repo model.PaymentRepository, publisher *publisher.Paymen
 }
 
 func (s *PaymentService) ProcessPayment(ctx context.Context, req *paymentpb.ProcessPaymentRequest) (*paymentpb.ProcessPaymentResponse, error) {
+	// The order ID is the idempotency key: a retried charge of an order
+	// returns the existing payment instead of charging again
+	if req.OrderId != "" {
+		if existing, err := s.repo.GetByOrderID(req.OrderId); err == nil {
+			return existingPayment(existing, money.FromProto(req.Amount))
+		}
+	}
+
 	// Create payment record
 	payment := &model.Payment{
 		ID:            generatePaymentID(),
 		OrderID:       req.OrderId,
-		UserID:        "", // Will be set from context or request
-		Amount:        req.Amount,
-		Currency:      req.Currency,
+		UserID:        req.UserId,
+		Amount:        money.FromProto(req.Amount),
 		Status:        "pending",
 		PaymentMethod: req.PaymentMethod,
 		CardNumber:    req.CardNumber,
@@ -44,8 +53,16 @@ func (s *PaymentService) ProcessPayment(ctx context.Context, req *paymentpb.Proc
 		UpdatedAt:     time.Now(),
 	}
 
-	// Save payment to database
+	// Save payment to database. A concurrent charge of the same order that
+	// created its payment first is answered like a retry.
 	err := s.repo.Create(payment)
+	if errors.Is(err, model.ErrOrderPaid) {
+		existing, err := s.repo.GetByOrderID(req.OrderId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error getting payment of order %s: %v", req.OrderId, err)
+		}
+		return existingPayment(existing, payment.Amount)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error creating payment: %v", err)
 	}
@@ -65,11 +82,18 @@ func (s *PaymentService) ProcessPayment(ctx context.Context, req *paymentpb.Proc
 		PaymentId:     payment.ID,
 		OrderId:       payment.OrderID,
 		UserId:        payment.UserID,
-		Amount:        payment.Amount,
-		Currency:      payment.Currency,
+		Amount:        money.ToProto(payment.Amount),
 		PaymentMethod: payment.PaymentMethod,
 		CompletedAt:   time.Now().Format(time.RFC3339),
 	}
+	for _, item := range req.Items {
+		event.Items = append(event.Items, &events.OrderItem{
+			ProductId:   item.ProductId,
+			ProductName: item.ProductName,
+			Price:       item.Price,
+			Quantity:    item.Quantity,
+		})
+	}
 
 	err = s.publisher.PublishPaymentCompleted(ctx, event)
 	if err != nil {
@@ -87,8 +111,7 @@ func (s *PaymentService) ProcessPayment(ctx context.Context, req *paymentpb.Proc
 		Payment: &paymentpb.Payment{
 			Id:            updatedPayment.ID,
 			OrderId:       updatedPayment.OrderID,
-			Amount:        updatedPayment.Amount,
-			Currency:      updatedPayment.Currency,
+			Amount:        money.ToProto(updatedPayment.Amount),
 			Status:        updatedPayment.Status,
 			PaymentMethod: updatedPayment.PaymentMethod,
 			CreatedAt:     updatedPayment.CreatedAt.Format(time.RFC3339),
@@ -98,6 +121,36 @@ func (s *PaymentService) ProcessPayment(ctx context.Context, req *paymentpb.Proc
 	}, nil
 }
 
+// existingPayment answers a retried charge with the payment already made for
+// the order.
+func existingPayment(payment *model.Payment, amount money.Money) (*paymentpb.ProcessPaymentResponse, error) {
+	if cmp, err := payment.Amount.Cmp(amount); err != nil || cmp != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "order %s was already charged %s", payment.OrderID, payment.Amount)
+	}
+	switch payment.Status {
+	case "pending":
+		return nil, status.Errorf(codes.Unavailable, "payment of order %s is in progress", payment.OrderID)
+	case "failed":
+		return &paymentpb.ProcessPaymentResponse{
+			Success: false,
+			Error:   "payment failed",
+		}, nil
+	}
+
+	return &paymentpb.ProcessPaymentResponse{
+		Payment: &paymentpb.Payment{
+			Id:            payment.ID,
+			OrderId:       payment.OrderID,
+			Amount:        money.ToProto(payment.Amount),
+			Status:        payment.Status,
+			PaymentMethod: payment.PaymentMethod,
+			CreatedAt:     payment.CreatedAt.Format(time.RFC3339),
+			UpdatedAt:     payment.UpdatedAt.Format(time.RFC3339),
+		},
+		Success: true,
+	}, nil
+}
+
 func (s *PaymentService) GetPaymentStatus(ctx context.Context, req *paymentpb.GetPaymentStatusRequest) (*paymentpb.GetPaymentStatusResponse, error) {
 	payment, err := s.repo.GetByID(req.PaymentId)
 	if err != nil {
@@ -108,8 +161,7 @@ func (s *PaymentService) GetPaymentStatus(ctx context.Context, req *paymentpb.Ge
 		Payment: &paymentpb.Payment{
 			Id:            payment.ID,
 			OrderId:       payment.OrderID,
-			Amount:        payment.Amount,
-			Currency:      payment.Currency,
+			Amount:        money.ToProto(payment.Amount),
 			Status:        payment.Status,
 			PaymentMethod: payment.PaymentMethod,
 			CreatedAt:     payment.CreatedAt.Format(time.RFC3339),
@@ -130,6 +182,17 @@ func (s *PaymentService) RefundPayment(ctx context.Context, req *paymentpb.Refun
 		return nil, status.Errorf(codes.FailedPrecondition, "payment is not completed, cannot refund")
 	}
 
+	// A partial refund must be in the currency of the payment and not exceed it
+	if req.Amount != nil {
+		cmp, err := money.FromProto(req.Amount).Cmp(payment.Amount)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid refund amount: %v", err)
+		}
+		if cmp > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "refund amount exceeds payment amount")
+		}
+	}
+
 	// Simulate refund processing
 	// In real app, this would call payment gateway
 	time.Sleep(100 * time.Millisecond)
@@ -147,13 +210,12 @@ func (s *PaymentService) RefundPayment(ctx context.Context, req *paymentpb.Refun
 }
 
 // Business logic methods
-func (s *PaymentService) ProcessPaymentWithItems(ctx context.Context, orderID, userID string, amount float64, currency, paymentMethod string) (*model.Payment, error) {
+func (s *PaymentService) ProcessPaymentWithItems(ctx context.Context, orderID, userID string, amount money.Money, paymentMethod string) (*model.Payment, error) {
 	payment := &model.Payment{
 		ID:            generatePaymentID(),
 		OrderID:       orderID,
 		UserID:        userID,
 		Amount:        amount,
-		Currency:      currency,
 		Status:        "pending",
 		PaymentMethod: paymentMethod,
 		CreatedAt:     time.Now(),
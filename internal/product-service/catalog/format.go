@@ -0,0 +1,222 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"daprps/internal/money"
+	"daprps/internal/product-service/model"
+)
+
+const (
+	FormatCSV        = "csv"
+	FormatJSONLines  = "jsonl"
+	maxJSONLineBytes = 1024 * 1024
+)
+
+// csvHeader is the column order used for both reading and writing CSV files.
+var csvHeader = []string{"id", "sku", "name", "description", "price", "currency", "stock", "category", "weight_grams", "max_per_order"}
+
+// Row is a single parsed input line, kept with its position for error reporting.
+type Row struct {
+	Line    int
+	Product *model.Product
+}
+
+// RowError describes why a given input line was rejected.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// DetectFormat returns the format for a file, preferring an explicit value
+// over the file extension.
+func DetectFormat(explicit, path string) (string, error) {
+	if explicit != "" {
+		switch explicit {
+		case FormatCSV, FormatJSONLines:
+			return explicit, nil
+		}
+		return "", fmt.Errorf("unsupported format: %s", explicit)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONLines, nil
+	}
+	return "", fmt.Errorf("cannot detect format of %q, use -format", path)
+}
+
+// ReadRows parses every row of the input. Rows that cannot be parsed are
+// returned as RowErrors so the caller can report all of them at once.
+func ReadRows(r io.Reader, format string) ([]Row, []error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSONLines:
+		return readJSONLines(r)
+	}
+	return nil, []error{fmt.Errorf("unsupported format: %s", format)}
+}
+
+func readCSV(r io.Reader) ([]Row, []error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, []error{fmt.Errorf("error reading CSV header: %w", err)}
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, []error{errors.New("CSV header must contain a name column")}
+	}
+
+	var rows []Row
+	var errs []error
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			errs = append(errs, &RowError{Line: line, Err: err})
+			continue
+		}
+
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		product := &model.Product{
+			ID:          get("id"),
+			SKU:         get("sku"),
+			Name:        get("name"),
+			Description: get("description"),
+			Category:    get("category"),
+		}
+		if v := get("price"); v != "" {
+			product.Price, err = money.Parse(v, get("currency"))
+			if err != nil {
+				errs = append(errs, &RowError{Line: line, Err: fmt.Errorf("invalid price %q", v)})
+				continue
+			}
+		}
+		if v := get("stock"); v != "" {
+			stock, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, &RowError{Line: line, Err: fmt.Errorf("invalid stock %q", v)})
+				continue
+			}
+			product.Stock = int32(stock)
+		}
+		if v := get("weight_grams"); v != "" {
+			weight, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, &RowError{Line: line, Err: fmt.Errorf("invalid weight_grams %q", v)})
+				continue
+			}
+			product.WeightGrams = int32(weight)
+		}
+		if v := get("max_per_order"); v != "" {
+			limit, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, &RowError{Line: line, Err: fmt.Errorf("invalid max_per_order %q", v)})
+				continue
+			}
+			product.MaxPerOrder = int32(limit)
+		}
+
+		rows = append(rows, Row{Line: line, Product: product})
+	}
+
+	return rows, errs
+}
+
+func readJSONLines(r io.Reader) ([]Row, []error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineBytes)
+
+	var rows []Row
+	var errs []error
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var product model.Product
+		if err := json.Unmarshal([]byte(text), &product); err != nil {
+			errs = append(errs, &RowError{Line: line, Err: err})
+			continue
+		}
+		rows = append(rows, Row{Line: line, Product: &product})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("error reading input: %w", err))
+	}
+
+	return rows, errs
+}
+
+// WriteProducts writes products in the given format.
+func WriteProducts(w io.Writer, format string, products []*model.Product) error {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, p := range products {
+			err := writer.Write([]string{
+				p.ID,
+				p.SKU,
+				p.Name,
+				p.Description,
+				p.Price.Decimal(),
+				p.Price.Currency,
+				strconv.FormatInt(int64(p.Stock), 10),
+				p.Category,
+				strconv.FormatInt(int64(p.WeightGrams), 10),
+				strconv.FormatInt(int64(p.MaxPerOrder), 10),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatJSONLines:
+		encoder := json.NewEncoder(w)
+		for _, p := range products {
+			if err := encoder.Encode(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported format: %s", format)
+}
@@ -0,0 +1,295 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"daprps/internal/product-service/model"
+
+	"gorm.io/gorm"
+)
+
+const DefaultBatchSize = 100
+
+// Change actions reported by an import.
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionRestore   = "restore"
+	ActionUnchanged = "unchanged"
+)
+
+// FieldDiff is a single field that differs between the stored and imported product.
+type FieldDiff struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Change describes what an import does, or would do in dry-run mode, to one product.
+type Change struct {
+	Line      int
+	Action    string
+	ProductID string
+	SKU       string
+	Diffs     []FieldDiff
+}
+
+// Report summarises an import run.
+type Report struct {
+	DryRun  bool
+	Changes []Change
+}
+
+func (r *Report) count(action string) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Write prints a human readable diff of the import.
+func (r *Report) Write(w io.Writer) {
+	for _, c := range r.Changes {
+		switch c.Action {
+		case ActionCreate:
+			fmt.Fprintf(w, "+ %s (line %d)\n", describe(c), c.Line)
+		case ActionUpdate, ActionRestore:
+			marker := "~"
+			if c.Action == ActionRestore {
+				marker = "^"
+			}
+			fmt.Fprintf(w, "%s %s (line %d)\n", marker, describe(c), c.Line)
+			for _, d := range c.Diffs {
+				fmt.Fprintf(w, "    %s: %q -> %q\n", d.Field, d.Old, d.New)
+			}
+		}
+	}
+
+	mode := "applied"
+	if r.DryRun {
+		mode = "dry run, nothing written"
+	}
+	fmt.Fprintf(w, "%d created, %d updated, %d restored, %d unchanged (%s)\n",
+		r.count(ActionCreate), r.count(ActionUpdate), r.count(ActionRestore), r.count(ActionUnchanged), mode)
+}
+
+func describe(c Change) string {
+	if c.SKU != "" {
+		return fmt.Sprintf("%s [sku %s]", c.ProductID, c.SKU)
+	}
+	return c.ProductID
+}
+
+// Importer validates catalog rows and upserts them through the product repository.
+type Importer struct {
+	repo      model.ProductRepository
+	batchSize int
+	dryRun    bool
+}
+
+func NewImporter(repo model.ProductRepository, batchSize int, dryRun bool) *Importer {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Importer{
+		repo:      repo,
+		batchSize: batchSize,
+		dryRun:    dryRun,
+	}
+}
+
+// Validate checks a single product row.
+func Validate(p *model.Product) error {
+	var problems []string
+	if strings.TrimSpace(p.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if p.Price.Amount < 0 {
+		problems = append(problems, "price must not be negative")
+	}
+	if p.Stock < 0 {
+		problems = append(problems, "stock must not be negative")
+	}
+	if p.WeightGrams < 0 {
+		problems = append(problems, "weight_grams must not be negative")
+	}
+	if p.MaxPerOrder < 0 {
+		problems = append(problems, "max_per_order must not be negative")
+	}
+	if len(p.SKU) > 100 {
+		problems = append(problems, "sku must be at most 100 characters")
+	}
+	if len(p.Category) > 100 {
+		problems = append(problems, "category must be at most 100 characters")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Import validates every row before writing anything. If any row is invalid
+// no batch is written and all validation errors are returned.
+func (i *Importer) Import(rows []Row) (*Report, []error) {
+	var errs []error
+	seen := make(map[string]int)
+	for _, row := range rows {
+		if err := Validate(row.Product); err != nil {
+			errs = append(errs, &RowError{Line: row.Line, Err: err})
+			continue
+		}
+		for _, key := range []string{"id:" + row.Product.ID, "sku:" + row.Product.SKU} {
+			if key == "id:" || key == "sku:" {
+				continue
+			}
+			if first, ok := seen[key]; ok {
+				errs = append(errs, &RowError{Line: row.Line, Err: fmt.Errorf("duplicate %s, first seen on line %d", key, first)})
+			}
+			seen[key] = row.Line
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	report := &Report{DryRun: i.dryRun}
+	batch := make([]*model.Product, 0, i.batchSize)
+	for _, row := range rows {
+		change, err := i.plan(row)
+		if err != nil {
+			return report, []error{err}
+		}
+		report.Changes = append(report.Changes, change)
+		if change.Action == ActionUnchanged {
+			continue
+		}
+
+		batch = append(batch, row.Product)
+		if len(batch) == i.batchSize {
+			if err := i.flush(batch); err != nil {
+				return report, []error{err}
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := i.flush(batch); err != nil {
+		return report, []error{err}
+	}
+
+	return report, nil
+}
+
+func (i *Importer) flush(batch []*model.Product) error {
+	if i.dryRun || len(batch) == 0 {
+		return nil
+	}
+	if err := i.repo.UpsertBatch(batch); err != nil {
+		return fmt.Errorf("error importing batch: %w", err)
+	}
+	return nil
+}
+
+// plan resolves the row to an existing product by ID, falling back to SKU,
+// and works out what would change. Soft-deleted products are matched too and
+// restored by the import.
+func (i *Importer) plan(row Row) (Change, error) {
+	product := row.Product
+	product.DeletedAt = gorm.DeletedAt{}
+
+	existing, err := i.lookup(product)
+	if err != nil {
+		return Change{}, &RowError{Line: row.Line, Err: err}
+	}
+
+	if existing == nil {
+		if product.ID == "" {
+			product.ID = generateID()
+		}
+		return Change{Line: row.Line, Action: ActionCreate, ProductID: product.ID, SKU: product.SKU}, nil
+	}
+
+	product.ID = existing.ID
+	diffs := diffProducts(existing, product)
+	action := ActionUpdate
+	switch {
+	case existing.DeletedAt.Valid:
+		action = ActionRestore
+	case len(diffs) == 0:
+		action = ActionUnchanged
+	}
+	return Change{Line: row.Line, Action: action, ProductID: product.ID, SKU: product.SKU, Diffs: diffs}, nil
+}
+
+func (i *Importer) lookup(product *model.Product) (*model.Product, error) {
+	if product.ID != "" {
+		existing, err := i.repo.GetByIDUnscoped(product.ID)
+		if err == nil {
+			if product.SKU != "" && product.SKU != existing.SKU {
+				if err := i.checkSKUFree(product); err != nil {
+					return nil, err
+				}
+			}
+			return existing, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if product.SKU != "" {
+		existing, err := i.repo.GetBySKUUnscoped(product.SKU)
+		if err == nil {
+			if product.ID != "" && product.ID != existing.ID {
+				return nil, fmt.Errorf("sku %s already belongs to product %s", product.SKU, existing.ID)
+			}
+			return existing, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// checkSKUFree fails if another product already has the SKU of product.
+func (i *Importer) checkSKUFree(product *model.Product) error {
+	owner, err := i.repo.GetBySKUUnscoped(product.SKU)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil
+	case err != nil:
+		return err
+	case owner.ID != product.ID:
+		return fmt.Errorf("sku %s already belongs to product %s", product.SKU, owner.ID)
+	}
+	return nil
+}
+
+func diffProducts(old, new *model.Product) []FieldDiff {
+	var diffs []FieldDiff
+	add := func(field, o, n string) {
+		if o != n {
+			diffs = append(diffs, FieldDiff{Field: field, Old: o, New: n})
+		}
+	}
+	add("sku", old.SKU, new.SKU)
+	add("name", old.Name, new.Name)
+	add("description", old.Description, new.Description)
+	add("price", old.Price.String(), new.Price.String())
+	add("stock", fmt.Sprint(old.Stock), fmt.Sprint(new.Stock))
+	add("category", old.Category, new.Category)
+	add("weight_grams", fmt.Sprint(old.WeightGrams), fmt.Sprint(new.WeightGrams))
+	add("max_per_order", fmt.Sprint(old.MaxPerOrder), fmt.Sprint(new.MaxPerOrder))
+	return diffs
+}
+
+// generateID mirrors the product service ID scheme (in real app, use UUID).
+func generateID() string {
+	return fmt.Sprintf("prod_%d", time.Now().UnixNano())
+}
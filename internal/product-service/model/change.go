@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// ProductChange records that a product changed. Changes are recorded in the
+// transaction of the product or stock write they describe. IDs come from a
+// database sequence, so they order the changes of every replica and survive
+// restarts; product watches use them as versions.
+type ProductChange struct {
+	ID        int64     `json:"id" gorm:"primaryKey;autoIncrement"`
+	ProductID string    `json:"product_id" gorm:"type:varchar(255);not null"`
+	Deleted   bool      `json:"deleted" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+// ChangeRepository reads the change log. The product and inventory
+// repositories write it.
+type ChangeRepository interface {
+	// Since returns up to limit changes with an ID above id, oldest first.
+	Since(id int64, limit int) ([]ProductChange, error)
+	// Latest returns the ID of the newest change, 0 if there is none.
+	Latest() (int64, error)
+	// Prune removes changes recorded before the given time.
+	Prune(before time.Time) (int64, error)
+}
@@ -3,16 +3,23 @@ package model
 import (
 	"time"
 
+	"daprps/internal/money"
+
 	"gorm.io/gorm"
 )
 
+// Product is a catalog entry. A SKU, when set, identifies a single product,
+// deleted ones included, so that imports matching by SKU find one row.
 type Product struct {
 	ID          string         `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	SKU         string         `json:"sku" gorm:"type:varchar(100);uniqueIndex:idx_products_sku_unique,where:sku <> ''"`
 	Name        string         `json:"name" gorm:"type:varchar(255);not null"`
 	Description string         `json:"description" gorm:"type:text"`
-	Price       float64        `json:"price"`
+	Price       money.Money    `json:"price" gorm:"embedded;embeddedPrefix:price_"`
 	Stock       int32          `json:"stock" gorm:"type:int;not null;default:0"`
 	Category    string         `json:"category" gorm:"type:varchar(100)"`
+	WeightGrams int32          `json:"weight_grams" gorm:"type:int;not null;default:0"`
+	MaxPerOrder int32          `json:"max_per_order" gorm:"type:int;not null;default:0"`
 	CreatedAt   time.Time      `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt   time.Time      `json:"updated_at" gorm:"autoUpdateTime"`
 	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
@@ -20,9 +27,18 @@ type Product struct {
 
 type ProductRepository interface {
 	GetByID(id string) (*Product, error)
+	// GetByIDs returns the products with the given IDs. Unknown and deleted
+	// products are left out.
+	GetByIDs(ids []string) ([]*Product, error)
 	GetAll(category string, limit, offset int32) ([]*Product, error)
 	UpdateStock(id string, quantity int32, operation string) (*Product, error)
 	Create(product *Product) error
 	Update(product *Product) error
 	Delete(id string) error
+	UpsertBatch(products []*Product) error
+	GetByIDUnscoped(id string) (*Product, error)
+	GetBySKUUnscoped(sku string) (*Product, error)
+	GetDeleted(limit, offset int32) ([]*Product, error)
+	Restore(id string) (*Product, error)
+	PurgeDeleted(deletedBefore time.Time) (int64, error)
 }
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultWarehouseID holds stock that has not been assigned to a specific
+// warehouse, including stock recorded before warehouses existed.
+const DefaultWarehouseID = "default"
+
+// Allocation strategies used when a stock operation does not name a warehouse.
+const (
+	AllocateNearest   = "nearest"
+	AllocateMostStock = "most_stock"
+	AllocateSplit     = "split"
+)
+
+// DefaultReservationTTL is how long reserved stock is held when a
+// reservation does not say.
+const DefaultReservationTTL = 15 * time.Minute
+
+var (
+	ErrInsufficientStock   = errors.New("insufficient stock")
+	ErrWarehouseNotFound   = errors.New("warehouse not found")
+	ErrInvalidStrategy     = errors.New("invalid allocation strategy")
+	ErrInvalidQuantity     = errors.New("quantity must be positive")
+	ErrReservationNotFound = errors.New("reservation not found")
+)
+
+type Warehouse struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	Name      string    `json:"name" gorm:"type:varchar(255);not null"`
+	Region    string    `json:"region" gorm:"type:varchar(100)"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// WarehouseStock is the stock of one product in one warehouse. Product.Stock
+// is kept equal to the sum of Quantity over all warehouses.
+type WarehouseStock struct {
+	ProductID   string    `json:"product_id" gorm:"primaryKey;type:varchar(255)"`
+	WarehouseID string    `json:"warehouse_id" gorm:"primaryKey;type:varchar(255)"`
+	Quantity    int32     `json:"quantity" gorm:"type:int;not null;default:0"`
+	Reserved    int32     `json:"reserved" gorm:"type:int;not null;default:0"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Available returns the quantity that is neither sold nor reserved.
+func (s *WarehouseStock) Available() int32 {
+	return s.Quantity - s.Reserved
+}
+
+// StockTransfer records stock moved between two warehouses.
+type StockTransfer struct {
+	ID              string    `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	ProductID       string    `json:"product_id" gorm:"type:varchar(255);not null;index"`
+	FromWarehouseID string    `json:"from_warehouse_id" gorm:"type:varchar(255);not null"`
+	ToWarehouseID   string    `json:"to_warehouse_id" gorm:"type:varchar(255);not null"`
+	Quantity        int32     `json:"quantity" gorm:"type:int;not null"`
+	CreatedAt       time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// StockReservation is the part of a reservation held in one warehouse. It
+// is counted in WarehouseStock.Reserved until the reservation is committed,
+// released or expires.
+type StockReservation struct {
+	ID          string    `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	WarehouseID string    `json:"warehouse_id" gorm:"primaryKey;type:varchar(255)"`
+	ProductID   string    `json:"product_id" gorm:"type:varchar(255);not null"`
+	Quantity    int32     `json:"quantity" gorm:"type:int;not null"`
+	ExpiresAt   time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// Reservation is stock of a product held for a pending order, possibly
+// across several warehouses.
+type Reservation struct {
+	ID          string
+	ProductID   string
+	Allocations []StockAllocation
+	ExpiresAt   time.Time
+}
+
+// StockAllocation is the part of a stock operation served by one warehouse.
+type StockAllocation struct {
+	WarehouseID string `json:"warehouse_id"`
+	Quantity    int32  `json:"quantity"`
+}
+
+// AllocationTarget selects where a stock operation applies. WarehouseID wins
+// over Strategy; Latitude and Longitude are only used by AllocateNearest.
+type AllocationTarget struct {
+	WarehouseID string
+	Strategy    string
+	Latitude    float64
+	Longitude   float64
+}
+
+type InventoryRepository interface {
+	EnsureDefaultWarehouse() error
+	CreateWarehouse(warehouse *Warehouse) error
+	GetWarehouses() ([]*Warehouse, error)
+	GetStockLevels(productID string) ([]*WarehouseStock, error)
+	// GetStockLevelsByProduct returns the stock levels of several products,
+	// keyed by product ID.
+	GetStockLevelsByProduct(productIDs []string) (map[string][]*WarehouseStock, error)
+	AdjustStock(productID string, quantity int32, operation string, target AllocationTarget) (*Product, []StockAllocation, error)
+	// Reserve holds stock until the reservation is committed or released,
+	// or ttl has passed.
+	Reserve(productID string, quantity int32, target AllocationTarget, ttl time.Duration) (*Reservation, error)
+	Release(reservationID string) error
+	// Commit turns reserved stock into sold stock and returns the product
+	// with the quantity committed.
+	Commit(reservationID string) (*Product, int32, error)
+	// ReleaseExpired releases the reservations that expired before the
+	// given time and returns how many it released.
+	ReleaseExpired(before time.Time) (int, error)
+	Transfer(productID, fromWarehouseID, toWarehouseID string, quantity int32) (*StockTransfer, error)
+}
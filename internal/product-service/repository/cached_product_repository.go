@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"daprps/internal/product-service/model"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheOptions configures CachedProductRepository.
+type CacheOptions struct {
+	// Size is the maximum number of products kept in process.
+	Size int
+	// TTL bounds how long a product is served from the in-process cache.
+	TTL time.Duration
+	// Redis enables a shared second tier when set.
+	Redis *redis.Client
+	// RedisTTL bounds how long a product is kept in Redis.
+	RedisTTL time.Duration
+}
+
+// productGenerationTTL is how long the Redis generation of a product is kept
+// after its last invalidation. It must outlast any load of the product.
+const productGenerationTTL = 24 * time.Hour
+
+// cacheSetScript stores a product in Redis unless it was invalidated since
+// the load began, which bumped its generation.
+var cacheSetScript = redis.NewScript(`
+if (redis.call("GET", KEYS[2]) or "0") ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// CachedProductRepository is a read-through cache for GetByID. Writes made
+// through it invalidate the affected products; writes made elsewhere must be
+// reported with Invalidate.
+type CachedProductRepository struct {
+	model.ProductRepository
+	opts  CacheOptions
+	group singleflight.Group
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	// generation is bumped on every invalidation so that loads which started
+	// before a write do not store stale data afterwards.
+	generation uint64
+}
+
+type cacheEntry struct {
+	id        string
+	product   model.Product
+	expiresAt time.Time
+}
+
+func NewCachedProductRepository(repo model.ProductRepository, opts CacheOptions) *CachedProductRepository {
+	return &CachedProductRepository{
+		ProductRepository: repo,
+		opts:              opts,
+		items:             make(map[string]*list.Element),
+		order:             list.New(),
+	}
+}
+
+func (r *CachedProductRepository) GetByID(id string) (*model.Product, error) {
+	if product, ok := r.getLocal(id); ok {
+		return product, nil
+	}
+
+	v, err, _ := r.group.Do(id, func() (interface{}, error) {
+		generation := r.currentGeneration()
+		redisGeneration, redisOK := r.redisGeneration(id)
+
+		if product, ok := r.getRedis(id); ok {
+			r.setLocal(product, generation)
+			return product, nil
+		}
+
+		product, err := r.ProductRepository.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		r.setLocal(product, generation)
+		if redisOK {
+			r.setRedis(product, generation, redisGeneration)
+		}
+		return product, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers get their own copy so they cannot modify the cached value
+	product := *v.(*model.Product)
+	return &product, nil
+}
+
+func (r *CachedProductRepository) UpdateStock(id string, quantity int32, operation string) (*model.Product, error) {
+	product, err := r.ProductRepository.UpdateStock(id, quantity, operation)
+	r.Invalidate(id)
+	return product, err
+}
+
+func (r *CachedProductRepository) Create(product *model.Product) error {
+	err := r.ProductRepository.Create(product)
+	r.Invalidate(product.ID)
+	return err
+}
+
+func (r *CachedProductRepository) Update(product *model.Product) error {
+	err := r.ProductRepository.Update(product)
+	r.Invalidate(product.ID)
+	return err
+}
+
+func (r *CachedProductRepository) Delete(id string) error {
+	err := r.ProductRepository.Delete(id)
+	r.Invalidate(id)
+	return err
+}
+
+func (r *CachedProductRepository) UpsertBatch(products []*model.Product) error {
+	err := r.ProductRepository.UpsertBatch(products)
+	for _, product := range products {
+		r.Invalidate(product.ID)
+	}
+	return err
+}
+
+func (r *CachedProductRepository) Restore(id string) (*model.Product, error) {
+	product, err := r.ProductRepository.Restore(id)
+	r.Invalidate(id)
+	return product, err
+}
+
+// Invalidate drops a product from both cache tiers. Its Redis generation is
+// bumped as well, so loads on any replica that began before do not store it
+// again.
+func (r *CachedProductRepository) Invalidate(id string) {
+	r.mu.Lock()
+	r.generation++
+	if elem, ok := r.items[id]; ok {
+		r.order.Remove(elem)
+		delete(r.items, id)
+	}
+	r.mu.Unlock()
+
+	if r.opts.Redis != nil {
+		ctx := context.Background()
+		_, err := r.opts.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Incr(ctx, productGenerationKey(id))
+			pipe.Expire(ctx, productGenerationKey(id), productGenerationTTL)
+			pipe.Del(ctx, productCacheKey(id))
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to invalidate cached product %s: %v", id, err)
+		}
+	}
+}
+
+func (r *CachedProductRepository) currentGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+func (r *CachedProductRepository) getLocal(id string) (*model.Product, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.items[id]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		r.order.Remove(elem)
+		delete(r.items, id)
+		return nil, false
+	}
+
+	r.order.MoveToFront(elem)
+	product := entry.product
+	return &product, true
+}
+
+func (r *CachedProductRepository) setLocal(product *model.Product, generation uint64) {
+	if r.opts.Size <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if generation != r.generation {
+		return
+	}
+
+	entry := &cacheEntry{id: product.ID, product: *product, expiresAt: time.Now().Add(r.opts.TTL)}
+	if elem, ok := r.items[product.ID]; ok {
+		elem.Value = entry
+		r.order.MoveToFront(elem)
+		return
+	}
+
+	r.items[product.ID] = r.order.PushFront(entry)
+	for r.order.Len() > r.opts.Size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.items, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+func (r *CachedProductRepository) getRedis(id string) (*model.Product, bool) {
+	if r.opts.Redis == nil {
+		return nil, false
+	}
+
+	data, err := r.opts.Redis.Get(context.Background(), productCacheKey(id)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Failed to read cached product %s: %v", id, err)
+		}
+		return nil, false
+	}
+
+	var product model.Product
+	if err := json.Unmarshal(data, &product); err != nil {
+		log.Printf("Failed to decode cached product %s: %v", id, err)
+		return nil, false
+	}
+	return &product, true
+}
+
+// redisGeneration reads the Redis generation of a product before it is
+// loaded. ok is false if it cannot be read, in which case the load must not
+// be stored in Redis.
+func (r *CachedProductRepository) redisGeneration(id string) (generation string, ok bool) {
+	if r.opts.Redis == nil {
+		return "", false
+	}
+
+	generation, err := r.opts.Redis.Get(context.Background(), productGenerationKey(id)).Result()
+	switch {
+	case err == redis.Nil:
+		return "0", true
+	case err != nil:
+		log.Printf("Failed to read cache generation of product %s: %v", id, err)
+		return "", false
+	}
+	return generation, true
+}
+
+// setRedis stores a loaded product in Redis unless it was invalidated, here
+// or on another replica, since the load began.
+func (r *CachedProductRepository) setRedis(product *model.Product, generation uint64, redisGeneration string) {
+	if r.currentGeneration() != generation {
+		return
+	}
+
+	data, err := json.Marshal(product)
+	if err != nil {
+		log.Printf("Failed to encode product %s for cache: %v", product.ID, err)
+		return
+	}
+	keys := []string{productCacheKey(product.ID), productGenerationKey(product.ID)}
+	err = cacheSetScript.Run(context.Background(), r.opts.Redis, keys, redisGeneration, data, r.opts.RedisTTL.Milliseconds()).Err()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to cache product %s: %v", product.ID, err)
+	}
+}
+
+func productCacheKey(id string) string {
+	return fmt.Sprintf("product:%s", id)
+}
+
+func productGenerationKey(id string) string {
+	return fmt.Sprintf("product:%s:generation", id)
+}
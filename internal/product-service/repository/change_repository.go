@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"daprps/internal/product-service/model"
+
+	"gorm.io/gorm"
+)
+
+type ChangeRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewChangeRepository(db *gorm.DB) model.ChangeRepository {
+	return &ChangeRepositoryImpl{db: db}
+}
+
+// changeLogLock is the key of the advisory lock that orders the inserts into
+// the change log.
+const changeLogLock = 0x70726f64
+
+// recordChanges appends changes of the given products to the log in the
+// transaction that made them. It must be the last statement of tx: the
+// change log lock is held until tx ends, so changes become visible in ID
+// order and an ID that is missing belongs to a transaction that rolled back.
+func recordChanges(tx *gorm.DB, deleted bool, productIDs ...string) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", changeLogLock).Error; err != nil {
+		return fmt.Errorf("error locking product change log: %w", err)
+	}
+	for _, productID := range productIDs {
+		if err := tx.Create(&model.ProductChange{ProductID: productID, Deleted: deleted}).Error; err != nil {
+			return fmt.Errorf("error recording product change: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *ChangeRepositoryImpl) Since(id int64, limit int) ([]model.ProductChange, error) {
+	var changes []model.ProductChange
+	err := r.db.Where("id > ?", id).Order("id").Limit(limit).Find(&changes).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting product changes: %w", err)
+	}
+	return changes, nil
+}
+
+func (r *ChangeRepositoryImpl) Latest() (int64, error) {
+	var id int64
+	err := r.db.Model(&model.ProductChange{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+	if err != nil {
+		return 0, fmt.Errorf("error getting latest product change: %w", err)
+	}
+	return id, nil
+}
+
+func (r *ChangeRepositoryImpl) Prune(before time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", before).Delete(&model.ProductChange{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error pruning product changes: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,560 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"daprps/internal/product-service/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InventoryRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewInventoryRepository(db *gorm.DB) model.InventoryRepository {
+	return &InventoryRepositoryImpl{db: db}
+}
+
+func (r *InventoryRepositoryImpl) EnsureDefaultWarehouse() error {
+	warehouse := model.Warehouse{ID: model.DefaultWarehouseID, Name: "Default warehouse"}
+	return r.db.Where(model.Warehouse{ID: model.DefaultWarehouseID}).FirstOrCreate(&warehouse).Error
+}
+
+func (r *InventoryRepositoryImpl) CreateWarehouse(warehouse *model.Warehouse) error {
+	return r.db.Create(warehouse).Error
+}
+
+func (r *InventoryRepositoryImpl) GetWarehouses() ([]*model.Warehouse, error) {
+	var warehouses []*model.Warehouse
+	err := r.db.Order("name").Find(&warehouses).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting warehouses: %w", err)
+	}
+	return warehouses, nil
+}
+
+func (r *InventoryRepositoryImpl) GetStockLevels(productID string) ([]*model.WarehouseStock, error) {
+	var levels []*model.WarehouseStock
+	err := r.db.Where("product_id = ?", productID).Order("warehouse_id").Find(&levels).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting stock levels: %w", err)
+	}
+	return levels, nil
+}
+
+func (r *InventoryRepositoryImpl) GetStockLevelsByProduct(productIDs []string) (map[string][]*model.WarehouseStock, error) {
+	levelsByProduct := make(map[string][]*model.WarehouseStock, len(productIDs))
+	if len(productIDs) == 0 {
+		return levelsByProduct, nil
+	}
+	var levels []*model.WarehouseStock
+	err := r.db.Where("product_id IN ?", productIDs).Order("product_id, warehouse_id").Find(&levels).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting stock levels: %w", err)
+	}
+	for _, level := range levels {
+		levelsByProduct[level.ProductID] = append(levelsByProduct[level.ProductID], level)
+	}
+	return levelsByProduct, nil
+}
+
+func (r *InventoryRepositoryImpl) AdjustStock(productID string, quantity int32, operation string, target model.AllocationTarget) (*model.Product, []model.StockAllocation, error) {
+	if quantity <= 0 {
+		return nil, nil, model.ErrInvalidQuantity
+	}
+
+	var product *model.Product
+	var allocations []model.StockAllocation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		product, allocations, err = adjustStock(tx, productID, quantity, operation, target)
+		if err != nil {
+			return err
+		}
+		return recordChanges(tx, false, productID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return product, allocations, nil
+}
+
+func (r *InventoryRepositoryImpl) Reserve(productID string, quantity int32, target model.AllocationTarget, ttl time.Duration) (*model.Reservation, error) {
+	if quantity <= 0 {
+		return nil, model.ErrInvalidQuantity
+	}
+
+	reservation := &model.Reservation{
+		ID:        fmt.Sprintf("rsv_%d", time.Now().UnixNano()),
+		ProductID: productID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		_, levels, err := lockProductStock(tx, productID)
+		if err != nil {
+			return err
+		}
+
+		reservation.Allocations, err = allocate(tx, levels, quantity, target)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range reservation.Allocations {
+			level := findLevel(levels, a.WarehouseID)
+			level.Reserved += a.Quantity
+			if err := tx.Save(level).Error; err != nil {
+				return fmt.Errorf("error reserving stock: %w", err)
+			}
+			held := &model.StockReservation{
+				ID:          reservation.ID,
+				WarehouseID: a.WarehouseID,
+				ProductID:   productID,
+				Quantity:    a.Quantity,
+				ExpiresAt:   reservation.ExpiresAt,
+			}
+			if err := tx.Create(held).Error; err != nil {
+				return fmt.Errorf("error recording reservation: %w", err)
+			}
+		}
+		return recordChanges(tx, false, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (r *InventoryRepositoryImpl) Release(reservationID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		_, levels, held, err := lockReservation(tx, reservationID)
+		if err != nil {
+			return err
+		}
+		if err := releaseReservation(tx, levels, held); err != nil {
+			return err
+		}
+		return recordChanges(tx, false, held[0].ProductID)
+	})
+}
+
+// Commit removes the reserved units from both the reserved and the total
+// stock of their warehouses and updates the product total.
+func (r *InventoryRepositoryImpl) Commit(reservationID string) (*model.Product, int32, error) {
+	var product *model.Product
+	var committed int32
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var levels []*model.WarehouseStock
+		var held []*model.StockReservation
+		var err error
+		product, levels, held, err = lockReservation(tx, reservationID)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range held {
+			level := findLevel(levels, h.WarehouseID)
+			if level == nil || level.Reserved < h.Quantity {
+				return fmt.Errorf("cannot commit %d units of %s in warehouse %s: not reserved", h.Quantity, h.ProductID, h.WarehouseID)
+			}
+			level.Reserved -= h.Quantity
+			level.Quantity -= h.Quantity
+			committed += h.Quantity
+			if err := tx.Save(level).Error; err != nil {
+				return fmt.Errorf("error committing stock: %w", err)
+			}
+		}
+		if err := tx.Where("id = ?", reservationID).Delete(&model.StockReservation{}).Error; err != nil {
+			return fmt.Errorf("error deleting reservation: %w", err)
+		}
+
+		product.Stock = 0
+		for _, level := range levels {
+			product.Stock += level.Quantity
+		}
+		product.UpdatedAt = time.Now()
+		if err := tx.Save(product).Error; err != nil {
+			return fmt.Errorf("error updating product: %w", err)
+		}
+		return recordChanges(tx, false, product.ID)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return product, committed, nil
+}
+
+// ReleaseExpired releases expired reservations one at a time, so that a
+// reservation committed or released meanwhile is skipped.
+func (r *InventoryRepositoryImpl) ReleaseExpired(before time.Time) (int, error) {
+	var ids []string
+	err := r.db.Model(&model.StockReservation{}).
+		Where("expires_at < ?", before).
+		Distinct("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired reservations: %w", err)
+	}
+
+	released := 0
+	for _, id := range ids {
+		err := r.Release(id)
+		if errors.Is(err, model.ErrReservationNotFound) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// Transfer moves stock between warehouses and records the transfer in the
+// same transaction. The product's total stock does not change.
+func (r *InventoryRepositoryImpl) Transfer(productID, fromWarehouseID, toWarehouseID string, quantity int32) (*model.StockTransfer, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("quantity must be positive")
+	}
+	if fromWarehouseID == toWarehouseID {
+		return nil, fmt.Errorf("source and destination warehouse must differ")
+	}
+
+	transfer := &model.StockTransfer{
+		ID:              fmt.Sprintf("trf_%d", time.Now().UnixNano()),
+		ProductID:       productID,
+		FromWarehouseID: fromWarehouseID,
+		ToWarehouseID:   toWarehouseID,
+		Quantity:        quantity,
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		_, levels, err := lockProductStock(tx, productID)
+		if err != nil {
+			return err
+		}
+
+		from := findLevel(levels, fromWarehouseID)
+		if from == nil || from.Available() < quantity {
+			return model.ErrInsufficientStock
+		}
+		if err := checkWarehouse(tx, toWarehouseID); err != nil {
+			return err
+		}
+
+		to := findLevel(levels, toWarehouseID)
+		if to == nil {
+			to = &model.WarehouseStock{ProductID: productID, WarehouseID: toWarehouseID}
+		}
+
+		from.Quantity -= quantity
+		to.Quantity += quantity
+		for _, level := range []*model.WarehouseStock{from, to} {
+			if err := tx.Save(level).Error; err != nil {
+				return fmt.Errorf("error updating stock level: %w", err)
+			}
+		}
+
+		if err := tx.Create(transfer).Error; err != nil {
+			return fmt.Errorf("error recording transfer: %w", err)
+		}
+		return recordChanges(tx, false, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// lockReservation locks a reservation together with the stock of its
+// product.
+func lockReservation(tx *gorm.DB, reservationID string) (*model.Product, []*model.WarehouseStock, []*model.StockReservation, error) {
+	var held []*model.StockReservation
+	if err := tx.Where("id = ?", reservationID).Find(&held).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("error getting reservation: %w", err)
+	}
+	if len(held) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: %s", model.ErrReservationNotFound, reservationID)
+	}
+
+	product, levels, err := lockProductStock(tx, held[0].ProductID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Read again under the product lock; a concurrent commit or release may
+	// have taken the reservation
+	held = nil
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", reservationID).Find(&held).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("error getting reservation: %w", err)
+	}
+	if len(held) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: %s", model.ErrReservationNotFound, reservationID)
+	}
+	return product, levels, held, nil
+}
+
+// releaseReservation returns reserved units to the available stock and
+// deletes the reservation.
+func releaseReservation(tx *gorm.DB, levels []*model.WarehouseStock, held []*model.StockReservation) error {
+	for _, h := range held {
+		level := findLevel(levels, h.WarehouseID)
+		if level == nil || level.Reserved < h.Quantity {
+			return fmt.Errorf("cannot release %d units of %s in warehouse %s: not reserved", h.Quantity, h.ProductID, h.WarehouseID)
+		}
+		level.Reserved -= h.Quantity
+		if err := tx.Save(level).Error; err != nil {
+			return fmt.Errorf("error releasing stock: %w", err)
+		}
+	}
+	if err := tx.Where("id = ?", held[0].ID).Delete(&model.StockReservation{}).Error; err != nil {
+		return fmt.Errorf("error deleting reservation: %w", err)
+	}
+	return nil
+}
+
+// adjustStock adds stock to one warehouse or removes it from the warehouses
+// chosen by target, then updates the product total.
+func adjustStock(tx *gorm.DB, productID string, quantity int32, operation string, target model.AllocationTarget) (*model.Product, []model.StockAllocation, error) {
+	product, levels, err := lockProductStock(tx, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var allocations []model.StockAllocation
+	switch operation {
+	case "add":
+		warehouseID := target.WarehouseID
+		if warehouseID == "" {
+			warehouseID = model.DefaultWarehouseID
+		} else if err := checkWarehouse(tx, warehouseID); err != nil {
+			return nil, nil, err
+		}
+
+		level := findLevel(levels, warehouseID)
+		if level == nil {
+			level = &model.WarehouseStock{ProductID: productID, WarehouseID: warehouseID}
+			levels = append(levels, level)
+		}
+		level.Quantity += quantity
+		allocations = []model.StockAllocation{{WarehouseID: warehouseID, Quantity: quantity}}
+	case "subtract":
+		allocations, err = allocate(tx, levels, quantity, target)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range allocations {
+			findLevel(levels, a.WarehouseID).Quantity -= a.Quantity
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid operation: %s", operation)
+	}
+
+	for _, a := range allocations {
+		if err := tx.Save(findLevel(levels, a.WarehouseID)).Error; err != nil {
+			return nil, nil, fmt.Errorf("error updating stock level: %w", err)
+		}
+	}
+
+	product.Stock = 0
+	for _, level := range levels {
+		product.Stock += level.Quantity
+	}
+	product.UpdatedAt = time.Now()
+
+	if err := tx.Save(product).Error; err != nil {
+		return nil, nil, fmt.Errorf("error updating product: %w", err)
+	}
+
+	return product, allocations, nil
+}
+
+// lockProductStock locks a product and its stock levels for the rest of the
+// transaction and reconciles them with the product total.
+func lockProductStock(tx *gorm.DB, productID string) (*model.Product, []*model.WarehouseStock, error) {
+	var product model.Product
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", productID).First(&product).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting product: %w", err)
+	}
+
+	levels, err := syncDefaultWarehouse(tx, &product)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &product, levels, nil
+}
+
+// syncDefaultWarehouse makes the stock levels of a product add up to
+// Product.Stock by absorbing any difference in the default warehouse. This
+// seeds stock recorded before warehouses existed and keeps imports, which
+// only know the total, consistent.
+func syncDefaultWarehouse(tx *gorm.DB, product *model.Product) ([]*model.WarehouseStock, error) {
+	var levels []*model.WarehouseStock
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ?", product.ID).
+		Order("warehouse_id").
+		Find(&levels).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting stock levels: %w", err)
+	}
+
+	var total int32
+	for _, level := range levels {
+		total += level.Quantity
+	}
+	if total == product.Stock {
+		return levels, nil
+	}
+
+	level := findLevel(levels, model.DefaultWarehouseID)
+	if level == nil {
+		level = &model.WarehouseStock{ProductID: product.ID, WarehouseID: model.DefaultWarehouseID}
+		levels = append(levels, level)
+	}
+	level.Quantity += product.Stock - total
+	if level.Quantity < level.Reserved {
+		return nil, fmt.Errorf("stock of %s is below the quantity held in warehouses: %w", product.ID, model.ErrInsufficientStock)
+	}
+
+	if err := tx.Save(level).Error; err != nil {
+		return nil, fmt.Errorf("error updating stock level: %w", err)
+	}
+	return levels, nil
+}
+
+// allocate picks the warehouses that serve quantity units.
+func allocate(tx *gorm.DB, levels []*model.WarehouseStock, quantity int32, target model.AllocationTarget) ([]model.StockAllocation, error) {
+	if target.WarehouseID != "" {
+		level := findLevel(levels, target.WarehouseID)
+		if level == nil {
+			if err := checkWarehouse(tx, target.WarehouseID); err != nil {
+				return nil, err
+			}
+			return nil, model.ErrInsufficientStock
+		}
+		if level.Available() < quantity {
+			return nil, model.ErrInsufficientStock
+		}
+		return []model.StockAllocation{{WarehouseID: level.WarehouseID, Quantity: quantity}}, nil
+	}
+
+	candidates := make([]*model.WarehouseStock, 0, len(levels))
+	for _, level := range levels {
+		if level.Available() > 0 {
+			candidates = append(candidates, level)
+		}
+	}
+
+	switch target.Strategy {
+	case model.AllocateNearest:
+		distances, err := warehouseDistances(tx, candidates, target.Latitude, target.Longitude)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return distances[candidates[i].WarehouseID] < distances[candidates[j].WarehouseID]
+		})
+		for _, level := range candidates {
+			if level.Available() >= quantity {
+				return []model.StockAllocation{{WarehouseID: level.WarehouseID, Quantity: quantity}}, nil
+			}
+		}
+		return nil, model.ErrInsufficientStock
+	case model.AllocateMostStock:
+		sortByAvailable(candidates)
+		if len(candidates) == 0 || candidates[0].Available() < quantity {
+			return nil, model.ErrInsufficientStock
+		}
+		return []model.StockAllocation{{WarehouseID: candidates[0].WarehouseID, Quantity: quantity}}, nil
+	case "", model.AllocateSplit:
+		sortByAvailable(candidates)
+		var allocations []model.StockAllocation
+		remaining := quantity
+		for _, level := range candidates {
+			if remaining == 0 {
+				break
+			}
+			take := level.Available()
+			if take > remaining {
+				take = remaining
+			}
+			allocations = append(allocations, model.StockAllocation{WarehouseID: level.WarehouseID, Quantity: take})
+			remaining -= take
+		}
+		if remaining > 0 {
+			return nil, model.ErrInsufficientStock
+		}
+		return allocations, nil
+	}
+	return nil, fmt.Errorf("%w: %s", model.ErrInvalidStrategy, target.Strategy)
+}
+
+func sortByAvailable(levels []*model.WarehouseStock) {
+	sort.SliceStable(levels, func(i, j int) bool {
+		return levels[i].Available() > levels[j].Available()
+	})
+}
+
+// warehouseDistances returns the great-circle distance in kilometres from the
+// given point to each warehouse holding stock.
+func warehouseDistances(tx *gorm.DB, levels []*model.WarehouseStock, lat, lon float64) (map[string]float64, error) {
+	ids := make([]string, 0, len(levels))
+	for _, level := range levels {
+		ids = append(ids, level.WarehouseID)
+	}
+
+	var warehouses []*model.Warehouse
+	if len(ids) > 0 {
+		if err := tx.Where("id IN ?", ids).Find(&warehouses).Error; err != nil {
+			return nil, fmt.Errorf("error getting warehouses: %w", err)
+		}
+	}
+
+	distances := make(map[string]float64, len(ids))
+	for _, id := range ids {
+		distances[id] = math.Inf(1)
+	}
+	for _, w := range warehouses {
+		distances[w.ID] = haversine(lat, lon, w.Latitude, w.Longitude)
+	}
+	return distances, nil
+}
+
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func checkWarehouse(tx *gorm.DB, warehouseID string) error {
+	var warehouse model.Warehouse
+	err := tx.Where("id = ?", warehouseID).First(&warehouse).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: %s", model.ErrWarehouseNotFound, warehouseID)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting warehouse: %w", err)
+	}
+	return nil
+}
+
+func findLevel(levels []*model.WarehouseStock, warehouseID string) *model.WarehouseStock {
+	for _, level := range levels {
+		if level.WarehouseID == warehouseID {
+			return level
+		}
+	}
+	return nil
+}
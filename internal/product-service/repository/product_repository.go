@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -26,6 +27,18 @@ func (r *ProductRepositoryImpl) GetByID(id string) (*model.Product, error) {
 	return &product, nil
 }
 
+func (r *ProductRepositoryImpl) GetByIDs(ids []string) ([]*model.Product, error) {
+	var products []*model.Product
+	if len(ids) == 0 {
+		return products, nil
+	}
+	err := r.db.Where("id IN ?", ids).Find(&products).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting products by ID: %w", err)
+	}
+	return products, nil
+}
+
 func (r *ProductRepositoryImpl) GetAll(category string, limit, offset int32) ([]*model.Product, error) {
 	var products []*model.Product
 	query := r.db.Order("created_at DESC")
@@ -42,65 +55,150 @@ func (r *ProductRepositoryImpl) GetAll(category string, limit, offset int32) ([]
 	return products, nil
 }
 
+// UpdateStock adds stock to the default warehouse or removes it from
+// whichever warehouses hold it.
 func (r *ProductRepositoryImpl) UpdateStock(id string, quantity int32, operation string) (*model.Product, error) {
-	tx := r.db.Begin()
-	if tx.Error != nil {
-		return nil, fmt.Errorf("error starting transaction: %w", tx.Error)
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	var product *model.Product
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		product, _, err = adjustStock(tx, id, quantity, operation, model.AllocationTarget{})
+		if err != nil {
+			return err
 		}
-	}()
-
-	// Get current product
-	var product model.Product
-	err := tx.Where("id = ?", id).First(&product).Error
+		return recordChanges(tx, false, product.ID)
+	})
 	if err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("error getting product: %w", err)
+		return nil, err
 	}
+	return product, nil
+}
 
-	// Calculate new stock
-	switch operation {
-	case "add":
-		product.Stock += quantity
-	case "subtract":
-		product.Stock -= quantity
-		if product.Stock < 0 {
-			tx.Rollback()
-			return nil, fmt.Errorf("insufficient stock")
+func (r *ProductRepositoryImpl) Create(product *model.Product) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(product).Error; err != nil {
+			return err
 		}
-	default:
-		tx.Rollback()
-		return nil, fmt.Errorf("invalid operation: %s", operation)
-	}
+		if _, err := syncDefaultWarehouse(tx, product); err != nil {
+			return err
+		}
+		return recordChanges(tx, false, product.ID)
+	})
+}
 
+func (r *ProductRepositoryImpl) Update(product *model.Product) error {
 	product.UpdatedAt = time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(product).Error; err != nil {
+			return err
+		}
+		if _, err := syncDefaultWarehouse(tx, product); err != nil {
+			return err
+		}
+		return recordChanges(tx, false, product.ID)
+	})
+}
+
+func (r *ProductRepositoryImpl) Delete(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).Delete(&model.Product{}).Error; err != nil {
+			return err
+		}
+		return recordChanges(tx, true, id)
+	})
+}
 
-	// Update product
-	err = tx.Save(&product).Error
+// GetByIDUnscoped returns a product even if it has been soft-deleted.
+func (r *ProductRepositoryImpl) GetByIDUnscoped(id string) (*model.Product, error) {
+	var product model.Product
+	err := r.db.Unscoped().Where("id = ?", id).First(&product).Error
 	if err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("error updating product: %w", err)
+		return nil, fmt.Errorf("error getting product by ID: %w", err)
 	}
+	return &product, nil
+}
 
-	if err = tx.Commit().Error; err != nil {
-		return nil, fmt.Errorf("error committing transaction: %w", err)
+// GetBySKUUnscoped returns the product with a SKU even if it has been
+// soft-deleted.
+func (r *ProductRepositoryImpl) GetBySKUUnscoped(sku string) (*model.Product, error) {
+	var product model.Product
+	err := r.db.Unscoped().Where("sku = ?", sku).First(&product).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting product by SKU: %w", err)
 	}
-
 	return &product, nil
 }
 
-func (r *ProductRepositoryImpl) Create(product *model.Product) error {
-	return r.db.Create(product).Error
+func (r *ProductRepositoryImpl) GetDeleted(limit, offset int32) ([]*model.Product, error) {
+	var products []*model.Product
+	err := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC").
+		Limit(int(limit)).Offset(int(offset)).
+		Find(&products).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting deleted products: %w", err)
+	}
+	return products, nil
 }
 
-func (r *ProductRepositoryImpl) Update(product *model.Product) error {
-	product.UpdatedAt = time.Now()
-	return r.db.Save(product).Error
+func (r *ProductRepositoryImpl) Restore(id string) (*model.Product, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&model.Product{}).
+			Where("id = ? AND deleted_at IS NOT NULL", id).
+			Updates(map[string]interface{}{"deleted_at": nil, "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return recordChanges(tx, false, id)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error restoring product: %w", err)
+	}
+	return r.GetByID(id)
 }
 
-func (r *ProductRepositoryImpl) Delete(id string) error {
-	return r.db.Where("id = ?", id).Delete(&model.Product{}).Error
+// PurgeDeleted permanently removes products soft-deleted before the given time.
+func (r *ProductRepositoryImpl) PurgeDeleted(deletedBefore time.Time) (int64, error) {
+	result := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Delete(&model.Product{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error purging deleted products: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// UpsertBatch creates or updates every product in a single transaction.
+// Existing rows keep their original creation time.
+func (r *ProductRepositoryImpl) UpsertBatch(products []*model.Product) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		ids := make([]string, 0, len(products))
+		for _, product := range products {
+			ids = append(ids, product.ID)
+			var existing model.Product
+			err := tx.Unscoped().Where("id = ?", product.ID).First(&existing).Error
+			switch {
+			case err == nil:
+				product.CreatedAt = existing.CreatedAt
+				product.UpdatedAt = time.Now()
+				if err := tx.Unscoped().Save(product).Error; err != nil {
+					return fmt.Errorf("error updating product %s: %w", product.ID, err)
+				}
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				if err := tx.Create(product).Error; err != nil {
+					return fmt.Errorf("error creating product %s: %w", product.ID, err)
+				}
+			default:
+				return fmt.Errorf("error getting product %s: %w", product.ID, err)
+			}
+
+			if _, err := syncDefaultWarehouse(tx, product); err != nil {
+				return err
+			}
+		}
+		return recordChanges(tx, false, ids...)
+	})
 }
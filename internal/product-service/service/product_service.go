@@ -2,56 +2,162 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"daprps/api/proto/events"
 	productpb "daprps/api/proto/product"
+	"daprps/internal/money"
 	"daprps/internal/product-service/model"
+	"daprps/kafka/publisher"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
 )
 
+// DefaultPurgeRetention is how long soft-deleted products are kept when a
+// purge does not specify a retention period.
+const DefaultPurgeRetention = 30 * 24 * time.Hour
+
 type ProductService struct {
 	productpb.UnimplementedProductServiceServer
-	repo model.ProductRepository
+	repo      model.ProductRepository
+	inventory model.InventoryRepository
+	publisher *publisher.ProductPublisher
+	watcher   *ProductWatcher
 }
 
-func NewProductService(repo model.ProductRepository) *ProductService {
+func NewProductService(repo model.ProductRepository, inventory model.InventoryRepository, changes model.ChangeRepository, publisher *publisher.ProductPublisher) *ProductService {
 	return &ProductService{
-		repo: repo,
+		repo:      repo,
+		inventory: inventory,
+		publisher: publisher,
+		watcher:   NewProductWatcher(changes),
 	}
 }
 
+// RunWatcher delivers product changes to watches until ctx is done.
+func (s *ProductService) RunWatcher(ctx context.Context) {
+	s.watcher.Run(ctx)
+}
+
 func (s *ProductService) GetProduct(ctx context.Context, req *productpb.GetProductRequest) (*productpb.GetProductResponse, error) {
-	product, err := s.repo.GetByID(req.ProductId)
+	getProduct := s.repo.GetByID
+	if req.IncludeDeleted {
+		getProduct = s.repo.GetByIDUnscoped
+	}
+
+	product, err := getProduct(req.ProductId)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "product not found: %v", err)
 	}
+	return s.productWithStock(product)
+}
+
+// maxBatchProducts bounds the number of products GetProducts looks up.
+const maxBatchProducts = 500
+
+// GetProducts looks up several products in one call. Unknown and deleted
+// products are reported in not_found instead of failing the call.
+func (s *ProductService) GetProducts(ctx context.Context, req *productpb.GetProductsRequest) (*productpb.GetProductsResponse, error) {
+	if len(req.ProductIds) > maxBatchProducts {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d products can be looked up at once", maxBatchProducts)
+	}
+
+	var ids []string
+	seen := make(map[string]bool, len(req.ProductIds))
+	for _, id := range req.ProductIds {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	products, err := s.repo.GetByIDs(ids)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting products: %v", err)
+	}
+	levels, err := s.inventory.GetStockLevelsByProduct(ids)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting stock levels: %v", err)
+	}
+
+	byID := make(map[string]*model.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	// Products are returned in the order they were requested
+	resp := &productpb.GetProductsResponse{}
+	for _, id := range ids {
+		product, ok := byID[id]
+		if !ok {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		resp.Products = append(resp.Products, withStock(product, levels[id]))
+	}
+	return resp, nil
+}
+
+// productWithStock adds the stock of each warehouse to a product.
+func (s *ProductService) productWithStock(product *model.Product) (*productpb.GetProductResponse, error) {
+	levels, err := s.inventory.GetStockLevels(product.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting stock levels: %v", err)
+	}
+	return withStock(product, levels), nil
+}
+
+// withStock builds the response for a product with the given stock levels.
+func withStock(product *model.Product, levels []*model.WarehouseStock) *productpb.GetProductResponse {
+	// Products that never had a warehouse operation hold all stock in the default warehouse
+	if len(levels) == 0 && product.Stock > 0 {
+		levels = []*model.WarehouseStock{{ProductID: product.ID, WarehouseID: model.DefaultWarehouseID, Quantity: product.Stock}}
+	}
+
+	var available int32
+	var warehouses []*productpb.WarehouseStock
+	for _, level := range levels {
+		available += level.Available()
+		warehouses = append(warehouses, &productpb.WarehouseStock{
+			WarehouseId: level.WarehouseID,
+			Quantity:    level.Quantity,
+			Reserved:    level.Reserved,
+			Available:   level.Available(),
+		})
+	}
 
 	return &productpb.GetProductResponse{
-		Product: &productpb.Product{
-			Id:          product.ID,
-			Name:        product.Name,
-			Description: product.Description,
-			Price:       product.Price,
-			Stock:       product.Stock,
-			Category:    product.Category,
-		},
-	}, nil
+		Product:    convertProduct(product),
+		Available:  available,
+		Warehouses: warehouses,
+	}
 }
 
 func (s *ProductService) UpdateStock(ctx context.Context, req *productpb.UpdateStockRequest) (*productpb.UpdateStockResponse, error) {
-	updatedProduct, err := s.repo.UpdateStock(req.ProductId, req.Quantity, req.Operation)
+	target := model.AllocationTarget{
+		WarehouseID: req.WarehouseId,
+		Strategy:    req.Strategy,
+		Latitude:    req.Latitude,
+		Longitude:   req.Longitude,
+	}
+
+	updatedProduct, allocations, err := s.inventory.AdjustStock(req.ProductId, req.Quantity, req.Operation, target)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "error updating stock: %v", err)
+		return nil, stockError("error updating stock", err)
 	}
 
+	s.invalidate(updatedProduct.ID)
+	s.publishStockUpdated(ctx, updatedProduct, req.Quantity, req.Operation)
+
 	return &productpb.UpdateStockResponse{
-		Success:  true,
-		NewStock: updatedProduct.Stock,
+		Success:     true,
+		NewStock:    updatedProduct.Stock,
+		Allocations: convertAllocations(allocations),
 	}, nil
 }
 
@@ -63,14 +169,7 @@ func (s *ProductService) ListProducts(ctx context.Context, req *productpb.ListPr
 
 	var protoProducts []*productpb.Product
 	for _, p := range products {
-		protoProducts = append(protoProducts, &productpb.Product{
-			Id:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-			Stock:       p.Stock,
-			Category:    p.Category,
-		})
+		protoProducts = append(protoProducts, convertProduct(p))
 	}
 
 	return &productpb.ListProductsResponse{
@@ -78,8 +177,273 @@ func (s *ProductService) ListProducts(ctx context.Context, req *productpb.ListPr
 	}, nil
 }
 
+func (s *ProductService) WatchProducts(req *productpb.WatchProductsRequest, stream productpb.ProductService_WatchProductsServer) error {
+	return s.Watch(stream.Context(), req, stream.Send)
+}
+
+// Watch sends the current state of the requested products, or the changes
+// missed since req.SinceVersion, and then every further change until ctx is
+// done. It is shared by the gRPC stream and the HTTP event stream.
+func (s *ProductService) Watch(ctx context.Context, req *productpb.WatchProductsRequest, send func(*productpb.ProductEvent) error) error {
+	if len(req.ProductIds) == 0 && req.Category == "" {
+		return status.Errorf(codes.InvalidArgument, "product_ids or category is required")
+	}
+
+	w := newProductWatch(s.repo, req, send)
+
+	sub, version, missed, resumed := s.watcher.subscribe(req.SinceVersion)
+	defer s.watcher.unsubscribe(sub)
+
+	if resumed {
+		for _, change := range missed {
+			if err := w.sendChange(change); err != nil {
+				return err
+			}
+		}
+	} else if err := w.sendSnapshot(version); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-sub.changes:
+			if err := w.sendChange(change); err != nil {
+				return err
+			}
+		case <-sub.overflow:
+			// The client fell behind; replace the dropped changes with a fresh snapshot
+			version := s.watcher.drain(sub)
+			if err := w.sendSnapshot(version); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *ProductService) DeleteProduct(ctx context.Context, req *productpb.DeleteProductRequest) (*productpb.DeleteProductResponse, error) {
+	if _, err := s.repo.GetByID(req.ProductId); err != nil {
+		return nil, status.Errorf(codes.NotFound, "product not found: %v", err)
+	}
+
+	if err := s.repo.Delete(req.ProductId); err != nil {
+		return nil, status.Errorf(codes.Internal, "error deleting product: %v", err)
+	}
+
+	s.publishProductUpdated(ctx, req.ProductId, "deleted")
+
+	return &productpb.DeleteProductResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *ProductService) ListDeletedProducts(ctx context.Context, req *productpb.ListDeletedProductsRequest) (*productpb.ListDeletedProductsResponse, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	products, err := s.repo.GetDeleted(limit, req.Offset)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error listing deleted products: %v", err)
+	}
+
+	var protoProducts []*productpb.Product
+	for _, p := range products {
+		protoProducts = append(protoProducts, convertProduct(p))
+	}
+
+	return &productpb.ListDeletedProductsResponse{
+		Products: protoProducts,
+	}, nil
+}
+
+func (s *ProductService) RestoreProduct(ctx context.Context, req *productpb.RestoreProductRequest) (*productpb.RestoreProductResponse, error) {
+	product, err := s.repo.Restore(req.ProductId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.NotFound, "deleted product not found: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "error restoring product: %v", err)
+	}
+
+	s.publishProductUpdated(ctx, product.ID, "restored")
+
+	return &productpb.RestoreProductResponse{
+		Product: convertProduct(product),
+		Success: true,
+	}, nil
+}
+
+func (s *ProductService) PurgeDeletedProducts(ctx context.Context, req *productpb.PurgeDeletedProductsRequest) (*productpb.PurgeDeletedProductsResponse, error) {
+	if req.RetentionHours < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "retention_hours must not be negative")
+	}
+
+	retention := DefaultPurgeRetention
+	if req.RetentionHours > 0 {
+		retention = time.Duration(req.RetentionHours) * time.Hour
+	}
+
+	purged, err := s.repo.PurgeDeleted(time.Now().Add(-retention))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error purging products: %v", err)
+	}
+
+	log.Printf("Purged %d products deleted more than %s ago", purged, retention)
+
+	return &productpb.PurgeDeletedProductsResponse{
+		PurgedCount: purged,
+		Success:     true,
+	}, nil
+}
+
+func (s *ProductService) CreateWarehouse(ctx context.Context, req *productpb.CreateWarehouseRequest) (*productpb.CreateWarehouseResponse, error) {
+	if req.Warehouse == nil || req.Warehouse.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "warehouse name is required")
+	}
+
+	warehouse := &model.Warehouse{
+		ID:        req.Warehouse.Id,
+		Name:      req.Warehouse.Name,
+		Region:    req.Warehouse.Region,
+		Latitude:  req.Warehouse.Latitude,
+		Longitude: req.Warehouse.Longitude,
+	}
+	if warehouse.ID == "" {
+		warehouse.ID = fmt.Sprintf("wh_%d", time.Now().UnixNano())
+	}
+
+	if err := s.inventory.CreateWarehouse(warehouse); err != nil {
+		return nil, status.Errorf(codes.Internal, "error creating warehouse: %v", err)
+	}
+
+	return &productpb.CreateWarehouseResponse{
+		Warehouse: convertWarehouse(warehouse),
+		Success:   true,
+	}, nil
+}
+
+func (s *ProductService) ListWarehouses(ctx context.Context, req *productpb.ListWarehousesRequest) (*productpb.ListWarehousesResponse, error) {
+	warehouses, err := s.inventory.GetWarehouses()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error listing warehouses: %v", err)
+	}
+
+	var protoWarehouses []*productpb.Warehouse
+	for _, w := range warehouses {
+		protoWarehouses = append(protoWarehouses, convertWarehouse(w))
+	}
+
+	return &productpb.ListWarehousesResponse{
+		Warehouses: protoWarehouses,
+	}, nil
+}
+
+func (s *ProductService) ReserveStock(ctx context.Context, req *productpb.ReserveStockRequest) (*productpb.ReserveStockResponse, error) {
+	target := model.AllocationTarget{
+		WarehouseID: req.WarehouseId,
+		Strategy:    req.Strategy,
+		Latitude:    req.Latitude,
+		Longitude:   req.Longitude,
+	}
+	if req.TtlSeconds < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ttl_seconds must not be negative")
+	}
+	ttl := model.DefaultReservationTTL
+	if req.TtlSeconds > 0 {
+		ttl = time.Duration(req.TtlSeconds) * time.Second
+	}
+
+	reservation, err := s.inventory.Reserve(req.ProductId, req.Quantity, target, ttl)
+	if err != nil {
+		return nil, stockError("error reserving stock", err)
+	}
+	s.watcher.Wake()
+
+	return &productpb.ReserveStockResponse{
+		Allocations:   convertAllocations(reservation.Allocations),
+		Success:       true,
+		ReservationId: reservation.ID,
+		ExpiresAt:     reservation.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *ProductService) ReleaseStock(ctx context.Context, req *productpb.ReleaseStockRequest) (*productpb.ReleaseStockResponse, error) {
+	if req.ReservationId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "reservation_id is required")
+	}
+	if err := s.inventory.Release(req.ReservationId); err != nil {
+		return nil, stockError("error releasing stock", err)
+	}
+	s.watcher.Wake()
+
+	return &productpb.ReleaseStockResponse{
+		Success: true,
+	}, nil
+}
+
+// CommitStock turns a reservation into a sale: the reserved units leave the
+// warehouses' stock.
+func (s *ProductService) CommitStock(ctx context.Context, req *productpb.CommitStockRequest) (*productpb.CommitStockResponse, error) {
+	if req.ReservationId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "reservation_id is required")
+	}
+	product, quantity, err := s.inventory.Commit(req.ReservationId)
+	if err != nil {
+		return nil, stockError("error committing stock", err)
+	}
+
+	s.invalidate(product.ID)
+	s.publishStockUpdated(ctx, product, quantity, "subtract")
+
+	return &productpb.CommitStockResponse{
+		Success:  true,
+		NewStock: product.Stock,
+	}, nil
+}
+
+// RunReservationExpiry releases expired stock reservations every interval
+// until ctx is done.
+func (s *ProductService) RunReservationExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := s.inventory.ReleaseExpired(time.Now())
+			if err != nil {
+				log.Printf("Failed to release expired reservations: %v", err)
+			}
+			if released > 0 {
+				log.Printf("Released %d expired stock reservations", released)
+				s.watcher.Wake()
+			}
+		}
+	}
+}
+
+func (s *ProductService) TransferStock(ctx context.Context, req *productpb.TransferStockRequest) (*productpb.TransferStockResponse, error) {
+	transfer, err := s.inventory.Transfer(req.ProductId, req.FromWarehouseId, req.ToWarehouseId, req.Quantity)
+	if err != nil {
+		return nil, stockError("error transferring stock", err)
+	}
+	s.watcher.Wake()
+
+	log.Printf("Transferred %d units of %s from %s to %s", transfer.Quantity, transfer.ProductID, transfer.FromWarehouseID, transfer.ToWarehouseID)
+
+	return &productpb.TransferStockResponse{
+		TransferId: transfer.ID,
+		Success:    true,
+	}, nil
+}
+
 // Business logic methods
-func (s *ProductService) CreateProduct(ctx context.Context, name, description, category string, price float64, stock int32) (*model.Product, error) {
+func (s *ProductService) CreateProduct(ctx context.Context, name, description, category string, price money.Money, stock int32) (*model.Product, error) {
 	product := &model.Product{
 		ID:          generateID(),
 		Name:        name,
@@ -96,17 +460,64 @@ func (s *ProductService) CreateProduct(ctx context.Context, name, description, c
 		return nil, fmt.Errorf("error creating product: %w", err)
 	}
 
+	s.publishProductUpdated(ctx, product.ID, "created")
+
 	return product, nil
 }
 
 func (s *ProductService) DecreaseStock(ctx context.Context, productID string, quantity int32) error {
-	_, err := s.repo.UpdateStock(productID, quantity, "subtract")
+	product, err := s.repo.UpdateStock(productID, quantity, "subtract")
 	if err != nil {
 		return fmt.Errorf("error decreasing stock: %w", err)
 	}
+
+	s.publishStockUpdated(ctx, product, quantity, "subtract")
 	return nil
 }
 
+// publishStockUpdated wakes the watchers for a stock change and announces it
+// to the other replicas.
+func (s *ProductService) publishStockUpdated(ctx context.Context, product *model.Product, quantity int32, operation string) {
+	oldStock := product.Stock - quantity
+	if operation == "subtract" {
+		oldStock = product.Stock + quantity
+	}
+
+	event := &events.StockUpdatedEvent{
+		ProductId: product.ID,
+		OldStock:  oldStock,
+		NewStock:  product.Stock,
+		Operation: operation,
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	s.watcher.Wake()
+	if s.publisher == nil {
+		return
+	}
+	if err := s.publisher.PublishStockUpdated(ctx, event); err != nil {
+		log.Printf("Failed to publish stock updated event: %v", err)
+	}
+}
+
+// publishProductUpdated wakes the watchers for a product change and
+// announces it like publishStockUpdated.
+func (s *ProductService) publishProductUpdated(ctx context.Context, productID, operation string) {
+	event := &events.ProductUpdatedEvent{
+		ProductId: productID,
+		Operation: operation,
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	s.watcher.Wake()
+	if s.publisher == nil {
+		return
+	}
+	if err := s.publisher.PublishProductUpdated(ctx, event); err != nil {
+		log.Printf("Failed to publish product updated event: %v", err)
+	}
+}
+
 // Helper function to generate ID (in real app, use UUID)
 func generateID() string {
 	return fmt.Sprintf("prod_%d", time.Now().UnixNano())
@@ -122,8 +533,8 @@ func (s *ProductService) HandlePaymentCompleted(ctx context.Context, event *even
 	// 3. Publish stock updated events
 
 	// For now, we'll just log the event
-	log.Printf("Payment completed for order %s with amount %f %s",
-		event.OrderId, event.Amount, event.Currency)
+	log.Printf("Payment completed for order %s with amount %s",
+		event.OrderId, money.FromProto(event.Amount))
 
 	// TODO: Implement stock update logic when order service is available
 	// This would involve:
@@ -133,3 +544,82 @@ func (s *ProductService) HandlePaymentCompleted(ctx context.Context, event *even
 
 	return nil
 }
+
+// cacheInvalidator is implemented by repositories that cache products and
+// must hear about changes made by other replicas.
+type cacheInvalidator interface {
+	Invalidate(productID string)
+}
+
+// HandleStockUpdated implements ProductEventHandler interface
+func (s *ProductService) HandleStockUpdated(ctx context.Context, event *events.StockUpdatedEvent) error {
+	// The change log already holds the change; read it without waiting for the next poll
+	s.invalidate(event.ProductId)
+	s.watcher.Wake()
+	return nil
+}
+
+// HandleProductUpdated implements ProductEventHandler interface
+func (s *ProductService) HandleProductUpdated(ctx context.Context, event *events.ProductUpdatedEvent) error {
+	s.invalidate(event.ProductId)
+	s.watcher.Wake()
+	return nil
+}
+
+func (s *ProductService) invalidate(productID string) {
+	if cache, ok := s.repo.(cacheInvalidator); ok {
+		cache.Invalidate(productID)
+	}
+}
+
+func convertProduct(p *model.Product) *productpb.Product {
+	product := &productpb.Product{
+		Id:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       money.ToProto(p.Price),
+		Stock:       p.Stock,
+		Category:    p.Category,
+		Sku:         p.SKU,
+		WeightGrams: p.WeightGrams,
+		MaxPerOrder: p.MaxPerOrder,
+	}
+	if p.DeletedAt.Valid {
+		product.DeletedAt = p.DeletedAt.Time.Format(time.RFC3339)
+	}
+	return product
+}
+
+func convertWarehouse(w *model.Warehouse) *productpb.Warehouse {
+	return &productpb.Warehouse{
+		Id:        w.ID,
+		Name:      w.Name,
+		Region:    w.Region,
+		Latitude:  w.Latitude,
+		Longitude: w.Longitude,
+	}
+}
+
+func convertAllocations(allocations []model.StockAllocation) []*productpb.StockAllocation {
+	var protoAllocations []*productpb.StockAllocation
+	for _, a := range allocations {
+		protoAllocations = append(protoAllocations, &productpb.StockAllocation{
+			WarehouseId: a.WarehouseID,
+			Quantity:    a.Quantity,
+		})
+	}
+	return protoAllocations
+}
+
+// stockError maps inventory errors to gRPC status codes.
+func stockError(message string, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, model.ErrWarehouseNotFound), errors.Is(err, model.ErrReservationNotFound):
+		return status.Errorf(codes.NotFound, "%s: %v", message, err)
+	case errors.Is(err, model.ErrInsufficientStock):
+		return status.Errorf(codes.FailedPrecondition, "%s: %v", message, err)
+	case errors.Is(err, model.ErrInvalidStrategy), errors.Is(err, model.ErrInvalidQuantity):
+		return status.Errorf(codes.InvalidArgument, "%s: %v", message, err)
+	}
+	return status.Errorf(codes.Internal, "%s: %v", message, err)
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	productpb "daprps/api/proto/product"
+	"daprps/internal/product-service/model"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// watchHistorySize is how many recent changes are kept in memory for
+	// resuming watchers, and how many are read from the change log before a
+	// watcher gets a snapshot instead.
+	watchHistorySize = 1024
+	// watchBufferSize bounds the changes queued for a single watcher before it
+	// is considered too slow and resynchronised with a snapshot.
+	watchBufferSize = 64
+	// watchPollInterval is how often the change log is read for changes made
+	// by other replicas when no event announced them.
+	watchPollInterval = time.Second
+	// watchLogRetention is how long the change log is kept.
+	watchLogRetention = 24 * time.Hour
+)
+
+// ProductWatcher fans product changes out to watchers. The repositories
+// record changes in the change log with the writes they describe; its IDs
+// serve as versions and every replica reads them from there, so a watch can
+// resume on any replica and after restarts.
+type ProductWatcher struct {
+	changes model.ChangeRepository
+	wake    chan struct{}
+
+	mu          sync.Mutex
+	version     int64 // ID of the newest change delivered
+	started     bool
+	history     []model.ProductChange
+	subscribers map[*productSubscription]struct{}
+}
+
+type productSubscription struct {
+	changes chan model.ProductChange
+	// overflow is signalled when changes were dropped because the
+	// watcher could not keep up.
+	overflow chan struct{}
+}
+
+func NewProductWatcher(changes model.ChangeRepository) *ProductWatcher {
+	return &ProductWatcher{
+		changes:     changes,
+		wake:        make(chan struct{}, 1),
+		subscribers: make(map[*productSubscription]struct{}),
+	}
+}
+
+// Wake makes Run read the change log now, such as after a write or when
+// another replica announced one.
+func (w *ProductWatcher) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers the changes of the change log to subscribers and prunes the
+// log until ctx is done. Changes recorded before Run started are not
+// delivered.
+func (w *ProductWatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(watchPollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		w.poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-w.wake:
+		case <-prune.C:
+			if _, err := w.changes.Prune(time.Now().Add(-watchLogRetention)); err != nil {
+				log.Printf("Failed to prune product changes: %v", err)
+			}
+		}
+	}
+}
+
+// poll reads new changes from the log and delivers them in ID order.
+func (w *ProductWatcher) poll() {
+	w.mu.Lock()
+	started, version := w.started, w.version
+	w.mu.Unlock()
+
+	if !started {
+		latest, err := w.changes.Latest()
+		if err != nil {
+			log.Printf("Failed to read product change log: %v", err)
+			return
+		}
+		w.mu.Lock()
+		w.version, w.started = latest, true
+		w.mu.Unlock()
+		return
+	}
+
+	changes, err := w.changes.Since(version, watchHistorySize)
+	if err != nil {
+		log.Printf("Failed to read product change log: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, change := range changes {
+		w.deliver(change)
+	}
+}
+
+// deliver records a change and passes it to every subscriber without
+// blocking. w.mu must be held.
+func (w *ProductWatcher) deliver(change model.ProductChange) {
+	w.version = change.ID
+	w.history = append(w.history, change)
+	if len(w.history) > watchHistorySize {
+		w.history = w.history[len(w.history)-watchHistorySize:]
+	}
+
+	for sub := range w.subscribers {
+		select {
+		case sub.changes <- change:
+		default:
+			select {
+			case sub.overflow <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// subscribe registers a watcher and returns the changes after sinceVersion
+// up to the current version, from memory or else from the change log. ok is
+// false when sinceVersion cannot be resumed and the caller has to send a
+// snapshot.
+func (w *ProductWatcher) subscribe(sinceVersion int64) (sub *productSubscription, current int64, missed []model.ProductChange, ok bool) {
+	w.mu.Lock()
+	sub = &productSubscription{
+		changes:  make(chan model.ProductChange, watchBufferSize),
+		overflow: make(chan struct{}, 1),
+	}
+	w.subscribers[sub] = struct{}{}
+	current = w.version
+
+	if sinceVersion <= 0 || sinceVersion > current {
+		w.mu.Unlock()
+		return sub, current, nil, false
+	}
+	if len(w.history) > 0 && w.history[0].ID <= sinceVersion+1 {
+		for _, change := range w.history {
+			if change.ID > sinceVersion {
+				missed = append(missed, change)
+			}
+		}
+		w.mu.Unlock()
+		return sub, current, missed, true
+	}
+	w.mu.Unlock()
+
+	// Changes after current reach the subscription, so only those up to it
+	// are read
+	changes, err := w.changes.Since(sinceVersion, watchHistorySize+1)
+	if err != nil {
+		log.Printf("Failed to read product change log: %v", err)
+		return sub, current, nil, false
+	}
+	for _, change := range changes {
+		if change.ID > current {
+			break
+		}
+		missed = append(missed, change)
+	}
+	if len(missed) > watchHistorySize {
+		return sub, current, nil, false
+	}
+	return sub, current, missed, true
+}
+
+func (w *ProductWatcher) unsubscribe(sub *productSubscription) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.subscribers, sub)
+}
+
+// drain discards queued changes after an overflow and returns the current version.
+func (w *ProductWatcher) drain(sub *productSubscription) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		select {
+		case <-sub.changes:
+		default:
+			return w.version
+		}
+	}
+}
+
+// productWatch holds the filter and delivery state of a single watcher.
+type productWatch struct {
+	repo     model.ProductRepository
+	ids      map[string]bool
+	category string
+	// tracked holds the products already sent to the watcher so deletions
+	// can be reported for category watches.
+	tracked map[string]bool
+	send    func(*productpb.ProductEvent) error
+}
+
+func newProductWatch(repo model.ProductRepository, req *productpb.WatchProductsRequest, send func(*productpb.ProductEvent) error) *productWatch {
+	ids := make(map[string]bool, len(req.ProductIds))
+	for _, id := range req.ProductIds {
+		ids[id] = true
+	}
+	return &productWatch{
+		repo:     repo,
+		ids:      ids,
+		category: req.Category,
+		tracked:  make(map[string]bool),
+		send:     send,
+	}
+}
+
+func (w *productWatch) matches(p *model.Product) bool {
+	return w.ids[p.ID] || (w.category != "" && p.Category == w.category)
+}
+
+func (w *productWatch) sendSnapshot(version int64) error {
+	var products []*model.Product
+	for id := range w.ids {
+		product, err := w.repo.GetByID(id)
+		if err != nil {
+			continue
+		}
+		products = append(products, product)
+	}
+	if w.category != "" {
+		inCategory, err := w.repo.GetAll(w.category, -1, 0)
+		if err != nil {
+			return status.Errorf(codes.Internal, "error listing products: %v", err)
+		}
+		for _, p := range inCategory {
+			if !w.ids[p.ID] {
+				products = append(products, p)
+			}
+		}
+	}
+
+	for _, p := range products {
+		w.tracked[p.ID] = true
+		err := w.send(&productpb.ProductEvent{
+			Type:      "snapshot",
+			ProductId: p.ID,
+			Product:   convertProduct(p),
+			Version:   version,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *productWatch) sendChange(change model.ProductChange) error {
+	if !w.ids[change.ProductID] && w.category == "" {
+		return nil
+	}
+
+	var product *model.Product
+	if !change.Deleted {
+		p, err := w.repo.GetByID(change.ProductID)
+		switch {
+		case err == nil:
+			product = p
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			log.Printf("Failed to load product %s for watchers: %v", change.ProductID, err)
+			return nil
+		}
+	}
+
+	if product == nil {
+		if !w.ids[change.ProductID] && !w.tracked[change.ProductID] {
+			return nil
+		}
+		delete(w.tracked, change.ProductID)
+		return w.send(&productpb.ProductEvent{
+			Type:      "deleted",
+			ProductId: change.ProductID,
+			Version:   change.ID,
+		})
+	}
+
+	if !w.matches(product) {
+		delete(w.tracked, product.ID)
+		return nil
+	}
+	w.tracked[product.ID] = true
+	return w.send(&productpb.ProductEvent{
+		Type:      "updated",
+		ProductId: product.ID,
+		Product:   convertProduct(product),
+		Version:   change.ID,
+	})
+}
@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"encoding/json"
+
+	"daprps/api/proto/events"
+	"daprps/internal/money"
+)
+
+// decodePaymentCompleted decodes a payment completed event. Events published
+// before amounts were recorded in minor units carry the amount and the item
+// prices as bare numbers in major units of money.DefaultCurrency; they are
+// converted to Money objects before decoding.
+func decodePaymentCompleted(data []byte) (*events.PaymentCompletedEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if err := upgradeMoney(fields, "amount"); err != nil {
+		return nil, err
+	}
+	if raw, ok := fields["items"]; ok {
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if err := upgradeMoney(item, "price"); err != nil {
+				return nil, err
+			}
+		}
+		upgraded, err := json.Marshal(items)
+		if err != nil {
+			return nil, err
+		}
+		fields["items"] = upgraded
+	}
+
+	upgraded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var event events.PaymentCompletedEvent
+	if err := json.Unmarshal(upgraded, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// upgradeMoney rewrites the named field in the shape of a Money message,
+// whether it holds a Money object or a bare number. Missing fields and
+// nulls are left alone.
+func upgradeMoney(fields map[string]json.RawMessage, name string) error {
+	raw, ok := fields[name]
+	if !ok || string(raw) == "null" {
+		return nil
+	}
+	var m money.Money
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	upgraded, err := json.Marshal(money.ToProto(m))
+	if err != nil {
+		return err
+	}
+	fields[name] = upgraded
+	return nil
+}
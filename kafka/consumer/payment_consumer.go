@@ -2,7 +2,6 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"os"
 	"strings"
@@ -57,13 +56,13 @@ func (c *PaymentConsumer) Start(ctx context.Context, topics []string) error {
 
 func (c *PaymentConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
-		var event events.PaymentCompletedEvent
-		if err := json.Unmarshal(message.Value, &event); err != nil {
+		event, err := decodePaymentCompleted(message.Value)
+		if err != nil {
 			log.Printf("Error unmarshaling message: %v", err)
 			continue
 		}
 
-		if err := c.handler.HandlePaymentCompleted(session.Context(), &event); err != nil {
+		if err := c.handler.HandlePaymentCompleted(session.Context(), event); err != nil {
 			log.Printf("Error handling payment completed event: %v", err)
 		}
 
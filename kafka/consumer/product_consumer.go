@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"daprps/api/proto/events"
+	"daprps/kafka/publisher"
+
+	"github.com/Shopify/sarama"
+)
+
+// ProductConsumer delivers stock and product update events. Every replica
+// that needs to see all updates must use its own consumer group.
+type ProductConsumer struct {
+	consumer sarama.ConsumerGroup
+	handler  ProductEventHandler
+}
+
+type ProductEventHandler interface {
+	HandleStockUpdated(ctx context.Context, event *events.StockUpdatedEvent) error
+	HandleProductUpdated(ctx context.Context, event *events.ProductUpdatedEvent) error
+}
+
+func NewProductConsumer(handler ProductEventHandler, groupID string) (*ProductConsumer, error) {
+	// Get Kafka brokers from environment variable
+	brokersStr := getEnv("KAFKA_BROKERS", "localhost:9092")
+	brokers := strings.Split(brokersStr, ",")
+
+	config := sarama.NewConfig()
+	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+
+	consumer, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductConsumer{
+		consumer: consumer,
+		handler:  handler,
+	}, nil
+}
+
+// Topics returns the topics this consumer understands.
+func (c *ProductConsumer) Topics() []string {
+	return []string{publisher.StockUpdatedTopic, publisher.ProductUpdatedTopic}
+}
+
+func (c *ProductConsumer) Start(ctx context.Context, topics []string) error {
+	for {
+		err := c.consumer.Consume(ctx, topics, c)
+		if err != nil {
+			log.Printf("Error from consumer: %v", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *ProductConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		switch message.Topic {
+		case publisher.StockUpdatedTopic:
+			var event events.StockUpdatedEvent
+			if err := json.Unmarshal(message.Value, &event); err != nil {
+				log.Printf("Error unmarshaling message: %v", err)
+				break
+			}
+			if err := c.handler.HandleStockUpdated(session.Context(), &event); err != nil {
+				log.Printf("Error handling stock updated event: %v", err)
+			}
+		case publisher.ProductUpdatedTopic:
+			var event events.ProductUpdatedEvent
+			if err := json.Unmarshal(message.Value, &event); err != nil {
+				log.Printf("Error unmarshaling message: %v", err)
+				break
+			}
+			if err := c.handler.HandleProductUpdated(session.Context(), &event); err != nil {
+				log.Printf("Error handling product updated event: %v", err)
+			}
+		default:
+			log.Printf("Ignoring message from unexpected topic %s", message.Topic)
+		}
+
+		session.MarkMessage(message, "")
+	}
+	return nil
+}
+
+func (c *ProductConsumer) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (c *ProductConsumer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (c *ProductConsumer) Close() error {
+	return c.consumer.Close()
+}
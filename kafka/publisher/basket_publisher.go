@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+
+	"daprps/api/proto/events"
+)
+
+// BasketEventsTopic carries every basket event, keyed by user ID, so that
+// consumers see the changes of one basket in order.
+const BasketEventsTopic = "basket-events"
+
+// Types of the events on BasketEventsTopic.
+const (
+	BasketAbandonedType       = "basket_abandoned"
+	BasketRecoveredType       = "basket_recovered"
+	BasketItemAddedType       = "basket_item_added"
+	BasketItemRemovedType     = "basket_item_removed"
+	BasketQuantityChangedType = "basket_quantity_changed"
+	BasketClearedType         = "basket_cleared"
+)
+
+// BasketEvent is a message of BasketEventsTopic. Payload is the JSON-encoded
+// event named by Type.
+type BasketEvent struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type BasketPublisher struct {
+	*producer
+}
+
+func NewBasketPublisher() (*BasketPublisher, error) {
+	producer, err := newProducer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BasketPublisher{
+		producer: producer,
+	}, nil
+}
+
+func (p *BasketPublisher) PublishBasketAbandoned(ctx context.Context, event *events.BasketAbandonedEvent) error {
+	return p.publishEvent(BasketAbandonedType, event.UserId, event)
+}
+
+func (p *BasketPublisher) PublishBasketRecovered(ctx context.Context, event *events.BasketRecoveredEvent) error {
+	return p.publishEvent(BasketRecoveredType, event.UserId, event)
+}
+
+func (p *BasketPublisher) PublishItemAdded(ctx context.Context, event *events.BasketItemAddedEvent) error {
+	return p.publishEvent(BasketItemAddedType, event.UserId, event)
+}
+
+func (p *BasketPublisher) PublishItemRemoved(ctx context.Context, event *events.BasketItemRemovedEvent) error {
+	return p.publishEvent(BasketItemRemovedType, event.UserId, event)
+}
+
+func (p *BasketPublisher) PublishQuantityChanged(ctx context.Context, event *events.BasketQuantityChangedEvent) error {
+	return p.publishEvent(BasketQuantityChangedType, event.UserId, event)
+}
+
+func (p *BasketPublisher) PublishBasketCleared(ctx context.Context, event *events.BasketClearedEvent) error {
+	return p.publishEvent(BasketClearedType, event.UserId, event)
+}
+
+// publishEvent wraps event in a BasketEvent of the given type and publishes
+// it to BasketEventsTopic.
+func (p *BasketPublisher) publishEvent(eventType, userID string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.publish(BasketEventsTopic, userID, BasketEvent{Type: eventType, Payload: payload})
+}
@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// producer sends JSON-encoded events to Kafka. It is shared by the
+// publishers, which only pick the topic and key of each event.
+type producer struct {
+	producer sarama.SyncProducer
+}
+
+func newProducer() (*producer, error) {
+	// Get Kafka brokers from environment variable
+	brokersStr := getEnv("KAFKA_BROKERS", "localhost:9092")
+	brokers := strings.Split(brokersStr, ",")
+
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+
+	syncProducer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &producer{
+		producer: syncProducer,
+	}, nil
+}
+
+func (p *producer) publish(topic, key string, event interface{}) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(eventBytes),
+	}
+
+	partition, offset, err := p.producer.SendMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s event published to partition %d at offset %d", topic, partition, offset)
+	return nil
+}
+
+func (p *producer) Close() error {
+	return p.producer.Close()
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,35 @@
+package publisher
+
+import (
+	"context"
+
+	"daprps/api/proto/events"
+)
+
+const (
+	StockUpdatedTopic   = "stock-updated"
+	ProductUpdatedTopic = "product-updated"
+)
+
+type ProductPublisher struct {
+	*producer
+}
+
+func NewProductPublisher() (*ProductPublisher, error) {
+	producer, err := newProducer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductPublisher{
+		producer: producer,
+	}, nil
+}
+
+func (p *ProductPublisher) PublishStockUpdated(ctx context.Context, event *events.StockUpdatedEvent) error {
+	return p.publish(StockUpdatedTopic, event.ProductId, event)
+}
+
+func (p *ProductPublisher) PublishProductUpdated(ctx context.Context, event *events.ProductUpdatedEvent) error {
+	return p.publish(ProductUpdatedTopic, event.ProductId, event)
+}